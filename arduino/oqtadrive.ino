@@ -895,17 +895,18 @@ void replay() {
 }
 
 /*
-	Get a sector from daemon and immediately reflect it back. For reliability
-	testing. FIXME: validate
+	Get a sector for the given drive from daemon and immediately reflect it
+	back. The daemon compares what it sent with what it receives back. For
+	reliability testing.
  */
-void verify() {
-	daemonCmdArgs(CMD_GET,
-		lowByte(sectorLengthMux), highByte(sectorLengthMux), ' ', 0);
-	daemonRcv(sectorLengthMux);
+void verify(uint8_t drive) {
+	daemonCmdArgs(CMD_GET, drive, 0, 0, 0);
+	uint16_t rcv = daemonRcv(0);
+	if (rcv > PAYLOAD_LENGTH) {
+		rcv = PAYLOAD_LENGTH;
+	}
 	// send back for verification
-	daemonCmdArgs(CMD_VERIFY,
-		lowByte(sectorLengthMux), highByte(sectorLengthMux), ' ',
-		sectorLengthMux);
+	daemonCmdArgs(CMD_VERIFY, lowByte(rcv), highByte(rcv), drive, rcv);
 }
 
 /*
@@ -1041,6 +1042,16 @@ void daemonCheckControl() {
 				remoteConfig(arg1, arg2, arg3);
 				break;
 
+			case CMD_PING: // ping from daemon for measuring round trip time
+				daemonCmd((uint8_t*)DAEMON_PONG);
+				break;
+
+			case CMD_VERIFY:
+				for (; arg2 > 0; arg2--) {
+					verify(arg1);
+				}
+				break;
+
 			case CMD_RESYNC:
 				detectInterface((arg1 & MASK_IF1) != 0, (arg1 & MASK_QL) != 0);
 				synced = false;
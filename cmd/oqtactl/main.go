@@ -33,7 +33,7 @@ var OqtaDriveVersion string
 //
 func synopsis() {
 	fmt.Print(`
-synopsis: oqtactl {serve|load|unload|save|ls|dump|resync|config|version} ...
+synopsis: oqtactl {serve|load|unload|save|ls|dump|diff|check|extract|convert|mkcart|bundle|inspect|cat|screen|map|overlay|snapshot|faults|script|resync|config|diag|version} ...
 
 run 'oqtactl {action} -h|--help' to see detailed info
 
@@ -80,15 +80,57 @@ func main() {
 	case "dump":
 		run.DieOnError(run.NewDump().Execute(args))
 
+	case "diff":
+		run.DieOnError(run.NewDiff().Execute(args))
+
+	case "check":
+		run.DieOnError(run.NewCheck().Execute(args))
+
+	case "extract":
+		run.DieOnError(run.NewExtract().Execute(args))
+
+	case "convert":
+		run.DieOnError(run.NewConvert().Execute(args))
+
+	case "mkcart":
+		run.DieOnError(run.NewMkCart().Execute(args))
+
+	case "bundle":
+		run.DieOnError(run.NewBundle().Execute(args))
+
+	case "inspect":
+		run.DieOnError(run.NewInspect().Execute(args))
+
+	case "cat":
+		run.DieOnError(run.NewCat().Execute(args))
+
+	case "screen":
+		run.DieOnError(run.NewScreen().Execute(args))
+
 	case "map":
 		run.DieOnError(run.NewMap().Execute(args))
 
+	case "overlay":
+		run.DieOnError(run.NewOverlay().Execute(args))
+
+	case "snapshot":
+		run.DieOnError(run.NewSnapshot().Execute(args))
+
+	case "faults":
+		run.DieOnError(run.NewFaults().Execute(args))
+
+	case "script":
+		run.DieOnError(run.NewScript().Execute(args))
+
 	case "resync":
 		run.DieOnError(run.NewResync().Execute(args))
 
 	case "config":
 		run.DieOnError(run.NewConfig().Execute(args))
 
+	case "diag":
+		run.DieOnError(run.NewDiag().Execute(args))
+
 	case "version":
 		version()
 
@@ -23,11 +23,14 @@ package control
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -36,10 +39,17 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/xelalexv/oqtadrive/pkg/daemon"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
 	"github.com/xelalexv/oqtadrive/pkg/microdrive/client"
 	"github.com/xelalexv/oqtadrive/pkg/microdrive/format"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/format/z80"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/if1"
 )
 
+// maximum size of a script in text form
+const maxScriptSize = 65536
+
 //
 type APIServer interface {
 	Serve() error
@@ -75,8 +85,36 @@ func (a *api) Serve() error {
 	addRoute(router, "map", "GET", "/map", a.getDriveMap)
 	addRoute(router, "map", "PUT", "/map", a.setDriveMap)
 	addRoute(router, "drivels", "GET", "/drive/{drive:[1-8]}/list", a.driveList)
+	addRoute(router, "hash", "GET", "/drive/{drive:[1-8]}/hash", a.hashes)
+	addRoute(router, "screen", "GET", "/drive/{drive:[1-8]}/screen", a.screen)
 	addRoute(router, "resync", "PUT", "/resync", a.resync)
 	addRoute(router, "config", "PUT", "/config", a.config)
+	addRoute(router, "verify", "PUT", "/drive/{drive:[1-8]}/verify", a.verify)
+	addRoute(router, "overlay", "GET", "/drive/{drive:[1-8]}/overlay",
+		a.getOverlay)
+	addRoute(router, "overlay", "PUT", "/drive/{drive:[1-8]}/overlay/commit",
+		a.commitOverlay)
+	addRoute(router, "overlay", "PUT", "/drive/{drive:[1-8]}/overlay/discard",
+		a.discardOverlay)
+	addRoute(router, "snapshot", "GET", "/drive/{drive:[1-8]}/snapshot",
+		a.listSnapshots)
+	addRoute(router, "snapshot", "PUT", "/drive/{drive:[1-8]}/snapshot",
+		a.takeSnapshot)
+	addRoute(router, "snapshot", "PUT", "/drive/{drive:[1-8]}/snapshot/restore",
+		a.restoreSnapshot)
+	addRoute(router, "snapshot", "DELETE", "/drive/{drive:[1-8]}/snapshot",
+		a.deleteSnapshot)
+	addRoute(router, "faults", "GET", "/drive/{drive:[1-8]}/faults", a.getFaults)
+	addRoute(router, "faults", "PUT", "/drive/{drive:[1-8]}/faults", a.setFault)
+	addRoute(router, "faults", "DELETE", "/drive/{drive:[1-8]}/faults",
+		a.clearFaults)
+	addRoute(router, "diag", "PUT", "/diag", a.diag)
+	addRoute(router, "script", "GET", "/script", a.getScript)
+	addRoute(router, "script", "PUT", "/script", a.runScript)
+	addRoute(router, "script", "DELETE", "/script", a.stopScript)
+	addRoute(router, "debug", "GET", "/debug", a.debugMessages)
+	addRoute(router, "debugevents", "GET", "/debug/events", a.debugEvents)
+	addRoute(router, "debugtimings", "GET", "/debug/timings", a.debugTimings)
 
 	router.PathPrefix("/").Handler(
 		requestLogger(http.FileServer(http.Dir("./ui/web/")), "webui"))
@@ -255,7 +293,10 @@ func (a *api) getCartridges() []*Cartridge {
 		if c.Status == daemon.StatusIdle {
 			if cart, ok := a.daemon.GetCartridge(drive); cart != nil {
 				c.fill(cart)
+				// hash outside of lock, cartridge may be needed by drive
+				hash := cart.PrepareHash()
 				cart.Unlock()
+				c.Hash = hash()
 			} else if !ok {
 				c.Status = daemon.StatusBusy
 			}
@@ -275,37 +316,93 @@ func (a *api) load(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	reader := getFormat(w, req)
-	if reader == nil {
+	in, err := ioutil.ReadAll(io.LimitReader(req.Body, format.MaxArchiveSize))
+	if handleError(err, http.StatusInternalServerError, w) {
+		return
+	}
+	if handleError(req.Body.Close(), http.StatusInternalServerError, w) {
 		return
 	}
 
-	arg, err := getArg(req, "name")
+	arg, err := getArg(req, "entry")
+	if handleError(err, http.StatusUnprocessableEntity, w) {
+		return
+	}
+	entry, err := format.Unpack(in, arg)
 	if handleError(err, http.StatusUnprocessableEntity, w) {
 		return
 	}
+
+	reader := getFormatForData(w, req, entry.Data, entry.Format())
+	if reader == nil {
+		return
+	}
+
+	if arg, err = getArg(req, "name"); handleError(
+		err, http.StatusUnprocessableEntity, w) {
+		return
+	}
+	if arg == "" && entry.Name != "" {
+		arg = strings.TrimSuffix(
+			strings.ToUpper(path.Base(entry.Name)), ".Z80")
+	}
 	params := map[string]interface{}{"name": arg}
-	cart, err := reader.Read(io.LimitReader(req.Body, 1048576), true,
+
+	sectors := getSectors(w, req)
+	if sectors == -1 {
+		return
+	}
+	params["sectors"] = sectors
+
+	if arg, err = getArg(req, "screen"); handleError(
+		err, http.StatusUnprocessableEntity, w) {
+		return
+	}
+	params["screen"] = arg
+
+	if arg, err = getArg(req, "screendata"); handleError(
+		err, http.StatusUnprocessableEntity, w) {
+		return
+	}
+	if arg != "" {
+		data, err := base64.URLEncoding.DecodeString(arg)
+		if handleError(err, http.StatusUnprocessableEntity, w) {
+			return
+		}
+		params["screenData"] = data
+	}
+
+	cart, err := reader.Read(bytes.NewReader(entry.Data), true,
 		isFlagSet(req, "repair"), params)
 	if err != nil {
 		handleError(fmt.Errorf("cartridge corrupted: %v", err),
 			http.StatusUnprocessableEntity, w)
 		return
 	}
-	if handleError(req.Body.Close(), http.StatusInternalServerError, w) {
-		return
+
+	overlay := isFlagSet(req, "overlay")
+	if overlay {
+		err = a.daemon.LoadOverlay(drive, cart, isFlagSet(req, "force"))
+	} else {
+		err = a.daemon.SetCartridge(drive, cart, isFlagSet(req, "force"))
 	}
 
-	if err := a.daemon.SetCartridge(drive, cart, isFlagSet(req, "force")); err != nil {
+	if err != nil {
 		if strings.Contains(err.Error(), "could not lock") {
 			handleError(fmt.Errorf("drive %d busy", drive), http.StatusLocked, w)
 		} else if strings.Contains(err.Error(), "is modified") {
 			handleError(fmt.Errorf(
 				"cartridge in drive %d is modified", drive), http.StatusConflict, w)
+		} else if strings.Contains(err.Error(), "blank cartridge") {
+			handleError(err, http.StatusUnprocessableEntity, w)
 		} else {
 			handleError(err, http.StatusInternalServerError, w)
 		}
 
+	} else if overlay {
+		sendReply([]byte(fmt.Sprintf(
+			"loaded data into drive %d in overlay mode", drive)), http.StatusOK, w)
+
 	} else {
 		sendReply([]byte(
 			fmt.Sprintf("loaded data into drive %d", drive)), http.StatusOK, w)
@@ -320,7 +417,13 @@ func (a *api) unload(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if err := a.daemon.UnloadCartridge(drive, isFlagSet(req, "force")); err != nil {
+	sectors := getSectors(w, req)
+	if sectors == -1 {
+		return
+	}
+
+	if err := a.daemon.UnloadCartridge(
+		drive, isFlagSet(req, "force"), sectors); err != nil {
 		if strings.Contains(err.Error(), "could not lock") {
 			handleError(fmt.Errorf("drive %d busy", drive), http.StatusLocked, w)
 		} else if strings.Contains(err.Error(), "is modified") {
@@ -359,18 +462,32 @@ func (a *api) save(w http.ResponseWriter, req *http.Request) {
 
 	defer cart.Unlock()
 
-	writer := getFormat(w, req)
+	writer := getFormatOrDefault(w, req, cart.Client().DefaultFormat())
 	if writer == nil {
 		return
 	}
 
+	prefix, err := getArg(req, "prefix")
+	if handleError(err, http.StatusUnprocessableEntity, w) {
+		return
+	}
+
+	variant, err := getArg(req, "variant")
+	if handleError(err, http.StatusUnprocessableEntity, w) {
+		return
+	}
+
 	var out bytes.Buffer
-	if handleError(
-		writer.Write(cart, &out, nil), http.StatusInternalServerError, w) {
+	if handleError(writer.Write(cart, &out,
+		map[string]interface{}{"prefix": prefix, "variant": variant}),
+		http.StatusInternalServerError, w) {
 		return
 	}
 
-	cart.SetModified(false)
+	// when peeking, the cartridge is not considered saved
+	if !isFlagSet(req, "peek") {
+		cart.SetModified(false)
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Write(out.Bytes())
 }
@@ -430,6 +547,108 @@ func (a *api) driveInfo(w http.ResponseWriter, req *http.Request, info string) {
 	sendStreamReply(read, http.StatusOK, w)
 }
 
+// hashes sends the content hashes of the cartridge in a drive and its sectors
+func (a *api) hashes(w http.ResponseWriter, req *http.Request) {
+
+	drive := getDrive(w, req)
+	if drive == -1 {
+		return
+	}
+
+	cart, ok := a.daemon.GetCartridge(drive)
+
+	if !ok {
+		handleError(fmt.Errorf("drive %d busy", drive), http.StatusLocked, w)
+		return
+	}
+
+	if cart == nil {
+		handleError(fmt.Errorf("no cartridge in drive %d", drive),
+			http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	// hashing is done on a copy, so the drive doesn't have to wait for it
+	cp, err := microdrive.CopyCartridge(cart)
+	cart.Unlock()
+	if handleError(err, http.StatusInternalServerError, w) {
+		return
+	}
+
+	hashes := base.ComputeHashes(cp)
+
+	if wantsJSON(req) {
+		sendJSONReply(hashes, http.StatusOK, w)
+		return
+	}
+
+	var buf bytes.Buffer
+	hashes.Emit(&buf)
+	sendReply(buf.Bytes(), http.StatusOK, w)
+}
+
+// screen renders a Spectrum screen file from the cartridge in a drive as PNG
+func (a *api) screen(w http.ResponseWriter, req *http.Request) {
+
+	drive := getDrive(w, req)
+	if drive == -1 {
+		return
+	}
+
+	name, err := getArg(req, "file")
+	if handleError(err, http.StatusUnprocessableEntity, w) {
+		return
+	}
+	if name == "" {
+		handleError(fmt.Errorf("no file name given"),
+			http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	cart, ok := a.daemon.GetCartridge(drive)
+
+	if !ok {
+		handleError(fmt.Errorf("drive %d busy", drive), http.StatusLocked, w)
+		return
+	}
+
+	if cart == nil {
+		handleError(fmt.Errorf("no cartridge in drive %d", drive),
+			http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	defer cart.Unlock()
+
+	if cart.Client() != client.IF1 {
+		handleError(fmt.Errorf("not a Spectrum cartridge in drive %d", drive),
+			http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	f := microdrive.FindFile(cart, name)
+	if f == nil {
+		handleError(fmt.Errorf("file %+q not found", name),
+			http.StatusNotFound, w)
+		return
+	}
+
+	scr, err := z80.GetScreen(f)
+	if handleError(err, http.StatusUnprocessableEntity, w) {
+		return
+	}
+
+	var out bytes.Buffer
+	if handleError(if1.RenderScreen(scr, &out),
+		http.StatusInternalServerError, w) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.WriteHeader(http.StatusOK)
+	w.Write(out.Bytes())
+}
+
 // TODO: JSON response
 func (a *api) getDriveMap(w http.ResponseWriter, req *http.Request) {
 
@@ -533,6 +752,576 @@ func (a *api) config(w http.ResponseWriter, req *http.Request) {
 	sendReply([]byte("configuring"), http.StatusOK, w)
 }
 
+//
+func (a *api) getOverlay(w http.ResponseWriter, req *http.Request) {
+
+	drive := getDrive(w, req)
+	if drive == -1 {
+		return
+	}
+
+	status, err := a.daemon.GetOverlay(drive)
+	if err != nil {
+		if strings.Contains(err.Error(), "busy") {
+			handleError(err, http.StatusLocked, w)
+		} else {
+			handleError(err, http.StatusUnprocessableEntity, w)
+		}
+		return
+	}
+
+	if wantsJSON(req) {
+		sendJSONReply(status, http.StatusOK, w)
+		return
+	}
+
+	if !status.Active {
+		sendReply([]byte(fmt.Sprintf("drive %d is not in overlay mode\n", drive)),
+			http.StatusOK, w)
+		return
+	}
+
+	if status.Delta.IsEmpty() {
+		sendReply([]byte(fmt.Sprintf(
+			"drive %d is in overlay mode, no changes to master '%s'\n",
+			drive, status.Master)), http.StatusOK, w)
+		return
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "drive %d is in overlay mode, changes to master '%s':\n",
+		drive, status.Master)
+	status.Delta.Emit(&buf, "master", "drive")
+	sendReply(buf.Bytes(), http.StatusOK, w)
+}
+
+//
+func (a *api) commitOverlay(w http.ResponseWriter, req *http.Request) {
+
+	drive := getDrive(w, req)
+	if drive == -1 {
+		return
+	}
+
+	if err := a.daemon.CommitOverlay(drive); err != nil {
+		if strings.Contains(err.Error(), "busy") {
+			handleError(err, http.StatusLocked, w)
+		} else {
+			handleError(err, http.StatusUnprocessableEntity, w)
+		}
+		return
+	}
+
+	sendReply([]byte(fmt.Sprintf("committed changes in drive %d to master",
+		drive)), http.StatusOK, w)
+}
+
+//
+func (a *api) discardOverlay(w http.ResponseWriter, req *http.Request) {
+
+	drive := getDrive(w, req)
+	if drive == -1 {
+		return
+	}
+
+	if err := a.daemon.DiscardOverlay(drive); err != nil {
+		if strings.Contains(err.Error(), "could not lock") {
+			handleError(fmt.Errorf("drive %d busy", drive), http.StatusLocked, w)
+		} else {
+			handleError(err, http.StatusUnprocessableEntity, w)
+		}
+		return
+	}
+
+	sendReply([]byte(fmt.Sprintf("discarded changes in drive %d", drive)),
+		http.StatusOK, w)
+}
+
+//
+func (a *api) listSnapshots(w http.ResponseWriter, req *http.Request) {
+
+	drive := getDrive(w, req)
+	if drive == -1 {
+		return
+	}
+
+	list, err := a.daemon.ListSnapshots(drive)
+	if handleError(err, http.StatusUnprocessableEntity, w) {
+		return
+	}
+
+	if wantsJSON(req) {
+		sendJSONReply(list, http.StatusOK, w)
+		return
+	}
+
+	if len(list) == 0 {
+		sendReply([]byte(fmt.Sprintf("no snapshots for drive %d\n", drive)),
+			http.StatusOK, w)
+		return
+	}
+
+	msg := fmt.Sprintf("\nsnapshots for drive %d\n\n", drive)
+	for _, s := range list {
+		msg += fmt.Sprintf("%s\n", s)
+	}
+	sendReply([]byte(msg+"\n"), http.StatusOK, w)
+}
+
+//
+func (a *api) takeSnapshot(w http.ResponseWriter, req *http.Request) {
+
+	drive := getDrive(w, req)
+	if drive == -1 {
+		return
+	}
+
+	name, err := getArg(req, "name")
+	if handleError(err, http.StatusUnprocessableEntity, w) {
+		return
+	}
+
+	info, err := a.daemon.TakeSnapshot(drive, name)
+	if err != nil {
+		if strings.Contains(err.Error(), "busy") {
+			handleError(err, http.StatusLocked, w)
+		} else {
+			handleError(err, http.StatusUnprocessableEntity, w)
+		}
+		return
+	}
+
+	sendReply([]byte(fmt.Sprintf("took snapshot '%s' of drive %d",
+		info.Name, drive)), http.StatusOK, w)
+}
+
+//
+func (a *api) restoreSnapshot(w http.ResponseWriter, req *http.Request) {
+
+	drive := getDrive(w, req)
+	if drive == -1 {
+		return
+	}
+
+	name, err := getArg(req, "name")
+	if handleError(err, http.StatusUnprocessableEntity, w) {
+		return
+	}
+
+	if err := a.daemon.RestoreSnapshot(
+		drive, name, isFlagSet(req, "force")); err != nil {
+		if strings.Contains(err.Error(), "could not lock") {
+			handleError(fmt.Errorf("drive %d busy", drive), http.StatusLocked, w)
+		} else if strings.Contains(err.Error(), "is modified") {
+			handleError(fmt.Errorf(
+				"cartridge in drive %d is modified", drive), http.StatusConflict, w)
+		} else {
+			handleError(err, http.StatusUnprocessableEntity, w)
+		}
+		return
+	}
+
+	sendReply([]byte(fmt.Sprintf("restored drive %d to snapshot '%s'",
+		drive, name)), http.StatusOK, w)
+}
+
+//
+func (a *api) deleteSnapshot(w http.ResponseWriter, req *http.Request) {
+
+	drive := getDrive(w, req)
+	if drive == -1 {
+		return
+	}
+
+	name, err := getArg(req, "name")
+	if handleError(err, http.StatusUnprocessableEntity, w) {
+		return
+	}
+
+	if handleError(a.daemon.DeleteSnapshot(drive, name),
+		http.StatusUnprocessableEntity, w) {
+		return
+	}
+
+	sendReply([]byte(fmt.Sprintf("deleted snapshots of drive %d", drive)),
+		http.StatusOK, w)
+}
+
+//
+func (a *api) getFaults(w http.ResponseWriter, req *http.Request) {
+
+	drive := getDrive(w, req)
+	if drive == -1 {
+		return
+	}
+
+	set, err := a.daemon.GetFaults(drive)
+	if handleError(err, http.StatusUnprocessableEntity, w) {
+		return
+	}
+
+	if wantsJSON(req) {
+		sendJSONReply(set, http.StatusOK, w)
+		return
+	}
+
+	if len(set.Faults) == 0 {
+		sendReply([]byte(fmt.Sprintf("no faults in drive %d\n", drive)),
+			http.StatusOK, w)
+		return
+	}
+
+	msg := fmt.Sprintf("\nfaults in drive %d, seed %d\n\n", drive, set.Seed)
+	for _, f := range set.Faults {
+		msg += fmt.Sprintf("%s\n", f)
+	}
+	sendReply([]byte(msg+"\n"), http.StatusOK, w)
+}
+
+//
+func (a *api) setFault(w http.ResponseWriter, req *http.Request) {
+
+	drive := getDrive(w, req)
+	if drive == -1 {
+		return
+	}
+
+	sector, ok := getFaultSector(w, req)
+	if !ok {
+		return
+	}
+	if sector == nil {
+		handleError(fmt.Errorf("sector not specified"),
+			http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	kind, err := getArg(req, "kind")
+	if handleError(err, http.StatusUnprocessableEntity, w) {
+		return
+	}
+
+	f := &daemon.Fault{Sector: *sector, Kind: kind}
+
+	if arg, _ := getArg(req, "probability"); arg != "" {
+		if f.Probability, err = strconv.ParseFloat(arg, 64); handleError(
+			err, http.StatusUnprocessableEntity, w) {
+			return
+		}
+	}
+
+	var seed *int64
+	if arg, _ := getArg(req, "seed"); arg != "" {
+		s, err := strconv.ParseInt(arg, 10, 64)
+		if handleError(err, http.StatusUnprocessableEntity, w) {
+			return
+		}
+		seed = &s
+	}
+
+	if handleError(a.daemon.SetFault(drive, f, seed),
+		http.StatusUnprocessableEntity, w) {
+		return
+	}
+
+	sendReply([]byte(fmt.Sprintf("drive %d, %s", drive, f)), http.StatusOK, w)
+}
+
+//
+func (a *api) clearFaults(w http.ResponseWriter, req *http.Request) {
+
+	drive := getDrive(w, req)
+	if drive == -1 {
+		return
+	}
+
+	sector, ok := getFaultSector(w, req)
+	if !ok {
+		return
+	}
+
+	if handleError(a.daemon.ClearFaults(drive, sector),
+		http.StatusUnprocessableEntity, w) {
+		return
+	}
+
+	sendReply([]byte(fmt.Sprintf("cleared faults in drive %d", drive)),
+		http.StatusOK, w)
+}
+
+// getFaultSector gets the sector number for a fault given in the request, which
+// is either a number or all. If not specified, nil is returned.
+func getFaultSector(w http.ResponseWriter, req *http.Request) (*int, bool) {
+
+	arg, err := getArg(req, "sector")
+	if handleError(err, http.StatusUnprocessableEntity, w) {
+		return nil, false
+	}
+
+	var ret int
+
+	switch arg {
+	case "":
+		return nil, true
+	case "all":
+		ret = daemon.FaultAllSectors
+	default:
+		if ret, err = strconv.Atoi(arg); handleError(
+			err, http.StatusUnprocessableEntity, w) {
+			return nil, false
+		}
+	}
+
+	return &ret, true
+}
+
+//
+func (a *api) getScript(w http.ResponseWriter, req *http.Request) {
+
+	status := a.daemon.GetScriptStatus()
+
+	if wantsJSON(req) {
+		sendJSONReply(status, http.StatusOK, w)
+		return
+	}
+
+	if status.Started.IsZero() {
+		sendReply([]byte("no script has run yet\n"), http.StatusOK, w)
+		return
+	}
+
+	state := "finished"
+	if status.Running {
+		state = fmt.Sprintf("running, at step %d of %d", status.Step, status.Steps)
+	} else if status.Error != "" {
+		state = "failed"
+	}
+
+	msg := fmt.Sprintf("\nscript started %s, %s\n\n%s\n",
+		status.Started.Format("15:04:05"), state, status.Script)
+	for _, l := range status.Log {
+		msg += fmt.Sprintf("%s\n", l)
+	}
+	sendReply([]byte(msg+"\n"), http.StatusOK, w)
+}
+
+//
+func (a *api) runScript(w http.ResponseWriter, req *http.Request) {
+
+	// scripts with load steps need to be sent as JSON, with the files attached
+	var script *daemon.Script
+	var err error
+
+	if wantsJSON(req) {
+		upload := &daemon.ScriptUpload{}
+		// attached files are base64 encoded
+		limit := maxScriptSize + daemon.MaxScriptFilesSize*4/3 + 65536
+		if handleError(json.NewDecoder(io.LimitReader(req.Body, int64(limit))).
+			Decode(upload), http.StatusUnprocessableEntity, w) {
+			return
+		}
+		if len(upload.Script) > maxScriptSize {
+			handleError(fmt.Errorf("script too large"),
+				http.StatusUnprocessableEntity, w)
+			return
+		}
+		script, err = daemon.ParseScript(strings.NewReader(upload.Script))
+		if err == nil {
+			err = script.AttachFiles(upload.Files)
+		}
+
+	} else {
+		script, err = daemon.ParseScript(io.LimitReader(req.Body, maxScriptSize))
+		if err == nil {
+			err = script.AttachFiles(nil)
+		}
+	}
+
+	if handleError(err, http.StatusUnprocessableEntity, w) {
+		return
+	}
+	if handleError(req.Body.Close(), http.StatusInternalServerError, w) {
+		return
+	}
+
+	if handleError(a.daemon.RunScript(script), http.StatusConflict, w) {
+		return
+	}
+
+	sendReply([]byte(fmt.Sprintf("started script with %d steps",
+		len(script.Steps))), http.StatusOK, w)
+}
+
+//
+func (a *api) stopScript(w http.ResponseWriter, req *http.Request) {
+	if handleError(a.daemon.StopScript(), http.StatusUnprocessableEntity, w) {
+		return
+	}
+	sendReply([]byte("stopping script"), http.StatusOK, w)
+}
+
+//
+func (a *api) verify(w http.ResponseWriter, req *http.Request) {
+
+	drive := getDrive(w, req)
+	if drive == -1 {
+		return
+	}
+
+	count := 1
+	if arg, _ := getArg(req, "count"); arg != "" {
+		var err error
+		if count, err = strconv.Atoi(arg); handleError(
+			err, http.StatusUnprocessableEntity, w) {
+			return
+		}
+	}
+
+	res, err := a.daemon.Verify(drive, count)
+	if handleError(err, http.StatusUnprocessableEntity, w) {
+		return
+	}
+
+	if wantsJSON(req) {
+		sendJSONReply(res, http.StatusOK, w)
+		return
+	}
+
+	failed := 0
+	msg := fmt.Sprintf("\nverified %d sectors in drive %d\n", len(res), drive)
+
+	for _, r := range res {
+		state := "ok"
+		if !r.OK() {
+			state = "FAILED"
+			failed++
+		}
+		msg += fmt.Sprintf("\n  sector %3d: %4d bytes, %4d bit errors  %s",
+			r.Sector, r.Length, r.BitErrors, state)
+	}
+
+	msg += fmt.Sprintf("\n\n%d of %d sectors failed verification\n",
+		failed, len(res))
+	sendReply([]byte(msg), http.StatusOK, w)
+}
+
+//
+func (a *api) diag(w http.ResponseWriter, req *http.Request) {
+
+	drive, err := getIntArg(req, "drive")
+	if err != nil {
+		drive = 1
+	}
+
+	count, err := getIntArg(req, "count")
+	if err != nil {
+		count = 32
+	}
+
+	diag, err := a.daemon.Diagnose(drive, count)
+	if handleError(err, http.StatusUnprocessableEntity, w) {
+		return
+	}
+
+	if wantsJSON(req) {
+		sendJSONReply(diag, http.StatusOK, w)
+	} else {
+		sendReply([]byte(diag.String()), http.StatusOK, w)
+	}
+}
+
+//
+func (a *api) debugMessages(w http.ResponseWriter, req *http.Request) {
+
+	since, err := getIntArg(req, "since")
+	if err != nil || since < 0 {
+		since = 0
+	}
+
+	msgs, _ := a.daemon.GetDebugMessages(uint64(since))
+
+	if wantsJSON(req) {
+		if msgs == nil {
+			msgs = []*daemon.DebugMessage{}
+		}
+		sendJSONReply(msgs, http.StatusOK, w)
+		return
+	}
+
+	var b strings.Builder
+	for _, m := range msgs {
+		fmt.Fprintf(&b, "%s\n", m)
+	}
+	sendReply([]byte(b.String()), http.StatusOK, w)
+}
+
+// debugEvents streams debug messages from the adapter as server-sent events
+func (a *api) debugEvents(w http.ResponseWriter, req *http.Request) {
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		handleError(fmt.Errorf("streaming not supported"),
+			http.StatusInternalServerError, w)
+		return
+	}
+
+	since, err := getIntArg(req, "since")
+	if err != nil || since < 0 {
+		since = 0
+	}
+	seq := uint64(since)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	log.Infof("starting debug event stream for %s", req.RemoteAddr)
+
+	for {
+		msgs, next := a.daemon.GetDebugMessages(seq)
+
+		for _, m := range msgs {
+			data, err := json.Marshal(m)
+			if err != nil {
+				log.Errorf("problem encoding debug message: %v", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", m.Seq, data); err != nil {
+				log.Infof("closing debug event stream for %s: %v",
+					req.RemoteAddr, err)
+				return
+			}
+			seq = m.Seq
+		}
+		flusher.Flush()
+
+		select {
+		case <-next:
+		case <-req.Context().Done():
+			log.Infof("debug event stream for %s closed", req.RemoteAddr)
+			return
+		}
+	}
+}
+
+//
+func (a *api) debugTimings(w http.ResponseWriter, req *http.Request) {
+
+	timings := a.daemon.GetDebugTimings()
+
+	if wantsJSON(req) {
+		sendJSONReply(timings, http.StatusOK, w)
+		return
+	}
+
+	var b strings.Builder
+	for _, t := range timings {
+		fmt.Fprintf(&b, "%s\n", t)
+	}
+	sendReply([]byte(b.String()), http.StatusOK, w)
+}
+
 //
 func getDrive(w http.ResponseWriter, req *http.Request) int {
 	vars := mux.Vars(req)
@@ -543,12 +1332,42 @@ func getDrive(w http.ResponseWriter, req *http.Request) int {
 	return drive
 }
 
+// getSectors gets the number of sectors a cartridge should have as given in
+// the request, 0 if not specified, or -1 if the argument is invalid
+func getSectors(w http.ResponseWriter, req *http.Request) int {
+	arg, err := getArg(req, "sectors")
+	if handleError(err, http.StatusUnprocessableEntity, w) {
+		return -1
+	}
+	if arg == "" {
+		return 0
+	}
+	ret, err := strconv.Atoi(arg)
+	if err == nil && ret < 0 {
+		err = fmt.Errorf("invalid sector count: %d", ret)
+	}
+	if handleError(err, http.StatusUnprocessableEntity, w) {
+		return -1
+	}
+	return ret
+}
+
 //
 func getFormat(w http.ResponseWriter, req *http.Request) format.ReaderWriter {
+	return getFormatOrDefault(w, req, "")
+}
+
+// getFormatOrDefault gets the format given in the request, or the default
+// format if the request does not specify one
+func getFormatOrDefault(w http.ResponseWriter, req *http.Request,
+	def string) format.ReaderWriter {
 	arg, err := getArg(req, "type")
 	if handleError(err, http.StatusUnprocessableEntity, w) {
 		return nil
 	}
+	if arg == "" {
+		arg = def
+	}
 	ret, err := format.NewFormat(arg)
 	if handleError(err, http.StatusUnprocessableEntity, w) {
 		return nil
@@ -556,6 +1375,26 @@ func getFormat(w http.ResponseWriter, req *http.Request) format.ReaderWriter {
 	return ret
 }
 
+// getFormatForData gets the format given in the request, or if the request
+// does not specify one, the format detected from the content of data, with
+// fallback as the format to use if detection is not conclusive
+func getFormatForData(w http.ResponseWriter, req *http.Request,
+	data []byte, fallback string) format.ReaderWriter {
+	arg, err := getArg(req, "type")
+	if handleError(err, http.StatusUnprocessableEntity, w) {
+		return nil
+	}
+	if arg != "" {
+		return getFormat(w, req)
+	}
+	ret, cand, err := format.Detect(data, fallback)
+	if handleError(err, http.StatusUnprocessableEntity, w) {
+		return nil
+	}
+	log.Debugf("detected cartridge format %s", cand)
+	return ret
+}
+
 //
 func isFlagSet(req *http.Request, flag string) bool {
 	arg, _ := getArg(req, flag)
@@ -55,6 +55,7 @@ type Cartridge struct {
 	Formatted      bool   `json:"formatted"`
 	WriteProtected bool   `json:"writeProtected"`
 	Modified       bool   `json:"modified"`
+	Hash           string `json:"hash"`
 }
 
 //
@@ -77,7 +78,8 @@ func (c *Cartridge) equals(o *Cartridge) bool {
 		c.Status == o.Status &&
 		c.Formatted == o.Formatted &&
 		c.WriteProtected == o.WriteProtected &&
-		c.Modified == o.Modified
+		c.Modified == o.Modified &&
+		c.Hash == o.Hash
 }
 
 //
@@ -21,6 +21,7 @@
 package daemon
 
 import (
+	"fmt"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -31,8 +32,17 @@ func (c *command) debug(d *Daemon) error {
 
 	now := time.Now()
 
-	log.Debugf("%c%c %3d  [ %08b ] - %v",
-		c.arg(0), c.arg(1), c.arg(2), c.arg(2), now.Sub(d.debugStart))
+	msg := &DebugMessage{
+		Time:    now,
+		Type:    DebugTypeMessage,
+		Code:    fmt.Sprintf("%c%c", c.arg(0), c.arg(1)),
+		Value:   c.arg(2),
+		Elapsed: now.Sub(d.debugStart),
+	}
+	d.addDebugMessage(msg)
+
+	log.Debugf("%s %3d  [ %08b ] - %v", msg.Code, msg.Value, msg.Value,
+		msg.Elapsed)
 	d.debugStart = now
 
 	return nil
@@ -40,10 +50,34 @@ func (c *command) debug(d *Daemon) error {
 
 //
 func (c *command) timer(start bool, d *Daemon) error {
+
+	now := time.Now()
+	msg := &DebugMessage{Time: now}
+
 	if start {
-		d.debugStart = time.Now()
+		d.debugStart = now
+		msg.Type = DebugTypeTimerStart
 	} else {
-		log.Debugf("%v", time.Now().Sub(d.debugStart))
+		msg.Type = DebugTypeTimerStop
+		msg.Elapsed = now.Sub(d.debugStart)
+		log.Debugf("%v", msg.Elapsed)
 	}
+
+	d.addDebugMessage(msg)
 	return nil
 }
+
+// addDebugMessage adds the message to the debug log, attributing it to the
+// most recent drive operation
+func (d *Daemon) addDebugMessage(m *DebugMessage) {
+	m.Drive = d.debugDrive
+	m.Operation = d.debugOp
+	d.debugLog.add(m)
+}
+
+// setDebugOperation sets the drive operation to which subsequent debug
+// messages are attributed
+func (d *Daemon) setDebugOperation(drive int, op string) {
+	d.debugDrive = drive
+	d.debugOp = op
+}
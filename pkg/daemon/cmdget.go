@@ -34,21 +34,31 @@ func (c *command) get(d *Daemon) error {
 		return err
 	}
 
+	d.setDebugOperation(drive, "get")
+	d.countOp(drive)
+
 	if cart := d.getCartridge(drive); cart != nil {
 
 		sec := cart.GetNextSector()
+		if sec != nil && d.faults.drop(drive, sec.Index()) {
+			sec = nil
+		}
+
 		if err := d.mru.setSector(sec); err != nil {
 			return err
 		}
 
 		if sec != nil {
 			toSend := d.conduit.fillBlock(sec)
+			d.faults.corrupt(drive, sec.Index(),
+				d.conduit.sendBuf[len(sec.Header().Muxed()):toSend])
 
 			log.WithFields(log.Fields{
 				"drive":  drive,
 				"sector": sec.Index(),
 			}).Debugf("GET")
 
+			d.stats.get()
 			d.debugStart = time.Now()
 			d.conduit.send([]byte{byte(toSend), byte(toSend >> 8)})
 
@@ -57,5 +67,6 @@ func (c *command) get(d *Daemon) error {
 	}
 
 	log.WithFields(log.Fields{"drive": drive, "sector": "(nil)"}).Debugf("GET")
+	d.conduit.sent = 0
 	return d.conduit.send([]byte{0, 0})
 }
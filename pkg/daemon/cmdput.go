@@ -21,6 +21,7 @@
 package daemon
 
 import (
+	"errors"
 	"fmt"
 
 	log "github.com/sirupsen/logrus"
@@ -36,6 +37,9 @@ func (c *command) put(d *Daemon) error {
 		return err
 	}
 
+	d.setDebugOperation(drive, "put")
+	d.countOp(drive)
+
 	if c.arg(2) != 0 { // ignore canceled PUT
 		log.WithFields(
 			log.Fields{"drive": drive, "code": c.arg(2)}).Debugf("PUT canceled")
@@ -44,11 +48,17 @@ func (c *command) put(d *Daemon) error {
 
 	data, err := d.conduit.receiveBlock()
 	if err != nil {
+		if errors.Is(err, errCorruptedBlock) {
+			d.stats.corruptedBlock()
+		}
 		return err
 	}
 
+	d.stats.put()
+
 	if len(data) < 200 {
 		if hd, err := microdrive.NewHeader(d.conduit.client, data, true); err != nil {
+			d.stats.badHeader()
 			return fmt.Errorf("error creating header: %v", err)
 		} else if err = d.mru.setHeader(hd); err != nil {
 			return err
@@ -56,6 +66,7 @@ func (c *command) put(d *Daemon) error {
 
 	} else {
 		if rec, err := microdrive.NewRecord(d.conduit.client, data, true); err != nil {
+			d.stats.badRecord()
 			return fmt.Errorf("error creating record: %v", err)
 		} else if err = d.mru.setRecord(rec); err != nil {
 			return err
@@ -74,6 +74,8 @@ func (c *command) status(d *Daemon) error {
 		action = "started"
 	}
 
+	d.setDebugOperation(drive, "status")
+
 	d.mru.reset()
 
 	log.WithFields(log.Fields{
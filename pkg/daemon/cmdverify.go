@@ -0,0 +1,79 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package daemon
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// VerifyResult is the outcome of verifying a single sector that was sent to
+// the adapter for a GET, and reflected back by it.
+type VerifyResult struct {
+	Drive     int `json:"drive"`
+	Sector    int `json:"sector"`
+	Length    int `json:"length"`
+	BitErrors int `json:"bitErrors"`
+}
+
+//
+func (r *VerifyResult) OK() bool {
+	return r.BitErrors == 0
+}
+
+//
+func (c *command) verify(d *Daemon) error {
+
+	res := &VerifyResult{
+		Drive:  int(c.arg(2)),
+		Sector: -1,
+		Length: int(c.arg(0)) | int(c.arg(1))<<8,
+	}
+
+	if d.mru.sector != nil {
+		res.Sector = d.mru.sector.Index()
+	}
+
+	var err error
+	if res.BitErrors, err = d.conduit.verifyBlock(res.Length); err != nil {
+		return err
+	}
+
+	logger := log.WithFields(log.Fields{
+		"drive":      res.Drive,
+		"sector":     res.Sector,
+		"length":     res.Length,
+		"bit errors": res.BitErrors,
+	})
+
+	if res.OK() {
+		logger.Info("VERIFY ok")
+	} else {
+		logger.Warn("VERIFY failed")
+	}
+
+	select {
+	case d.verified <- res:
+	default:
+		log.Debug("verify result discarded")
+	}
+
+	return nil
+}
@@ -23,6 +23,7 @@ package daemon
 import (
 	"bytes"
 	"fmt"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -70,6 +71,7 @@ func (c *command) dispatch(d *Daemon) error {
 
 	case CmdHello:
 		d.synced = false
+		d.stats.sync(false, "adapter sent hello")
 		return nil
 
 	case CmdPing:
@@ -79,6 +81,13 @@ func (c *command) dispatch(d *Daemon) error {
 				return err
 			}
 			d.processControl()
+
+		} else if bytes.Equal(c.data, pong) {
+			select {
+			case d.pongs <- time.Now():
+			default:
+				log.Debug("pong discarded")
+			}
 		}
 		return nil
 
@@ -103,7 +112,8 @@ func (c *command) dispatch(d *Daemon) error {
 	case CmdMap:
 		return c.driveMap(d)
 
-		// case CMD_VERIFY: FIXME
+	case CmdVerify:
+		return c.verify(d)
 	}
 
 	return fmt.Errorf("unknown command: %v", c.data)
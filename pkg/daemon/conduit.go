@@ -22,8 +22,10 @@ package daemon
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"math/bits"
 	"time"
 
 	"github.com/jacobsa/go-serial/serial"
@@ -46,6 +48,9 @@ const stopMarkerLength = 4
 
 const headerFlagIndex = 12
 
+//
+var errCorruptedBlock = errors.New("corrupted block")
+
 //
 var helloDaemon = []byte("hlod")
 var helloIF1 = []byte("hloi")
@@ -65,6 +70,7 @@ type conduit struct {
 	hwGroupLocked bool
 	//
 	sendBuf []byte
+	sent    int
 }
 
 //
@@ -211,6 +217,7 @@ func (c *conduit) fillBlock(s base.Sector) int {
 
 //
 func (c *conduit) sendBlock(length int) error {
+	c.sent = length
 	if _, err := c.port.Write(c.sendBuf[0:length]); err != nil {
 		return fmt.Errorf("error sending block: %v", err)
 	}
@@ -276,7 +283,7 @@ func (c *conduit) receiveBlock() ([]byte, error) {
 
 	if int(shift) > len(stop)-1 {
 		return nil, fmt.Errorf(
-			"corrupted block, excessive stop shift '%d'", shift)
+			"%w, excessive stop shift '%d'", errCorruptedBlock, shift)
 	} else if shift > 0 {
 		if err := c.receive(stop[:shift]); err != nil {
 			return nil, fmt.Errorf("error aligning to block end: %v", err)
@@ -369,34 +376,35 @@ func (c *conduit) remainingBytes(raw []byte) int {
 	}
 }
 
-// FIXME: validate
-func (c *conduit) verifyBlock(expected []byte) {
+/*
+	verifyBlock receives a block of the given length that the adapter reflected
+	back after a GET, and compares it bit by bit with the block that was last
+	sent. Bytes missing on either side count as eight bit errors each. Returned
+	is the number of bit errors.
+*/
+func (c *conduit) verifyBlock(length int) (int, error) {
 
-	//l := c.blockLength()
-	l := 0 // FIXME
-	if l != len(expected) {
-		log.Errorf("length mismatch, want %d, got %d", len(expected), l)
-		return
+	if length > receiveBufferLength {
+		return 0, fmt.Errorf("verify block too long: %d", length)
 	}
 
-	raw := make([]byte, l)
-	if _, err := io.ReadFull(c.port, raw); err != nil {
-		log.Errorf("read error: %v", err)
-		return
+	raw := make([]byte, length)
+	if err := c.receive(raw); err != nil {
+		return 0, fmt.Errorf("error reading verify block: %v", err)
 	}
 
-	errors := 0
-	for ix := range expected {
-		if expected[ix] != raw[ix] {
-			errors++
+	expected := c.sendBuf[:c.sent]
+	bitErrors := 0
+
+	for ix := 0; ix < len(raw) || ix < len(expected); ix++ {
+		if ix >= len(raw) || ix >= len(expected) {
+			bitErrors += 8
+		} else {
+			bitErrors += bits.OnesCount8(raw[ix] ^ expected[ix])
 		}
 	}
 
-	if errors == 0 {
-		log.Info("OK")
-	} else {
-		log.Errorf("NG: %d", errors)
-	}
+	return bitErrors, nil
 }
 
 //
@@ -24,6 +24,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -38,6 +39,13 @@ import (
 //
 const DriveCount = 8
 
+//
+const MaxVerifyCount = 255
+
+// The adapter's serial receive buffer holds 64 bytes, so at most 16 commands
+// can be pending. We stay well below that.
+const MaxPingCount = 8
+
 //
 const StatusEmpty = "empty"
 const StatusIdle = "idle"
@@ -58,10 +66,23 @@ type Daemon struct {
 	//
 	mru        *mru
 	debugStart time.Time
+	debugLog   *debugLog
+	debugDrive int
+	debugOp    string
 	//
 	ctrlRun chan func() error
 	ctrlAck chan error
 	//
+	stats    *stats
+	faults   *faults
+	ops      []uint64
+	script   *scriptRunner
+	snaps    *snapshots
+	overlays *overlays
+	verified chan *VerifyResult
+	pongs    chan time.Time
+	diagLock sync.Mutex
+	//
 	stop chan bool
 }
 
@@ -72,8 +93,17 @@ func NewDaemon(port string, force client.Client) *Daemon {
 		port:        port,
 		forceClient: force,
 		mru:         &mru{},
+		debugLog:    newDebugLog(),
 		ctrlRun:     make(chan func() error),
 		ctrlAck:     make(chan error),
+		stats:       newStats(),
+		faults:      newFaults(),
+		ops:         make([]uint64, DriveCount),
+		script:      &scriptRunner{},
+		snaps:       newSnapshots(),
+		overlays:    newOverlays(),
+		verified:    make(chan *VerifyResult, MaxVerifyCount),
+		pongs:       make(chan time.Time, MaxPingCount),
 		stop:        make(chan bool),
 	}
 }
@@ -128,6 +158,8 @@ func (d *Daemon) listen() error {
 			if cmd, err = d.conduit.receiveCommand(); err != nil {
 				log.Errorf("error receiving command: %v", err)
 				d.synced = false
+				d.stats.sync(false,
+					fmt.Sprintf("error receiving command: %v", err))
 			}
 
 		} else {
@@ -138,6 +170,7 @@ func (d *Daemon) listen() error {
 				log.Errorf("error syncing with adapter: %v", err)
 			} else {
 				d.synced = true
+				d.stats.sync(true, "synced with adapter")
 				for ix := 1; ix <= DriveCount; ix++ {
 					if cart := d.getCartridge(ix); cart != nil {
 						cart.Unlock()
@@ -162,6 +195,8 @@ func (d *Daemon) listen() error {
 			if err = cmd.dispatch(d); err != nil {
 				log.Errorf("error dispatching command: %v", err)
 				d.synced = false
+				d.stats.sync(false,
+					fmt.Sprintf("error dispatching command: %v", err))
 			}
 		}
 	}
@@ -222,7 +257,12 @@ func (d *Daemon) loadCartridges() {
 			log.Errorf(
 				"failed loading auto-saved cartridge for drive %d: %v", ix, err)
 		} else if cart != nil {
-			d.SetCartridge(ix, cart, true)
+			master, err := helper.AutoLoadMaster(ix)
+			if err != nil {
+				log.Errorf(
+					"failed loading auto-saved master for drive %d: %v", ix, err)
+			}
+			d.setCartridgeOverMaster(ix, cart, master, true)
 		}
 	}
 }
@@ -238,20 +278,30 @@ func (d *Daemon) fillEmptyDrives() {
 	}
 }
 
-//
-func (d *Daemon) UnloadCartridge(ix int, force bool) error {
+// UnloadCartridge replaces the cartridge at slot ix (1-based) with a blank
+// one, with room for the given number of sectors. If sectors is 0, the blank
+// cartridge has full length.
+func (d *Daemon) UnloadCartridge(ix int, force bool, sectors int) error {
 	if d.conduit == nil {
 		return fmt.Errorf("nothing to unload")
 	}
-	cart, err := microdrive.NewCartridge(d.conduit.client)
+	cart, err := microdrive.NewCartridgeOfSize(d.conduit.client, sectors)
 	if err != nil {
 		return err
 	}
 	return d.SetCartridge(ix, cart, force)
 }
 
-// SetCartridge sets the cartridge at slot ix (1-based).
+// SetCartridge sets the cartridge at slot ix (1-based). If the drive was in
+// overlay mode, it is taken out of it.
 func (d *Daemon) SetCartridge(ix int, c base.Cartridge, force bool) error {
+	return d.setCartridgeOverMaster(ix, c, nil, force)
+}
+
+// setCartridgeOverMaster sets cartridge c at slot ix (1-based), on top of
+// master cartridge m. If m is nil, the drive is not in overlay mode.
+func (d *Daemon) setCartridgeOverMaster(ix int, c, m base.Cartridge,
+	force bool) error {
 
 	if present, ok := d.GetCartridge(ix); !ok {
 		return fmt.Errorf("could not lock present cartridge")
@@ -262,6 +312,7 @@ func (d *Daemon) SetCartridge(ix int, c base.Cartridge, force bool) error {
 	}
 
 	d.setCartridge(ix, c)
+	d.setMaster(ix, m)
 
 	if c == nil || !c.IsFormatted() {
 		if err := helper.AutoRemove(ix); err != nil {
@@ -277,6 +328,79 @@ func (d *Daemon) SetCartridge(ix int, c base.Cartridge, force bool) error {
 	return nil
 }
 
+// SwapCartridges swaps the cartridges in slots a and b (1-based)
+func (d *Daemon) SwapCartridges(a, b int) error {
+
+	ca, ok := d.GetCartridge(a)
+	if !ok {
+		return fmt.Errorf("could not lock cartridge in drive %d", a)
+	}
+
+	cb, ok := d.GetCartridge(b)
+	if !ok {
+		if ca != nil {
+			ca.Unlock()
+		}
+		return fmt.Errorf("could not lock cartridge in drive %d", b)
+	}
+
+	d.setCartridge(a, cb)
+	d.setCartridge(b, ca)
+
+	ma, mb := d.overlays.get(a), d.overlays.get(b)
+	d.setMaster(a, mb)
+	d.setMaster(b, ma)
+
+	for _, s := range []struct {
+		ix   int
+		cart base.Cartridge
+	}{{a, cb}, {b, ca}} {
+		if s.cart == nil || !s.cart.IsFormatted() {
+			if err := helper.AutoRemove(s.ix); err != nil {
+				log.Errorf("removing auto-save file for drive %d failed: %v",
+					s.ix, err)
+			}
+			continue
+		}
+		s.cart.SetAutoSaved(false)
+		if err := helper.AutoSave(s.ix, s.cart); err != nil {
+			log.Errorf("auto-saving drive %d failed: %v", s.ix, err)
+		}
+	}
+
+	if ca != nil {
+		ca.Unlock()
+	}
+	if cb != nil {
+		cb.Unlock()
+	}
+
+	return nil
+}
+
+// setMaster sets the master cartridge for the drive at slot ix (1-based), and
+// auto-saves it if it changed. A nil master takes the drive out of overlay
+// mode.
+func (d *Daemon) setMaster(ix int, m base.Cartridge) {
+
+	if prev := d.overlays.set(ix, m); prev == m {
+		return
+	}
+
+	if m == nil || !m.IsFormatted() {
+		if err := helper.AutoRemoveMaster(ix); err != nil {
+			log.Errorf("removing master auto-save for drive %d failed: %v",
+				ix, err)
+		}
+		return
+	}
+
+	m.SetAutoSaved(false)
+	if err := helper.AutoSaveMaster(ix, m); err != nil {
+		log.Errorf("auto-saving master for drive %d failed: %v", ix, err)
+	}
+}
+
 //
 func (d *Daemon) setCartridge(ix int, c base.Cartridge) {
 	if 0 < ix && ix <= len(d.cartridges) {
@@ -396,6 +520,7 @@ func (d *Daemon) Resync(cl client.Client, reset bool) error {
 
 	if reset {
 		d.synced = false
+		d.stats.sync(false, "adapter reset requested")
 		if err := d.conduit.close(); err != nil {
 			return err
 		}
@@ -438,6 +563,390 @@ func (d *Daemon) Configure(item string, arg1, arg2 byte) error {
 	})
 }
 
+/*
+	GetDebugMessages gets all debug messages received from the adapter that are
+	still held in the debug log and have a sequence number larger than since.
+	The returned channel gets closed as soon as the next message arrives.
+*/
+func (d *Daemon) GetDebugMessages(since uint64) ([]*DebugMessage, <-chan bool) {
+	return d.debugLog.since(since)
+}
+
+// GetDebugTimings gets the durations measured with the adapter's stop watch,
+// per drive and operation
+func (d *Daemon) GetDebugTimings() []*OpTiming {
+	return d.debugLog.getTimings()
+}
+
+// GetLinkStats gets statistics about the link between daemon and adapter
+func (d *Daemon) GetLinkStats() *LinkStats {
+	return d.stats.snapshot()
+}
+
+// opCount gets the number of sector operations that occurred in the given
+// drive since the daemon started
+func (d *Daemon) opCount(drive int) uint64 {
+	return atomic.LoadUint64(&d.ops[drive-1])
+}
+
+//
+func (d *Daemon) countOp(drive int) {
+	if 0 < drive && drive <= DriveCount {
+		atomic.AddUint64(&d.ops[drive-1], 1)
+	}
+}
+
+// RunScript starts running the given script. Only one script can run at a
+// time.
+func (d *Daemon) RunScript(s *Script) error {
+	return d.script.start(d, s)
+}
+
+// StopScript stops the running script
+func (d *Daemon) StopScript() error {
+	return d.script.cancel()
+}
+
+// GetScriptStatus gets the status of the running script, or the one that ran
+// last
+func (d *Daemon) GetScriptStatus() *ScriptStatus {
+	return d.script.get()
+}
+
+// TakeSnapshot takes a snapshot of the cartridge in the given drive, under
+// the given name. A snapshot with the same name is replaced. If name is empty,
+// the snapshot is named after the current time.
+func (d *Daemon) TakeSnapshot(drive int, name string) (*SnapshotInfo, error) {
+
+	if drive < 1 || drive > DriveCount {
+		return nil, fmt.Errorf("illegal drive number: %d", drive)
+	}
+
+	cart, ok := d.GetCartridge(drive)
+	if !ok {
+		return nil, fmt.Errorf("drive %d busy", drive)
+	}
+	if cart == nil {
+		return nil, fmt.Errorf("no cartridge in drive %d", drive)
+	}
+	defer cart.Unlock()
+
+	cp, err := microdrive.CopyCartridge(cart)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if name == "" {
+		name = now.Format("15:04:05.000")
+	}
+
+	snap := &snapshot{
+		info: SnapshotInfo{
+			Name:      name,
+			Time:      now,
+			Cartridge: cart.Name(),
+			Modified:  cart.IsModified(),
+		},
+		cart: cp,
+	}
+	d.snaps.add(drive, snap)
+
+	info := snap.info
+	return &info, nil
+}
+
+// RestoreSnapshot rolls back the cartridge in the given drive to the named
+// snapshot. If the cartridge in the drive is modified, force is required. The
+// snapshot is kept, so a drive can be rolled back to it several times. If the
+// drive is in overlay mode, it stays in it, with the snapshot becoming the
+// delta layer on top of the current master.
+func (d *Daemon) RestoreSnapshot(drive int, name string, force bool) error {
+
+	if drive < 1 || drive > DriveCount {
+		return fmt.Errorf("illegal drive number: %d", drive)
+	}
+
+	snap := d.snaps.get(drive, name)
+	if snap == nil {
+		return fmt.Errorf("no snapshot '%s' for drive %d", name, drive)
+	}
+
+	cp, err := microdrive.CopyCartridge(snap.cart)
+	if err != nil {
+		return err
+	}
+
+	return d.setCartridgeOverMaster(drive, cp, d.overlays.get(drive), force)
+}
+
+// ListSnapshots lists the snapshots taken for the given drive, oldest first
+func (d *Daemon) ListSnapshots(drive int) ([]*SnapshotInfo, error) {
+	if drive < 1 || drive > DriveCount {
+		return nil, fmt.Errorf("illegal drive number: %d", drive)
+	}
+	return d.snaps.list(drive), nil
+}
+
+// DeleteSnapshot deletes the named snapshot of the given drive, or all of its
+// snapshots if name is empty
+func (d *Daemon) DeleteSnapshot(drive int, name string) error {
+	if drive < 1 || drive > DriveCount {
+		return fmt.Errorf("illegal drive number: %d", drive)
+	}
+	return d.snaps.remove(drive, name)
+}
+
+// LoadOverlay loads a copy of the given master cartridge into the drive at slot
+// ix (1-based), putting the drive into overlay mode. Writes then only go into
+// the copy, i.e. the delta layer, while the master stays untouched.
+func (d *Daemon) LoadOverlay(ix int, master base.Cartridge, force bool) error {
+
+	if ix < 1 || ix > DriveCount {
+		return fmt.Errorf("illegal drive number: %d", ix)
+	}
+
+	if master == nil || !master.IsFormatted() {
+		return fmt.Errorf("cannot use blank cartridge as master")
+	}
+
+	cp, err := microdrive.CopyCartridge(master)
+	if err != nil {
+		return err
+	}
+
+	return d.setCartridgeOverMaster(ix, cp, master, force)
+}
+
+// GetOverlay gets the overlay status of the drive at slot ix (1-based)
+func (d *Daemon) GetOverlay(ix int) (*OverlayStatus, error) {
+
+	if ix < 1 || ix > DriveCount {
+		return nil, fmt.Errorf("illegal drive number: %d", ix)
+	}
+
+	master := d.overlays.get(ix)
+	if master == nil {
+		return &OverlayStatus{}, nil
+	}
+
+	cart, ok := d.GetCartridge(ix)
+	if !ok {
+		return nil, fmt.Errorf("drive %d busy", ix)
+	}
+	if cart == nil {
+		return &OverlayStatus{}, nil
+	}
+	defer cart.Unlock()
+
+	delta, err := base.Compare(master, cart)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OverlayStatus{Active: true, Master: master.Name(), Delta: delta}, nil
+}
+
+// DiscardOverlay discards all changes made to the cartridge in the drive at
+// slot ix (1-based) since it was loaded in overlay mode, or since the last
+// commit, by replacing it with a fresh copy of the master.
+func (d *Daemon) DiscardOverlay(ix int) error {
+
+	if ix < 1 || ix > DriveCount {
+		return fmt.Errorf("illegal drive number: %d", ix)
+	}
+
+	master := d.overlays.get(ix)
+	if master == nil {
+		return fmt.Errorf("drive %d is not in overlay mode", ix)
+	}
+
+	cp, err := microdrive.CopyCartridge(master)
+	if err != nil {
+		return err
+	}
+
+	return d.setCartridgeOverMaster(ix, cp, master, true)
+}
+
+// CommitOverlay merges the changes made to the cartridge in the drive at slot
+// ix (1-based) into its master, i.e. the cartridge in its current state becomes
+// the new master. The drive stays in overlay mode.
+func (d *Daemon) CommitOverlay(ix int) error {
+
+	if ix < 1 || ix > DriveCount {
+		return fmt.Errorf("illegal drive number: %d", ix)
+	}
+
+	if d.overlays.get(ix) == nil {
+		return fmt.Errorf("drive %d is not in overlay mode", ix)
+	}
+
+	cart, ok := d.GetCartridge(ix)
+	if !ok {
+		return fmt.Errorf("drive %d busy", ix)
+	}
+	if cart == nil {
+		return fmt.Errorf("no cartridge in drive %d", ix)
+	}
+	defer cart.Unlock()
+
+	master, err := microdrive.CopyCartridge(cart)
+	if err != nil {
+		return err
+	}
+
+	d.setMaster(ix, master)
+	return nil
+}
+
+// GetFaults gets the sector faults emulated for the given drive
+func (d *Daemon) GetFaults(drive int) (*FaultSet, error) {
+	if drive < 1 || drive > DriveCount {
+		return nil, fmt.Errorf("illegal drive number: %d", drive)
+	}
+	return d.faults.get(drive), nil
+}
+
+// SetFault sets a fault to emulate for a sector in the given drive, replacing
+// any fault already set for that sector. If seed is not nil, the random source
+// deciding when faults occur is re-seeded.
+func (d *Daemon) SetFault(drive int, f *Fault, seed *int64) error {
+	if drive < 1 || drive > DriveCount {
+		return fmt.Errorf("illegal drive number: %d", drive)
+	}
+	return d.faults.set(drive, f, seed)
+}
+
+// ClearFaults stops emulating the fault of a sector in the given drive, or
+// all of the drive's faults if sector is nil
+func (d *Daemon) ClearFaults(drive int, sector *int) error {
+	if drive < 1 || drive > DriveCount {
+		return fmt.Errorf("illegal drive number: %d", drive)
+	}
+	d.faults.clear(drive, sector)
+	return nil
+}
+
+/*
+	Ping sends count pings to the adapter and returns the round trip times of
+	the pongs received back. Since all pings are sent in one go, the time for
+	each but the first pong is measured from when the previous pong arrived.
+	Note that the adapter only answers pings when it is idle, and needs a
+	firmware that supports pings from the daemon.
+*/
+func (d *Daemon) Ping(count int) ([]time.Duration, error) {
+
+	if count < 1 || count > MaxPingCount {
+		return nil, fmt.Errorf("illegal ping count %d (use 1 through %d)",
+			count, MaxPingCount)
+	}
+
+	d.diagLock.Lock()
+	defer d.diagLock.Unlock()
+
+Drain:
+	for { // discard stale pongs from previous pings
+		select {
+		case <-d.pongs:
+		default:
+			break Drain
+		}
+	}
+
+	var sent time.Time
+
+	if err := d.queueControl(func() error {
+		if !d.synced {
+			return fmt.Errorf("not synced with adapter")
+		}
+		sent = time.Now()
+		for ix := 0; ix < count; ix++ {
+			if err := d.conduit.send(ping); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	ret := make([]time.Duration, 0, count)
+
+	for len(ret) < count {
+		select {
+		case t := <-d.pongs:
+			ret = append(ret, t.Sub(sent))
+			sent = t
+		case <-ctx.Done():
+			return ret, fmt.Errorf(
+				"ping timed out, got %d of %d pongs", len(ret), count)
+		}
+	}
+
+	return ret, nil
+}
+
+/*
+	Verify asks the adapter to get count sectors from the cartridge in the given
+	drive, and immediately reflect each of them back. The daemon compares what
+	it sent with what it received back, and reports the results. Note that the
+	adapter only processes this request when it is idle.
+*/
+func (d *Daemon) Verify(drive, count int) ([]*VerifyResult, error) {
+
+	if drive < 1 || drive > DriveCount {
+		return nil, fmt.Errorf("illegal drive number: %d", drive)
+	}
+
+	if count < 1 || count > MaxVerifyCount {
+		return nil, fmt.Errorf("illegal verify count %d (use 1 through %d)",
+			count, MaxVerifyCount)
+	}
+
+	d.diagLock.Lock()
+	defer d.diagLock.Unlock()
+
+Drain:
+	for { // discard stale results from previous verifications
+		select {
+		case <-d.verified:
+		default:
+			break Drain
+		}
+	}
+
+	if err := d.queueControl(func() error {
+		if d.synced {
+			return d.conduit.send(
+				[]byte{CmdVerify, byte(drive), byte(count), 0})
+		}
+		return fmt.Errorf("not synced with adapter")
+	}); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ret := make([]*VerifyResult, 0, count)
+
+	for len(ret) < count {
+		select {
+		case r := <-d.verified:
+			ret = append(ret, r)
+		case <-ctx.Done():
+			return ret, fmt.Errorf(
+				"verification timed out, got %d of %d results", len(ret), count)
+		}
+	}
+
+	return ret, nil
+}
+
 //
 func (d *Daemon) queueControl(f func() error) error {
 
@@ -0,0 +1,192 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package daemon
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+//
+const debugLogSize = 256
+
+//
+const DebugTypeMessage = "message"
+const DebugTypeTimerStart = "timer start"
+const DebugTypeTimerStop = "timer stop"
+
+// DebugMessage is a decoded debug message received from the adapter
+type DebugMessage struct {
+	Seq       uint64        `json:"seq"`
+	Time      time.Time     `json:"time"`
+	Type      string        `json:"type"`
+	Code      string        `json:"code,omitempty"`
+	Value     byte          `json:"value"`
+	Elapsed   time.Duration `json:"elapsed"`
+	Drive     int           `json:"drive,omitempty"`
+	Operation string        `json:"operation,omitempty"`
+}
+
+//
+func (m *DebugMessage) String() string {
+
+	ret := fmt.Sprintf("%6d  %s  %-11s", m.Seq,
+		m.Time.Format("15:04:05.000000"), m.Type)
+
+	switch m.Type {
+	case DebugTypeMessage:
+		ret += fmt.Sprintf("  %-2s %3d [ %08b ]  +%v", m.Code, m.Value, m.Value,
+			m.Elapsed)
+	case DebugTypeTimerStop:
+		ret += fmt.Sprintf("  %v", m.Elapsed)
+	}
+
+	if m.Operation != "" {
+		ret += fmt.Sprintf("  (drive %d, %s)", m.Drive, m.Operation)
+	}
+
+	return ret
+}
+
+// OpTiming holds the durations measured with the adapter's stop watch during
+// a particular operation on a drive.
+type OpTiming struct {
+	Drive     int           `json:"drive"`
+	Operation string        `json:"operation"`
+	Count     int           `json:"count"`
+	Min       time.Duration `json:"min"`
+	Max       time.Duration `json:"max"`
+	Total     time.Duration `json:"total"`
+}
+
+//
+func (t *OpTiming) Average() time.Duration {
+	if t.Count == 0 {
+		return 0
+	}
+	return t.Total / time.Duration(t.Count)
+}
+
+//
+func (t *OpTiming) add(d time.Duration) {
+	if t.Count == 0 || d < t.Min {
+		t.Min = d
+	}
+	if d > t.Max {
+		t.Max = d
+	}
+	t.Count++
+	t.Total += d
+}
+
+//
+func (t *OpTiming) String() string {
+	return fmt.Sprintf("drive %d  %-8s  count: %6d  min: %12v  avg: %12v  max: %12v",
+		t.Drive, t.Operation, t.Count, t.Min, t.Average(), t.Max)
+}
+
+// debugLog is a ring buffer for debug messages received from the adapter
+type debugLog struct {
+	messages []*DebugMessage
+	next     uint64
+	timings  map[string]*OpTiming
+	notify   chan bool
+	lock     sync.Mutex
+}
+
+//
+func newDebugLog() *debugLog {
+	return &debugLog{
+		messages: make([]*DebugMessage, debugLogSize),
+		next:     1,
+		timings:  make(map[string]*OpTiming),
+		notify:   make(chan bool),
+	}
+}
+
+//
+func (l *debugLog) add(m *DebugMessage) {
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	m.Seq = l.next
+	l.messages[m.Seq%debugLogSize] = m
+	l.next++
+
+	if m.Type == DebugTypeTimerStop {
+		key := fmt.Sprintf("%d/%s", m.Drive, m.Operation)
+		t, ok := l.timings[key]
+		if !ok {
+			t = &OpTiming{Drive: m.Drive, Operation: m.Operation}
+			l.timings[key] = t
+		}
+		t.add(m.Elapsed)
+	}
+
+	close(l.notify) // wake up everyone waiting for new messages
+	l.notify = make(chan bool)
+}
+
+// since returns all messages still in the buffer with a sequence number larger
+// than seq, and a channel that gets closed when the next message arrives.
+func (l *debugLog) since(seq uint64) ([]*DebugMessage, <-chan bool) {
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	first := seq + 1
+	if l.next > debugLogSize && first < l.next-debugLogSize {
+		first = l.next - debugLogSize
+	}
+
+	var ret []*DebugMessage
+	for s := first; s < l.next; s++ {
+		m := *l.messages[s%debugLogSize]
+		ret = append(ret, &m)
+	}
+
+	return ret, l.notify
+}
+
+//
+func (l *debugLog) getTimings() []*OpTiming {
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	ret := make([]*OpTiming, 0, len(l.timings))
+	for _, t := range l.timings {
+		c := *t
+		ret = append(ret, &c)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Drive == ret[j].Drive {
+			return ret[i].Operation < ret[j].Operation
+		}
+		return ret[i].Drive < ret[j].Drive
+	})
+
+	return ret
+}
@@ -0,0 +1,215 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package daemon
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// thresholds for diagnosis hints
+const diagMaxPingTime = 5 * time.Millisecond
+const diagMaxLostSyncs = 3
+
+// Diagnosis is the report of a diagnostic session run against the adapter.
+type Diagnosis struct {
+	Client         string          `json:"client"`
+	Drive          int             `json:"drive"`
+	Pings          []time.Duration `json:"pings"`
+	PingError      string          `json:"pingError,omitempty"`
+	Verified       int             `json:"verified"`
+	VerifyFailed   int             `json:"verifyFailed"`
+	VerifyErrors   int             `json:"verifyBitErrors"`
+	VerifyError    string          `json:"verifyError,omitempty"`
+	Stats          *LinkStats      `json:"stats"`
+	Hints          []string        `json:"hints"`
+	Pass           bool            `json:"pass"`
+	sectorFailures []int
+}
+
+/*
+	Diagnose runs a diagnostic session against the adapter. It measures ping
+	round trip times, verifies count sectors from the cartridge in the given
+	drive, and evaluates the link statistics collected so far. The adapter
+	needs to be idle during the session.
+*/
+func (d *Daemon) Diagnose(drive, count int) (*Diagnosis, error) {
+
+	if !d.synced {
+		return nil, fmt.Errorf("not synced with adapter")
+	}
+
+	if start, end, _ := d.GetHardwareDrives(); start <= drive && drive <= end {
+		return nil, fmt.Errorf("drive %d is a hardware drive", drive)
+	}
+
+	if cart := d.getCartridge(drive); cart == nil || !cart.IsFormatted() {
+		return nil, fmt.Errorf("no formatted cartridge in drive %d", drive)
+	}
+
+	ret := &Diagnosis{Client: d.GetClient(), Drive: drive, Pass: true}
+
+	pings, err := d.Ping(MaxPingCount)
+	ret.Pings = pings
+	if err != nil {
+		ret.PingError = err.Error()
+	}
+
+	res, err := d.Verify(drive, count)
+	if err != nil {
+		ret.VerifyError = err.Error()
+	}
+	for _, r := range res {
+		ret.Verified++
+		if !r.OK() {
+			ret.VerifyFailed++
+			ret.VerifyErrors += r.BitErrors
+			ret.sectorFailures = append(ret.sectorFailures, r.Sector)
+		}
+	}
+
+	ret.Stats = d.GetLinkStats()
+	ret.evaluate()
+
+	return ret, nil
+}
+
+//
+func (d *Diagnosis) fail(hint string, params ...interface{}) {
+	d.Pass = false
+	d.hint(hint, params...)
+}
+
+//
+func (d *Diagnosis) hint(hint string, params ...interface{}) {
+	d.Hints = append(d.Hints, fmt.Sprintf(hint, params...))
+}
+
+//
+func (d *Diagnosis) evaluate() {
+
+	if d.PingError != "" {
+		d.fail("adapter did not answer all pings (%s); make sure the adapter "+
+			"firmware is up to date and no drive is running", d.PingError)
+	} else if avg := d.AveragePing(); avg > diagMaxPingTime {
+		d.hint("average ping round trip of %v is high; check USB cable "+
+			"and hubs, and system load on daemon host", avg)
+	}
+
+	if d.VerifyError != "" {
+		d.fail("verification did not complete (%s)", d.VerifyError)
+	}
+
+	if d.VerifyFailed > 0 {
+		d.fail("%d of %d sectors came back with a total of %d bit errors "+
+			"(sectors %v); the serial connection between daemon and adapter "+
+			"is unreliable", d.VerifyFailed, d.Verified, d.VerifyErrors,
+			d.sectorFailures)
+	}
+
+	if d.Stats == nil {
+		return
+	}
+
+	if d.Stats.CorruptedBlocks > 0 {
+		d.fail("%d corrupted blocks were received from the adapter; check "+
+			"wiring of the data lines and the adapter's timing",
+			d.Stats.CorruptedBlocks)
+	}
+
+	if bad := d.Stats.BadHeaders + d.Stats.BadRecords; bad > 0 {
+		d.fail("%d headers and %d records with bad checksums were received "+
+			"while writing; check wiring of the data lines and the adapter's "+
+			"timing", d.Stats.BadHeaders, d.Stats.BadRecords)
+	}
+
+	if lost := d.Stats.LostSyncCount(); lost > diagMaxLostSyncs {
+		d.hint("sync with adapter was lost %d times; check power supply "+
+			"and USB connection of the adapter", lost)
+	}
+}
+
+//
+func (d *Diagnosis) AveragePing() time.Duration {
+	if len(d.Pings) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, p := range d.Pings {
+		sum += p
+	}
+	return sum / time.Duration(len(d.Pings))
+}
+
+//
+func (d *Diagnosis) String() string {
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "\nclient: %s\n", d.Client)
+
+	fmt.Fprintf(&b, "\nping round trips:")
+	for _, p := range d.Pings {
+		fmt.Fprintf(&b, " %v", p)
+	}
+	fmt.Fprintf(&b, "\naverage: %v\n", d.AveragePing())
+
+	fmt.Fprintf(&b, "\nverified %d sectors in drive %d, %d failed, %d bit errors\n",
+		d.Verified, d.Drive, d.VerifyFailed, d.VerifyErrors)
+
+	if d.Stats != nil {
+		fmt.Fprintf(&b, "\nsince %s:\n", d.Stats.Since.Format(time.RFC3339))
+		fmt.Fprintf(&b, "  sectors sent:      %d\n", d.Stats.Gets)
+		fmt.Fprintf(&b, "  blocks received:   %d\n", d.Stats.Puts)
+		fmt.Fprintf(&b, "  corrupted blocks:  %d\n", d.Stats.CorruptedBlocks)
+		fmt.Fprintf(&b, "  bad headers:       %d\n", d.Stats.BadHeaders)
+		fmt.Fprintf(&b, "  bad records:       %d\n", d.Stats.BadRecords)
+		fmt.Fprintf(&b, "  lost syncs:        %d\n", d.Stats.LostSyncCount())
+
+		if len(d.Stats.Syncs) > 0 {
+			fmt.Fprintf(&b, "\nsync history:\n")
+			for _, e := range d.Stats.Syncs {
+				state := "lost  "
+				if e.Synced {
+					state = "synced"
+				}
+				fmt.Fprintf(&b, "  %s  %s  %s\n",
+					e.Time.Format(time.RFC3339), state, e.Reason)
+			}
+		}
+	}
+
+	if len(d.Hints) > 0 {
+		fmt.Fprintf(&b, "\nhints:\n")
+		for _, h := range d.Hints {
+			fmt.Fprintf(&b, "  - %s\n", h)
+		}
+	}
+
+	if d.Pass {
+		fmt.Fprintf(&b, "\nPASS\n")
+	} else {
+		fmt.Fprintf(&b, "\nFAIL\n")
+	}
+
+	return b.String()
+}
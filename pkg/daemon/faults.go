@@ -0,0 +1,241 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+package daemon
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// kinds of emulated sector faults
+const FaultUnreadable = "unreadable"
+const FaultIntermittent = "intermittent"
+const FaultCorrupt = "corrupt"
+
+// FaultAllSectors as sector number makes a fault apply to all sectors of a
+// cartridge that have no fault of their own, emulating a worn tape
+const FaultAllSectors = -1
+
+// Muxed records start with their sync pattern, which is kept intact when
+// corrupting a record. Otherwise the adapter would just not find the record,
+// rather than reading bad data.
+const faultCorruptOffset = 16
+
+// Fault is an emulated defect of a sector. Unreadable sectors are never sent
+// to the Interface 1 or QL, intermittent ones are not sent with the given
+// probability, and corrupt ones get a bit flipped in their record with the
+// given probability.
+type Fault struct {
+	Sector      int     `json:"sector"`
+	Kind        string  `json:"kind"`
+	Probability float64 `json:"probability"`
+}
+
+//
+func (f *Fault) validate() error {
+
+	if f.Sector < FaultAllSectors || f.Sector > 255 {
+		return fmt.Errorf("invalid sector number: %d", f.Sector)
+	}
+
+	switch f.Kind {
+	case FaultUnreadable:
+		f.Probability = 1
+	case FaultIntermittent, FaultCorrupt:
+		if f.Probability <= 0 || f.Probability > 1 {
+			return fmt.Errorf(
+				"probability needs to be greater than 0 and at most 1, got %v",
+				f.Probability)
+		}
+	default:
+		return fmt.Errorf("unknown fault kind: %s", f.Kind)
+	}
+
+	return nil
+}
+
+//
+func (f *Fault) String() string {
+	sector := "all"
+	if f.Sector != FaultAllSectors {
+		sector = fmt.Sprintf("%d", f.Sector)
+	}
+	return fmt.Sprintf("sector %s: %s, probability %.2f",
+		sector, f.Kind, f.Probability)
+}
+
+// FaultSet holds the faults configured for a drive, along with the seed for
+// the random decisions on whether a fault occurs. With the same seed and the
+// same sequence of sector accesses, faults occur in the same way.
+type FaultSet struct {
+	Seed   int64    `json:"seed"`
+	Faults []*Fault `json:"faults"`
+}
+
+//
+func newFaults() *faults {
+	return &faults{drives: make([]*driveFaults, DriveCount)}
+}
+
+//
+type faults struct {
+	drives []*driveFaults
+	lock   sync.Mutex
+}
+
+//
+type driveFaults struct {
+	seed    int64
+	random  *rand.Rand
+	sectors map[int]*Fault
+}
+
+// set adds fault f for drive, replacing any fault already present for the
+// sector. If seed is not nil, the random source of the drive is re-seeded.
+func (fs *faults) set(drive int, f *Fault, seed *int64) error {
+
+	if err := f.validate(); err != nil {
+		return err
+	}
+
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	df := fs.drives[drive-1]
+	if df == nil {
+		df = &driveFaults{sectors: make(map[int]*Fault)}
+		df.reseed(1)
+		fs.drives[drive-1] = df
+	}
+
+	if seed != nil {
+		df.reseed(*seed)
+	}
+
+	df.sectors[f.Sector] = f
+	return nil
+}
+
+// clear removes the fault for the given sector of drive, or all its faults if
+// sector is nil
+func (fs *faults) clear(drive int, sector *int) {
+
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if df := fs.drives[drive-1]; df != nil {
+		if sector == nil {
+			fs.drives[drive-1] = nil
+		} else {
+			delete(df.sectors, *sector)
+		}
+	}
+}
+
+//
+func (fs *faults) get(drive int) *FaultSet {
+
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	ret := &FaultSet{Faults: []*Fault{}}
+
+	if df := fs.drives[drive-1]; df != nil {
+		ret.Seed = df.seed
+		for _, f := range df.sectors {
+			c := *f
+			ret.Faults = append(ret.Faults, &c)
+		}
+		sort.Slice(ret.Faults, func(i, j int) bool {
+			return ret.Faults[i].Sector < ret.Faults[j].Sector
+		})
+	}
+
+	return ret
+}
+
+// drop determines whether the given sector of drive is not to be sent, due to
+// an unreadable or intermittent fault
+func (fs *faults) drop(drive, sector int) bool {
+
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	df := fs.drives[drive-1]
+	if df == nil {
+		return false
+	}
+
+	f := df.lookup(sector)
+	if f == nil || (f.Kind != FaultUnreadable && f.Kind != FaultIntermittent) {
+		return false
+	}
+
+	if df.random.Float64() < f.Probability {
+		log.WithFields(log.Fields{"drive": drive, "sector": sector}).Debugf(
+			"fault: dropping %s sector", f.Kind)
+		return true
+	}
+	return false
+}
+
+// corrupt flips a random bit in record, the muxed record of the given sector
+// of drive about to be sent, if the sector has a corrupt fault
+func (fs *faults) corrupt(drive, sector int, record []byte) {
+
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	df := fs.drives[drive-1]
+	if df == nil || len(record) <= faultCorruptOffset {
+		return
+	}
+
+	f := df.lookup(sector)
+	if f == nil || f.Kind != FaultCorrupt ||
+		df.random.Float64() >= f.Probability {
+		return
+	}
+
+	ix := faultCorruptOffset + df.random.Intn(len(record)-faultCorruptOffset)
+	bit := byte(1 << uint(df.random.Intn(8)))
+	record[ix] ^= bit
+
+	log.WithFields(log.Fields{"drive": drive, "sector": sector}).Debugf(
+		"fault: flipped bit %02x at index %d of record", bit, ix)
+}
+
+//
+func (df *driveFaults) reseed(seed int64) {
+	df.seed = seed
+	df.random = rand.New(rand.NewSource(seed))
+}
+
+//
+func (df *driveFaults) lookup(sector int) *Fault {
+	if f, ok := df.sectors[sector]; ok {
+		return f
+	}
+	return df.sectors[FaultAllSectors]
+}
@@ -0,0 +1,64 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+package daemon
+
+import (
+	"sync"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+)
+
+// OverlayStatus describes whether a drive is in overlay mode, and if so, how
+// the cartridge in the drive differs from the master cartridge underneath
+type OverlayStatus struct {
+	Active bool       `json:"active"`
+	Master string     `json:"master,omitempty"`
+	Delta  *base.Diff `json:"delta,omitempty"`
+}
+
+// overlays keeps the master cartridges of drives in overlay mode. In overlay
+// mode, the cartridge in a drive is a copy of the master, so writes only go
+// into the copy, while the master stays untouched.
+type overlays struct {
+	masters []base.Cartridge
+	lock    sync.Mutex
+}
+
+//
+func newOverlays() *overlays {
+	return &overlays{masters: make([]base.Cartridge, DriveCount)}
+}
+
+// set sets the master cartridge for drive, and returns the previous master;
+// a nil master takes the drive out of overlay mode
+func (o *overlays) set(drive int, master base.Cartridge) base.Cartridge {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	prev := o.masters[drive-1]
+	o.masters[drive-1] = master
+	return prev
+}
+
+//
+func (o *overlays) get(drive int) base.Cartridge {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.masters[drive-1]
+}
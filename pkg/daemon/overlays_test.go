@@ -0,0 +1,185 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/if1"
+)
+
+//
+func TestOverlay(t *testing.T) {
+
+	tempHome(t)
+	d := NewDaemon("", 0)
+	master := overlayMaster(t, d)
+
+	// write to the delta layer, the way a PUT of a record does
+	modifyDrive(d, 1)
+
+	st, err := d.GetOverlay(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Active || st.Delta.IsEmpty() {
+		t.Fatalf("want active overlay with delta, got %+v", st)
+	}
+	if !sameRecords(master, master) || sameRecords(master, d.getCartridge(1)) {
+		t.Errorf("master was modified")
+	}
+
+	if err := d.DiscardOverlay(1); err != nil {
+		t.Fatal(err)
+	}
+	assertNoDelta(t, d, "after discard")
+
+	modifyDrive(d, 1)
+	if err := d.CommitOverlay(1); err != nil {
+		t.Fatal(err)
+	}
+	assertNoDelta(t, d, "after commit")
+	if err := d.DiscardOverlay(1); err != nil {
+		t.Fatal(err)
+	}
+	assertNoDelta(t, d, "after discard following commit")
+	if sameRecords(master, d.getCartridge(1)) {
+		t.Errorf("committed changes lost")
+	}
+
+	// loading without overlay ends overlay mode
+	plain, _ := if1.NewFormattedCartridge("plain")
+	if err := d.SetCartridge(1, plain, true); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := d.GetOverlay(1); st.Active {
+		t.Errorf("overlay mode not ended by load")
+	}
+	if masterSaved(t, 1) {
+		t.Errorf("master auto-save not removed")
+	}
+}
+
+// TestOverlaySnapshot checks that restoring a snapshot keeps overlay mode
+func TestOverlaySnapshot(t *testing.T) {
+
+	tempHome(t)
+	d := NewDaemon("", 0)
+	master := overlayMaster(t, d)
+
+	if _, err := d.TakeSnapshot(1, "clean"); err != nil {
+		t.Fatal(err)
+	}
+	modifyDrive(d, 1)
+	d.getCartridge(1).SetModified(true)
+
+	if err := d.RestoreSnapshot(1, "clean", false); err == nil ||
+		!strings.Contains(err.Error(), "modified") {
+		t.Fatalf("want error for modified cartridge, got %v", err)
+	}
+	if err := d.RestoreSnapshot(1, "clean", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.overlays.get(1) != master {
+		t.Fatalf("restoring snapshot ended overlay mode")
+	}
+	if !masterSaved(t, 1) {
+		t.Errorf("master auto-save removed")
+	}
+	assertNoDelta(t, d, "after restore")
+}
+
+//
+func TestOverlaySwap(t *testing.T) {
+
+	tempHome(t)
+	d := NewDaemon("", 0)
+	master := overlayMaster(t, d)
+
+	if err := d.SwapCartridges(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if d.overlays.get(1) != nil || d.overlays.get(2) != master {
+		t.Errorf("master did not move with cartridge")
+	}
+	if masterSaved(t, 1) || !masterSaved(t, 2) {
+		t.Errorf("master auto-save did not move with cartridge")
+	}
+}
+
+// overlayMaster loads a formatted cartridge into drive 1 in overlay mode, and
+// returns the master
+func overlayMaster(t *testing.T, d *Daemon) base.Cartridge {
+	master, err := if1.NewFormattedCartridge("master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.LoadOverlay(1, master, false); err != nil {
+		t.Fatal(err)
+	}
+	if d.getCartridge(1) == master {
+		t.Fatal("master loaded into drive")
+	}
+	return master
+}
+
+// modifyDrive replaces the record of the first sector of the cartridge in the
+// drive with a modified copy
+func modifyDrive(d *Daemon, drive int) {
+	sec := d.getCartridge(drive).GetSectorAt(0)
+	data := append([]byte{}, sec.Record().Demuxed()...)
+	data[100] ^= 0xff
+	rec, _ := if1.NewRecord(data, false)
+	sec.SetRecord(rec)
+}
+
+//
+func sameRecords(a, b base.Cartridge) bool {
+	for ix := 0; ix < a.SectorCount(); ix++ {
+		sa, sb := a.GetSectorAt(ix), b.GetSectorAt(ix)
+		if string(sa.Record().Content()) != string(sb.Record().Content()) {
+			return false
+		}
+	}
+	return true
+}
+
+//
+func assertNoDelta(t *testing.T, d *Daemon, msg string) {
+	st, err := d.GetOverlay(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Active || !st.Delta.IsEmpty() {
+		t.Errorf("%s: want active overlay without delta, got %+v", msg, st)
+	}
+}
+
+//
+func masterSaved(t *testing.T, drive int) bool {
+	_, err := os.Stat(filepath.Join(
+		os.Getenv("HOME"), ".oqtadrive", string(rune('0'+drive)), "master"))
+	return err == nil
+}
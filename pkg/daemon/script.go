@@ -0,0 +1,543 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/format"
+)
+
+// script triggers
+const TriggerNow = ""
+const TriggerAt = "at"
+const TriggerWait = "wait"
+const TriggerAfter = "after"
+
+// modifier for load and unload actions, for replacing modified cartridges
+const ModifierForce = "force"
+
+// script actions
+const ActionProtect = "protect"
+const ActionLoad = "load"
+const ActionUnload = "unload"
+const ActionSwap = "swap"
+
+//
+const scriptPollInterval = 50 * time.Millisecond
+const scriptLogSize = 64
+
+/*
+	Script is a sequence of steps acting on drives, each executed once its
+	trigger fires. Scripts are given in text form, one step per line:
+
+		[at {duration} | wait {duration} | after {n} ops] [force] {action}
+
+	A step with an at trigger runs once the duration has passed since script
+	start, with a wait trigger once the duration has passed since the previous
+	step, and with an after trigger once n sector operations have occurred in
+	the action's (first) drive since the previous step. Steps without trigger
+	run right after the previous step. Durations are given as e.g. 500ms, 10s,
+	or 2m. Actions are:
+
+		protect {drive} on|off		set/clear write protection
+		load {drive} {file}			load cartridge file into drive
+		unload {drive}				unload drive
+		swap {drive} {drive}		swap cartridges between drives
+
+	Load and unload fail if the cartridge in the drive has been modified, which
+	fails the script. With the force modifier, they replace it regardless, and
+	its changes are lost. The daemon does not read files for load steps itself.
+	Their contents need to be attached to the script, see AttachFiles. Empty
+	lines and lines starting with # are ignored.
+*/
+type Script struct {
+	Steps []*Step
+}
+
+// Step is a single step of a script
+type Step struct {
+	Line    int
+	Trigger string
+	Delay   time.Duration
+	Ops     uint64
+	Action  string
+	Drive   int
+	Other   int
+	Protect bool
+	Force   bool
+	File    string
+	//
+	data []byte
+}
+
+// maximum total size of the cartridge files attached to a script
+const MaxScriptFilesSize = format.MaxArchiveSize
+
+// ScriptUpload is a script in text form, together with the contents of the
+// files its load steps refer to, keyed by file name as given in the script
+type ScriptUpload struct {
+	Script string            `json:"script"`
+	Files  map[string][]byte `json:"files"`
+}
+
+// ParseScript parses a script in text form
+func ParseScript(in io.Reader) (*Script, error) {
+
+	ret := &Script{}
+	scanner := bufio.NewScanner(in)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		step, err := parseStep(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		step.Line = line
+		ret.Steps = append(ret.Steps, step)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ret.Steps) == 0 {
+		return nil, fmt.Errorf("script contains no steps")
+	}
+
+	return ret, nil
+}
+
+// String renders the script in text form; steps are kept at their original
+// line numbers, so that messages referring to lines remain accurate
+func (s *Script) String() string {
+	var ret bytes.Buffer
+	line := 1
+	for _, st := range s.Steps {
+		for ; line < st.Line; line++ {
+			ret.WriteString("\n")
+		}
+		fmt.Fprintf(&ret, "%s\n", st)
+		line++
+	}
+	return ret.String()
+}
+
+// LoadFiles returns the names of the files referred to by load steps, each
+// name only once
+func (s *Script) LoadFiles() []string {
+	var ret []string
+	seen := map[string]bool{}
+	for _, st := range s.Steps {
+		if st.Action == ActionLoad && !seen[st.File] {
+			seen[st.File] = true
+			ret = append(ret, st.File)
+		}
+	}
+	return ret
+}
+
+// AttachFiles attaches the contents of the files referred to by load steps,
+// keyed by file name as given in the script. All files need to be present.
+func (s *Script) AttachFiles(files map[string][]byte) error {
+
+	size := 0
+	for _, data := range files {
+		size += len(data)
+	}
+	if size > MaxScriptFilesSize {
+		return fmt.Errorf("script files too large")
+	}
+
+	for _, st := range s.Steps {
+		if st.Action == ActionLoad {
+			data, ok := files[st.File]
+			if !ok {
+				return fmt.Errorf("line %d: file %s not attached to script",
+					st.Line, st.File)
+			}
+			st.data = data
+		}
+	}
+
+	return nil
+}
+
+//
+func parseStep(text string) (*Step, error) {
+
+	all := strings.Fields(text)
+	fields := all
+	ret := &Step{}
+	var err error
+
+	switch fields[0] {
+
+	case TriggerAt, TriggerWait:
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("missing duration")
+		}
+		if ret.Delay, err = time.ParseDuration(fields[1]); err != nil {
+			return nil, err
+		}
+		ret.Trigger = fields[0]
+		fields = fields[2:]
+
+	case TriggerAfter:
+		if len(fields) < 3 || fields[2] != "ops" {
+			return nil, fmt.Errorf("after trigger needs to be: after {n} ops")
+		}
+		if ret.Ops, err = strconv.ParseUint(fields[1], 10, 32); err != nil {
+			return nil, err
+		}
+		ret.Trigger = TriggerAfter
+		fields = fields[3:]
+	}
+
+	if len(fields) > 0 && fields[0] == ModifierForce {
+		ret.Force = true
+		fields = fields[1:]
+	}
+
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("missing action or drive")
+	}
+
+	ret.Action = fields[0]
+	if ret.Drive, err = parseDrive(fields[1]); err != nil {
+		return nil, err
+	}
+	args := fields[2:]
+
+	switch ret.Action {
+
+	case ActionProtect:
+		if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+			return nil, fmt.Errorf("protect needs on or off")
+		}
+		ret.Protect = args[0] == "on"
+
+	case ActionLoad:
+		if len(args) == 0 {
+			return nil, fmt.Errorf("load needs a file")
+		}
+		// file is the remainder of the line, to allow for blanks in names
+		ret.File = skipFields(text, len(all)-len(args))
+
+	case ActionUnload:
+		if len(args) != 0 {
+			return nil, fmt.Errorf("unload takes no further arguments")
+		}
+
+	case ActionSwap:
+		if len(args) != 1 {
+			return nil, fmt.Errorf("swap needs two drives")
+		}
+		if ret.Other, err = parseDrive(args[0]); err != nil {
+			return nil, err
+		}
+		if ret.Other == ret.Drive {
+			return nil, fmt.Errorf("cannot swap drive %d with itself", ret.Drive)
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown action: %s", ret.Action)
+	}
+
+	if ret.Force && ret.Action != ActionLoad && ret.Action != ActionUnload {
+		return nil, fmt.Errorf("%s cannot be forced", ret.Action)
+	}
+
+	return ret, nil
+}
+
+// skipFields returns what remains of text after skipping its first n fields
+func skipFields(text string, n int) string {
+	for ; n > 0; n-- {
+		text = strings.TrimLeftFunc(text, unicode.IsSpace)
+		text = strings.TrimLeftFunc(text, func(r rune) bool {
+			return !unicode.IsSpace(r)
+		})
+	}
+	return strings.TrimSpace(text)
+}
+
+//
+func parseDrive(s string) (int, error) {
+	ret, err := strconv.Atoi(s)
+	if err != nil || ret < 1 || ret > DriveCount {
+		return 0, fmt.Errorf("invalid drive: %s", s)
+	}
+	return ret, nil
+}
+
+//
+func (s *Step) String() string {
+
+	var ret string
+
+	switch s.Trigger {
+	case TriggerAt, TriggerWait:
+		ret = fmt.Sprintf("%s %v ", s.Trigger, s.Delay)
+	case TriggerAfter:
+		ret = fmt.Sprintf("%s %d ops ", s.Trigger, s.Ops)
+	}
+
+	if s.Force {
+		ret += ModifierForce + " "
+	}
+
+	ret += fmt.Sprintf("%s %d", s.Action, s.Drive)
+
+	switch s.Action {
+	case ActionProtect:
+		if s.Protect {
+			ret += " on"
+		} else {
+			ret += " off"
+		}
+	case ActionLoad:
+		ret += " " + s.File
+	case ActionSwap:
+		ret += fmt.Sprintf(" %d", s.Other)
+	}
+
+	return ret
+}
+
+// due determines whether the step's trigger has fired, given the script start,
+// the time the previous step ran, and the number of sector operations in the
+// step's drive since then
+func (s *Step) due(start, previous time.Time, ops uint64) bool {
+	switch s.Trigger {
+	case TriggerAt:
+		return time.Since(start) >= s.Delay
+	case TriggerWait:
+		return time.Since(previous) >= s.Delay
+	case TriggerAfter:
+		return ops >= s.Ops
+	default:
+		return true
+	}
+}
+
+//
+func (s *Step) run(d *Daemon) error {
+
+	switch s.Action {
+
+	case ActionProtect:
+		cart, ok := d.GetCartridge(s.Drive)
+		if !ok {
+			return fmt.Errorf("drive %d busy", s.Drive)
+		}
+		if cart == nil {
+			return fmt.Errorf("no cartridge in drive %d", s.Drive)
+		}
+		cart.SetWriteProtected(s.Protect)
+		cart.Unlock()
+		return nil
+
+	case ActionLoad:
+		if s.data == nil {
+			return fmt.Errorf("file %s not attached to script", s.File)
+		}
+		entry, err := format.Unpack(s.data, "")
+		if err != nil {
+			return err
+		}
+		ext := strings.TrimPrefix(filepath.Ext(s.File), ".")
+		if entry.Name != "" {
+			ext = entry.Format()
+		}
+		reader, _, err := format.Detect(entry.Data, ext)
+		if err != nil {
+			return err
+		}
+		cart, err := reader.Read(bytes.NewReader(entry.Data), true, false, nil)
+		if err != nil {
+			return err
+		}
+		return d.SetCartridge(s.Drive, cart, s.Force)
+
+	case ActionUnload:
+		return d.UnloadCartridge(s.Drive, s.Force, 0)
+
+	case ActionSwap:
+		return d.SwapCartridges(s.Drive, s.Other)
+	}
+
+	return fmt.Errorf("unknown action: %s", s.Action)
+}
+
+// ScriptStatus describes the state of the script running in the daemon, or
+// the one that ran last
+type ScriptStatus struct {
+	Running bool      `json:"running"`
+	Started time.Time `json:"started"`
+	Step    int       `json:"step"`
+	Steps   int       `json:"steps"`
+	Script  string    `json:"script"`
+	Log     []string  `json:"log"`
+	Error   string    `json:"error,omitempty"`
+}
+
+//
+type scriptRunner struct {
+	status ScriptStatus
+	stop   chan bool
+	lock   sync.Mutex
+}
+
+//
+func (r *scriptRunner) start(d *Daemon, s *Script) error {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.status.Running {
+		return fmt.Errorf("a script is already running")
+	}
+
+	r.status = ScriptStatus{
+		Running: true,
+		Started: time.Now(),
+		Steps:   len(s.Steps),
+		Script:  s.String(),
+		Log:     []string{},
+	}
+	r.stop = make(chan bool, 1)
+
+	go r.run(d, s, r.stop)
+	return nil
+}
+
+//
+func (r *scriptRunner) cancel() error {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !r.status.Running {
+		return fmt.Errorf("no script running")
+	}
+
+	// a stop request may already be pending
+	select {
+	case r.stop <- true:
+	default:
+	}
+	return nil
+}
+
+//
+func (r *scriptRunner) get() *ScriptStatus {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	ret := r.status
+	ret.Log = append([]string{}, r.status.Log...)
+	return &ret
+}
+
+//
+func (r *scriptRunner) run(d *Daemon, s *Script, stop chan bool) {
+
+	start := time.Now()
+	previous := start
+	r.logf("script started")
+
+	for ix, step := range s.Steps {
+
+		r.lock.Lock()
+		r.status.Step = ix + 1
+		r.lock.Unlock()
+
+		ops := d.opCount(step.Drive)
+
+		for !step.due(start, previous, d.opCount(step.Drive)-ops) {
+			select {
+			case <-stop:
+				r.finish("script stopped", nil)
+				return
+			case <-time.After(scriptPollInterval):
+			}
+		}
+
+		if err := step.run(d); err != nil {
+			r.finish("script failed",
+				fmt.Errorf("line %d: %s: %v", step.Line, step, err))
+			return
+		}
+
+		r.logf("line %d: %s", step.Line, step)
+		previous = time.Now()
+	}
+
+	r.finish("script completed", nil)
+}
+
+//
+func (r *scriptRunner) logf(msg string, a ...interface{}) {
+
+	msg = fmt.Sprintf(msg, a...)
+	log.Infof("script: %s", msg)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.status.Log = append(r.status.Log, fmt.Sprintf("%s  %s",
+		time.Now().Format("15:04:05.000"), msg))
+	if len(r.status.Log) > scriptLogSize {
+		r.status.Log = r.status.Log[len(r.status.Log)-scriptLogSize:]
+	}
+}
+
+//
+func (r *scriptRunner) finish(msg string, err error) {
+
+	if err != nil {
+		msg = fmt.Sprintf("%s, %v", msg, err)
+	}
+	r.logf(msg)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.status.Running = false
+	if err != nil {
+		r.status.Error = err.Error()
+	}
+}
@@ -0,0 +1,249 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+package daemon
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/format"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/if1"
+)
+
+//
+func TestParseScript(t *testing.T) {
+
+	s, err := ParseScript(strings.NewReader(`
+# comment
+load 1 my game.mdr
+at 2s force load 2 b.mdr
+after 10 ops force unload 1
+wait 500ms protect 2 on
+swap 1 2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "\n\nload 1 my game.mdr\nat 2s force load 2 b.mdr\n" +
+		"after 10 ops force unload 1\nwait 500ms protect 2 on\nswap 1 2\n"
+	if s.String() != want {
+		t.Errorf("\nwant:\n%q\ngot:\n%q", want, s.String())
+	}
+
+	if s.Steps[0].Force || !s.Steps[1].Force || !s.Steps[2].Force {
+		t.Errorf("force modifier not parsed correctly")
+	}
+
+	// file names that also appear earlier in the line
+	for line, want := range map[string]string{
+		"load 1 a":                            "a",
+		"at 10s load 1 s":                     "s",
+		"load 2 2":                            "2",
+		"after 3 ops force load 3 ops  x.mdr": "ops  x.mdr",
+		"wait 1s\tload 1\t my game.mdr ":      "my game.mdr",
+	} {
+		s, err := ParseScript(strings.NewReader(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Steps[0].File != want {
+			t.Errorf("'%s': want file '%s', got '%s'", line, want, s.Steps[0].File)
+		}
+	}
+
+	for _, line := range []string{
+		"force swap 1 2",
+		"force protect 1 on",
+		"force",
+		"load 9 a.mdr",
+		"swap 1 1",
+		"after 10 load 1 a.mdr",
+		"at x unload 1",
+		"eject 1",
+	} {
+		if _, err := ParseScript(strings.NewReader(line)); err == nil {
+			t.Errorf("want error for '%s'", line)
+		}
+	}
+}
+
+// TestScriptLoadModified checks that load steps only replace a modified
+// cartridge when forced
+func TestScriptLoadModified(t *testing.T) {
+
+	tempHome(t)
+	d := NewDaemon("", 0)
+	cart, err := if1.NewFormattedCartridge("modified")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cart.SetModified(true)
+	if err := d.SetCartridge(1, cart, false); err != nil {
+		t.Fatal(err)
+	}
+
+	step := &Step{Action: ActionLoad, Drive: 1, File: "a.mdr",
+		data: formattedMDR(t)}
+	if err := step.run(d); err == nil ||
+		!strings.Contains(err.Error(), "modified") {
+		t.Errorf("want error for modified cartridge, got %v", err)
+	}
+	if d.getCartridge(1) != cart {
+		t.Errorf("modified cartridge was replaced")
+	}
+
+	step.Force = true
+	if err := step.run(d); err != nil {
+		t.Fatal(err)
+	}
+	if d.getCartridge(1) == cart {
+		t.Errorf("modified cartridge was not replaced")
+	}
+}
+
+// TestScriptAttachFiles checks that load steps get the contents of the files
+// attached to the script, and that the daemon does not read files by itself
+func TestScriptAttachFiles(t *testing.T) {
+
+	dir := tempHome(t)
+
+	file := filepath.Join(dir, "a.mdr")
+	if err := ioutil.WriteFile(file, formattedMDR(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := ParseScript(strings.NewReader(
+		"load 1 b.mdr\nload 2 " + file + "\nload 3 b.mdr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if files := s.LoadFiles(); len(files) != 2 ||
+		files[0] != "b.mdr" || files[1] != file {
+		t.Errorf("unexpected load files: %v", files)
+	}
+
+	err = s.AttachFiles(map[string][]byte{"b.mdr": formattedMDR(t)})
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("want error for missing file, got %v", err)
+	}
+	if err := s.Steps[1].run(NewDaemon("", 0)); err == nil {
+		t.Errorf("daemon read file not attached to script")
+	}
+
+	if err := s.AttachFiles(map[string][]byte{
+		"b.mdr": formattedMDR(t),
+		file:    make([]byte, MaxScriptFilesSize)}); err == nil {
+		t.Errorf("want error for oversized files")
+	}
+
+	if err := s.AttachFiles(map[string][]byte{
+		"b.mdr": formattedMDR(t),
+		file:    formattedMDR(t)}); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDaemon("", 0)
+	for _, st := range s.Steps {
+		if err := st.run(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for drive := 1; drive <= 3; drive++ {
+		if cart := d.getCartridge(drive); cart == nil || !cart.IsFormatted() {
+			t.Errorf("drive %d: cartridge not loaded", drive)
+		}
+	}
+}
+
+// TestScriptStopTwice checks that repeated stop requests do not block
+func TestScriptStopTwice(t *testing.T) {
+
+	tempHome(t)
+	d := NewDaemon("", 0)
+
+	s, err := ParseScript(strings.NewReader("at 1h protect 1 on"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RunScript(s); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan bool)
+	go func() {
+		for ix := 0; ix < 3; ix++ {
+			d.StopScript()
+		}
+		done <- true
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stopping script blocks")
+	}
+
+	for start := time.Now(); d.GetScriptStatus().Running; {
+		if time.Since(start) > 2*time.Second {
+			t.Fatal("script did not stop")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// tempHome points the home directory to a temporary directory, so that
+// auto-saves do not end up in the actual home directory
+func tempHome(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "oqtadrive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	home := os.Getenv("HOME")
+	os.Setenv("HOME", dir)
+	t.Cleanup(func() {
+		os.Setenv("HOME", home)
+		os.RemoveAll(dir)
+	})
+	return dir
+}
+
+//
+func formattedMDR(t *testing.T) []byte {
+	cart, err := if1.NewFormattedCartridge("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fm, err := format.NewFormat("mdr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := fm.Write(cart, &buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,141 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+package daemon
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+)
+
+// maximum number of snapshots kept per drive; when taking more, the oldest
+// snapshot is dropped
+const MaxSnapshots = 16
+
+// SnapshotInfo describes a snapshot of the cartridge in a drive
+type SnapshotInfo struct {
+	Name      string    `json:"name"`
+	Time      time.Time `json:"time"`
+	Cartridge string    `json:"cartridge"`
+	Modified  bool      `json:"modified"`
+}
+
+//
+func (i *SnapshotInfo) String() string {
+	modified := ""
+	if i.Modified {
+		modified = " (modified)"
+	}
+	return fmt.Sprintf("%-16s  %s  %-10s%s", i.Name,
+		i.Time.Format("2006-01-02 15:04:05"), i.Cartridge, modified)
+}
+
+//
+type snapshot struct {
+	info SnapshotInfo
+	cart base.Cartridge
+}
+
+//
+func newSnapshots() *snapshots {
+	return &snapshots{drives: make([][]*snapshot, DriveCount)}
+}
+
+//
+type snapshots struct {
+	drives [][]*snapshot
+	lock   sync.Mutex
+}
+
+// add adds a snapshot for drive, replacing one with the same name
+func (s *snapshots) add(drive int, snap *snapshot) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	list := s.drives[drive-1]
+
+	for ix, sn := range list {
+		if sn.info.Name == snap.info.Name {
+			list = append(list[:ix], list[ix+1:]...)
+			break
+		}
+	}
+
+	list = append(list, snap)
+	if len(list) > MaxSnapshots {
+		list = list[len(list)-MaxSnapshots:]
+	}
+
+	s.drives[drive-1] = list
+}
+
+//
+func (s *snapshots) get(drive int, name string) *snapshot {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, sn := range s.drives[drive-1] {
+		if sn.info.Name == name {
+			return sn
+		}
+	}
+	return nil
+}
+
+//
+func (s *snapshots) list(drive int) []*SnapshotInfo {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ret := []*SnapshotInfo{}
+	for _, sn := range s.drives[drive-1] {
+		info := sn.info
+		ret = append(ret, &info)
+	}
+	return ret
+}
+
+// remove removes the named snapshot of drive, or all of its snapshots if name
+// is empty
+func (s *snapshots) remove(drive int, name string) error {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if name == "" {
+		s.drives[drive-1] = nil
+		return nil
+	}
+
+	list := s.drives[drive-1]
+	for ix, sn := range list {
+		if sn.info.Name == name {
+			s.drives[drive-1] = append(list[:ix], list[ix+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no snapshot '%s' for drive %d", name, drive)
+}
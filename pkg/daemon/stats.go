@@ -0,0 +1,130 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package daemon
+
+import (
+	"sync"
+	"time"
+)
+
+//
+const maxSyncEvents = 32
+
+// LinkStats holds statistics about the quality of the link between daemon and
+// adapter, collected since the daemon started.
+type LinkStats struct {
+	Since           time.Time    `json:"since"`
+	Gets            int          `json:"gets"`
+	Puts            int          `json:"puts"`
+	CorruptedBlocks int          `json:"corruptedBlocks"`
+	BadHeaders      int          `json:"badHeaders"`
+	BadRecords      int          `json:"badRecords"`
+	Syncs           []*SyncEvent `json:"syncs"`
+}
+
+// SyncEvent records when and why the daemon lost or gained sync with the
+// adapter.
+type SyncEvent struct {
+	Time   time.Time `json:"time"`
+	Synced bool      `json:"synced"`
+	Reason string    `json:"reason"`
+}
+
+//
+func (s *LinkStats) LostSyncCount() int {
+	ret := 0
+	for _, e := range s.Syncs {
+		if !e.Synced {
+			ret++
+		}
+	}
+	return ret
+}
+
+//
+func newStats() *stats {
+	return &stats{data: LinkStats{Since: time.Now()}}
+}
+
+//
+type stats struct {
+	data LinkStats
+	lock sync.Mutex
+}
+
+//
+func (s *stats) get() {
+	s.lock.Lock()
+	s.data.Gets++
+	s.lock.Unlock()
+}
+
+//
+func (s *stats) put() {
+	s.lock.Lock()
+	s.data.Puts++
+	s.lock.Unlock()
+}
+
+//
+func (s *stats) corruptedBlock() {
+	s.lock.Lock()
+	s.data.CorruptedBlocks++
+	s.lock.Unlock()
+}
+
+//
+func (s *stats) badHeader() {
+	s.lock.Lock()
+	s.data.BadHeaders++
+	s.lock.Unlock()
+}
+
+//
+func (s *stats) badRecord() {
+	s.lock.Lock()
+	s.data.BadRecords++
+	s.lock.Unlock()
+}
+
+//
+func (s *stats) sync(synced bool, reason string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.Syncs = append(s.data.Syncs,
+		&SyncEvent{Time: time.Now(), Synced: synced, Reason: reason})
+	if len(s.data.Syncs) > maxSyncEvents {
+		s.data.Syncs = s.data.Syncs[len(s.data.Syncs)-maxSyncEvents:]
+	}
+}
+
+// snapshot returns a copy of the current statistics
+func (s *stats) snapshot() *LinkStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ret := s.data
+	ret.Syncs = make([]*SyncEvent, len(s.data.Syncs))
+	for ix, e := range s.data.Syncs {
+		ev := *e
+		ret.Syncs[ix] = &ev
+	}
+	return &ret
+}
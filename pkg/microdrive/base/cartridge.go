@@ -51,6 +51,7 @@ type cartridge struct {
 	accessIx  int
 	modified  bool
 	autosaved bool
+	hashes    hashCache
 	//
 	lock chan bool
 }
@@ -168,7 +169,8 @@ func (c *cartridge) SetSectorAt(ix int, s Sector) {
 	if 0 <= ix && ix < len(c.sectors) {
 		log.Tracef("setting sector at index %d", ix)
 		c.sectors[ix] = s
-		if strings.TrimSpace(s.Name()) != "" {
+		c.hashes.invalidate()
+		if s != nil && strings.TrimSpace(s.Name()) != "" {
 			c.name = s.Name()
 		}
 		c.modified = true
@@ -205,6 +207,7 @@ func (c *cartridge) IsModified() bool {
 //
 func (c *cartridge) SetModified(m bool) {
 	c.modified = m
+	c.hashes.invalidate()
 	if m {
 		c.autosaved = false
 	}
@@ -220,6 +223,35 @@ func (c *cartridge) SetAutoSaved(a bool) {
 	c.autosaved = a
 }
 
+// Hash returns the content hash of this cartridge, see CartridgeHash. Once
+// computed, it is cached until the cartridge changes.
+func (c *cartridge) Hash() string {
+	return c.PrepareHash()()
+}
+
+/*
+	PrepareHash returns a function that computes the content hash of this
+	cartridge, and caches it. Only preparing needs the cartridge lock, the
+	function can be called after unlocking. If the cartridge changes in the
+	meantime, the computed hash is returned but not cached. When the hash is
+	cached already, the function just returns it.
+*/
+func (c *cartridge) PrepareHash() func() string {
+
+	if hash, _, ok := c.hashes.get(); ok {
+		return func() string { return hash }
+	}
+
+	_, gen, _ := c.hashes.get()
+	cont := captureContent(c)
+
+	return func() string {
+		hash := cont.hashes(false).Cartridge
+		c.hashes.set(hash, gen)
+		return hash
+	}
+}
+
 //
 func (c *cartridge) AccessIx() int {
 	return c.accessIx
@@ -0,0 +1,242 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package base
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+//
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+//
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "INFO"
+	case SeverityWarning:
+		return "WARNING"
+	default:
+		return "ERROR"
+	}
+}
+
+// Issue is a problem found when checking a cartridge
+type Issue struct {
+	Severity   Severity `json:"severity"`
+	Sector     int      `json:"sector"`
+	File       string   `json:"file,omitempty"`
+	Message    string   `json:"message"`
+	Repairable bool     `json:"repairable"`
+	Repaired   bool     `json:"repaired"`
+}
+
+//
+func (i *Issue) String() string {
+	ret := fmt.Sprintf("%-8s", i.Severity)
+	if i.Sector > -1 {
+		ret += fmt.Sprintf(" sector %3d:", i.Sector)
+	}
+	if i.File != "" {
+		ret += fmt.Sprintf(" file %+q:", i.File)
+	}
+	ret += " " + i.Message
+	if i.Repaired {
+		ret += " [repaired]"
+	} else if i.Repairable {
+		ret += " [repairable]"
+	}
+	return ret
+}
+
+// CheckReport collects the issues found when checking a cartridge
+type CheckReport struct {
+	Issues []*Issue `json:"issues"`
+}
+
+//
+func NewCheckReport() *CheckReport {
+	return &CheckReport{}
+}
+
+// Add adds an issue for the given sector and file to the report; use -1 and
+// "" respectively if not applicable
+func (r *CheckReport) Add(sev Severity, sector int, file, msg string,
+	params ...interface{}) *Issue {
+	i := &Issue{
+		Severity: sev,
+		Sector:   sector,
+		File:     file,
+		Message:  fmt.Sprintf(msg, params...),
+	}
+	r.Issues = append(r.Issues, i)
+	return i
+}
+
+// AddRepairable adds a repairable issue. If repair is set, fix is called to
+// repair it, and the issue is marked as repaired if that succeeds.
+func (r *CheckReport) AddRepairable(sev Severity, sector int, file string,
+	repair bool, fix func() error, msg string, params ...interface{}) *Issue {
+	i := r.Add(sev, sector, file, msg, params...)
+	i.Repairable = true
+	if repair {
+		if err := fix(); err != nil {
+			i.Message = fmt.Sprintf("%s; repair failed: %v", i.Message, err)
+		} else {
+			i.Repaired = true
+		}
+	}
+	return i
+}
+
+// AddRepaired adds an issue that has already been repaired to the report
+func (r *CheckReport) AddRepaired(sev Severity, sector int, file, msg string,
+	params ...interface{}) *Issue {
+	i := r.Add(sev, sector, file, msg, params...)
+	i.Repairable = true
+	i.Repaired = true
+	return i
+}
+
+// Count returns the number of unrepaired issues with the given severity
+func (r *CheckReport) Count(sev Severity) int {
+	ret := 0
+	for _, i := range r.Issues {
+		if i.Severity == sev && !i.Repaired {
+			ret++
+		}
+	}
+	return ret
+}
+
+//
+func (r *CheckReport) Repaired() int {
+	ret := 0
+	for _, i := range r.Issues {
+		if i.Repaired {
+			ret++
+		}
+	}
+	return ret
+}
+
+// Emit emits the report, most severe issues first
+func (r *CheckReport) Emit(w io.Writer) {
+
+	sort.SliceStable(r.Issues, func(i, j int) bool {
+		return r.Issues[i].Severity > r.Issues[j].Severity
+	})
+
+	fmt.Fprintln(w)
+	for _, i := range r.Issues {
+		fmt.Fprintln(w, i)
+	}
+
+	fmt.Fprintf(w, "\n%d errors, %d warnings, %d repaired\n\n",
+		r.Count(SeverityError), r.Count(SeverityWarning), r.Repaired())
+}
+
+/*
+	CheckSectorNumbers checks that sector numbers of all sectors in the
+	cartridge are within min and max, and not used more than once. It returns
+	the sectors by number.
+*/
+func CheckSectorNumbers(c CartridgeBase, min, max int,
+	r *CheckReport) map[int][]Sector {
+
+	ret := make(map[int][]Sector)
+
+	for ix := 0; ix < c.SectorCount(); ix++ {
+		if sec := c.GetSectorAt(ix); sec != nil {
+			num := sec.Index()
+			if num < min || num > max {
+				r.Add(SeverityError, num, "",
+					"sector number out of range %d through %d", min, max)
+			}
+			ret[num] = append(ret[num], sec)
+		}
+	}
+
+	var dupes []int
+	for num, secs := range ret {
+		if len(secs) > 1 {
+			dupes = append(dupes, num)
+		}
+	}
+	sort.Ints(dupes)
+
+	for _, num := range dupes {
+		r.Add(SeverityError, num, "", "sector number used %d times",
+			len(ret[num]))
+	}
+
+	return ret
+}
+
+/*
+	PrevalentName returns the cartridge name found in most sector headers of the
+	cartridge. This is more robust than taking the cartridge's name, which is
+	determined by the most recently set sector.
+*/
+func PrevalentName(c CartridgeBase) string {
+
+	count := make(map[string]int)
+	for ix := 0; ix < c.SectorCount(); ix++ {
+		if sec := c.GetSectorAt(ix); sec != nil && sec.Header() != nil {
+			count[sec.Header().Name()]++
+		}
+	}
+
+	ret := c.Name()
+	max := 0
+	for n, cnt := range count {
+		if cnt > max || (cnt == max && strings.Compare(n, ret) < 0) {
+			ret = n
+			max = cnt
+		}
+	}
+
+	return ret
+}
+
+// MissingNumbers returns the numbers from 0 through max that are not in nums
+func MissingNumbers(nums []int, max int) []int {
+	seen := make(map[int]bool)
+	for _, n := range nums {
+		seen[n] = true
+	}
+	var ret []int
+	for n := 0; n <= max; n++ {
+		if !seen[n] {
+			ret = append(ret, n)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,248 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package base
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// SectorDiff describes how a sector differs between two cartridges
+type SectorDiff struct {
+	Number        int `json:"number"`
+	HeaderBytes   int `json:"headerBytes"`
+	RecordBytes   int `json:"recordBytes"`
+	FirstRecordIx int `json:"firstRecordIx"`
+}
+
+// FileDiff describes how a file differs between two cartridges
+type FileDiff struct {
+	Name          string `json:"name"`
+	SizeA         int    `json:"sizeA"`
+	SizeB         int    `json:"sizeB"`
+	HeaderChanged bool   `json:"headerChanged"`
+	DataBytes     int    `json:"dataBytes"`
+}
+
+// Diff is the result of comparing two cartridges, A and B
+type Diff struct {
+	SectorsOnlyInA []int         `json:"sectorsOnlyInA"`
+	SectorsOnlyInB []int         `json:"sectorsOnlyInB"`
+	Sectors        []*SectorDiff `json:"sectors"`
+	FilesOnlyInA   []string      `json:"filesOnlyInA"`
+	FilesOnlyInB   []string      `json:"filesOnlyInB"`
+	Files          []*FileDiff   `json:"files"`
+}
+
+/*
+	Compare compares cartridges a and b. Sectors are matched by sector number,
+	so differences in sector order or access position are not reported. Files
+	are matched by name.
+*/
+func Compare(a, b Cartridge) (*Diff, error) {
+
+	if a.Client() != b.Client() {
+		return nil, fmt.Errorf("cannot compare cartridges of different clients")
+	}
+
+	ret := &Diff{}
+	ret.compareSectors(sectorsByNumber(a), sectorsByNumber(b))
+	ret.compareFiles(a.Files(), b.Files())
+
+	return ret, nil
+}
+
+//
+func sectorsByNumber(c Cartridge) map[int]Sector {
+	ret := make(map[int]Sector)
+	for ix := 0; ix < c.SectorCount(); ix++ {
+		if sec := c.GetSectorAt(ix); sec != nil {
+			ret[sec.Index()] = sec
+		}
+	}
+	return ret
+}
+
+//
+func (d *Diff) compareSectors(a, b map[int]Sector) {
+
+	for num, sa := range a {
+		sb, ok := b[num]
+		if !ok {
+			d.SectorsOnlyInA = append(d.SectorsOnlyInA, num)
+			continue
+		}
+
+		hd := countDiffBytes(demuxed(sa.Header()), demuxed(sb.Header()))
+		rd := countDiffBytes(demuxed(sa.Record()), demuxed(sb.Record()))
+
+		if hd > 0 || rd > 0 {
+			d.Sectors = append(d.Sectors, &SectorDiff{
+				Number:        num,
+				HeaderBytes:   hd,
+				RecordBytes:   rd,
+				FirstRecordIx: firstDiff(demuxed(sa.Record()), demuxed(sb.Record())),
+			})
+		}
+	}
+
+	for num := range b {
+		if _, ok := a[num]; !ok {
+			d.SectorsOnlyInB = append(d.SectorsOnlyInB, num)
+		}
+	}
+
+	sort.Ints(d.SectorsOnlyInA)
+	sort.Ints(d.SectorsOnlyInB)
+	sort.Slice(d.Sectors, func(i, j int) bool {
+		return d.Sectors[i].Number < d.Sectors[j].Number
+	})
+}
+
+//
+func (d *Diff) compareFiles(a, b []File) {
+
+	fb := make(map[string]File)
+	for _, f := range b {
+		fb[f.Name()] = f
+	}
+
+	fa := make(map[string]File)
+	for _, f := range a {
+		fa[f.Name()] = f
+		o, ok := fb[f.Name()]
+		if !ok {
+			d.FilesOnlyInA = append(d.FilesOnlyInA, f.Name())
+			continue
+		}
+		hc := !bytes.Equal(f.Header(), o.Header())
+		db := countDiffBytes(f.Data(), o.Data())
+		if hc || db > 0 {
+			d.Files = append(d.Files, &FileDiff{
+				Name:          f.Name(),
+				SizeA:         f.Size(),
+				SizeB:         o.Size(),
+				HeaderChanged: hc,
+				DataBytes:     db,
+			})
+		}
+	}
+
+	for _, f := range b {
+		if _, ok := fa[f.Name()]; !ok {
+			d.FilesOnlyInB = append(d.FilesOnlyInB, f.Name())
+		}
+	}
+}
+
+//
+func (d *Diff) IsEmpty() bool {
+	return len(d.SectorsOnlyInA) == 0 && len(d.SectorsOnlyInB) == 0 &&
+		len(d.Sectors) == 0 && len(d.FilesOnlyInA) == 0 &&
+		len(d.FilesOnlyInB) == 0 && len(d.Files) == 0
+}
+
+// Emit emits the diff in readable form; nameA and nameB are used for
+// referring to the compared cartridges
+func (d *Diff) Emit(w io.Writer, nameA, nameB string) {
+
+	if d.IsEmpty() {
+		fmt.Fprintf(w, "\ncartridges are identical\n\n")
+		return
+	}
+
+	fmt.Fprintf(w, "\n--- %s\n+++ %s\n", nameA, nameB)
+
+	if len(d.FilesOnlyInA)+len(d.FilesOnlyInB)+len(d.Files) > 0 {
+		fmt.Fprintf(w, "\nFILES\n\n")
+		for _, f := range d.FilesOnlyInA {
+			fmt.Fprintf(w, "- %+q\n", f)
+		}
+		for _, f := range d.FilesOnlyInB {
+			fmt.Fprintf(w, "+ %+q\n", f)
+		}
+		for _, f := range d.Files {
+			fmt.Fprintf(w, "~ %+q - size: %d -> %d, %d data bytes differ",
+				f.Name, f.SizeA, f.SizeB, f.DataBytes)
+			if f.HeaderChanged {
+				fmt.Fprint(w, ", header changed")
+			}
+			fmt.Fprintln(w)
+		}
+	}
+
+	if len(d.SectorsOnlyInA)+len(d.SectorsOnlyInB)+len(d.Sectors) > 0 {
+		fmt.Fprintf(w, "\nSECTORS\n\n")
+		if len(d.SectorsOnlyInA) > 0 {
+			fmt.Fprintf(w, "- %v\n", d.SectorsOnlyInA)
+		}
+		if len(d.SectorsOnlyInB) > 0 {
+			fmt.Fprintf(w, "+ %v\n", d.SectorsOnlyInB)
+		}
+		for _, s := range d.Sectors {
+			fmt.Fprintf(w, "~ %3d - header: %d bytes differ, record: %d bytes differ",
+				s.Number, s.HeaderBytes, s.RecordBytes)
+			if s.FirstRecordIx > -1 {
+				fmt.Fprintf(w, " (first at index %d)", s.FirstRecordIx)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+
+	fmt.Fprintln(w)
+}
+
+//
+type demuxer interface {
+	Demuxed() []byte
+}
+
+//
+func demuxed(d demuxer) []byte {
+	if d == nil {
+		return nil
+	}
+	return d.Demuxed()
+}
+
+// countDiffBytes returns the number of differing bytes in a and b; excess
+// bytes in the longer of the two all count as different
+func countDiffBytes(a, b []byte) int {
+	ret := 0
+	for ix := 0; ix < len(a) || ix < len(b); ix++ {
+		if ix >= len(a) || ix >= len(b) || a[ix] != b[ix] {
+			ret++
+		}
+	}
+	return ret
+}
+
+//
+func firstDiff(a, b []byte) int {
+	for ix := 0; ix < len(a) || ix < len(b); ix++ {
+		if ix >= len(a) || ix >= len(b) || a[ix] != b[ix] {
+			return ix
+		}
+	}
+	return -1
+}
@@ -0,0 +1,72 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package base
+
+//
+func NewFile(name string, header, data []byte, sectors []int,
+	complete bool) File {
+	return &file{
+		name:     name,
+		header:   header,
+		data:     data,
+		sectors:  sectors,
+		complete: complete,
+	}
+}
+
+//
+type file struct {
+	name     string
+	header   []byte
+	data     []byte
+	sectors  []int
+	complete bool
+}
+
+//
+func (f *file) Name() string {
+	return f.name
+}
+
+//
+func (f *file) Size() int {
+	return len(f.data)
+}
+
+//
+func (f *file) Header() []byte {
+	return f.header
+}
+
+//
+func (f *file) Data() []byte {
+	return f.data
+}
+
+//
+func (f *file) Sectors() []int {
+	return f.sectors
+}
+
+//
+func (f *file) IsComplete() bool {
+	return f.complete
+}
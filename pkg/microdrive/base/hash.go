@@ -0,0 +1,215 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+package base
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/client"
+)
+
+/*
+	SectorHash returns the content hash of sector s, as a hex string. Only the
+	content of header and record is considered, so the hash does not change
+	with sync patterns, checksums, or padding, and is the same regardless of
+	the file format a sector was read from.
+*/
+func SectorHash(s Sector) string {
+	return hex.EncodeToString(
+		contentHash(contentOf(s.Header()), contentOf(s.Record())))
+}
+
+/*
+	CartridgeHash returns the content hash of cartridge c, as a hex string. It
+	is computed from the hashes of all its sectors in order of sector number,
+	so it does not depend on access index, the position of the sectors on the
+	tape, or the number of blank slots. Two cartridges with the same hash hold
+	the same content. Cartridges also provide their hash via Hash, which is
+	cached.
+*/
+func CartridgeHash(c CartridgeBase) string {
+	return captureContent(c).hashes(false).Cartridge
+}
+
+// HashedSector is the content hash of a sector
+type HashedSector struct {
+	Number int    `json:"number"`
+	Hash   string `json:"hash"`
+}
+
+// Hashes are the content hashes of a cartridge and its sectors; sectors are
+// ordered by sector number
+type Hashes struct {
+	Cartridge string          `json:"cartridge"`
+	Sectors   []*HashedSector `json:"sectors"`
+}
+
+// ComputeHashes computes the content hashes of cartridge c and its sectors
+func ComputeHashes(c CartridgeBase) *Hashes {
+	return captureContent(c).hashes(true)
+}
+
+// Emit emits the hashes in readable form
+func (h *Hashes) Emit(w io.Writer) {
+	fmt.Fprintf(w, "\ncontent hash: %s\n\nSECTOR  HASH\n", h.Cartridge)
+	for _, s := range h.Sectors {
+		fmt.Fprintf(w, "   %3d  %s\n", s.Number, s.Hash)
+	}
+	fmt.Fprintln(w)
+}
+
+/*
+	content holds copies of the header and record contents of a cartridge, so
+	that its hash can be computed without holding the cartridge lock. Headers
+	and records may get changed in place, e.g. during repair, so referencing
+	them would not be safe.
+*/
+type content struct {
+	client  client.Client
+	sectors []*sectorContent
+}
+
+//
+type sectorContent struct {
+	number int
+	header []byte
+	record []byte
+}
+
+//
+func captureContent(c CartridgeBase) *content {
+	ret := &content{client: c.Client()}
+	for ix := 0; ix < c.SectorCount(); ix++ {
+		if sec := c.GetSectorAt(ix); sec != nil {
+			ret.sectors = append(ret.sectors, &sectorContent{
+				number: sec.Index(),
+				header: append([]byte{}, contentOf(sec.Header())...),
+				record: append([]byte{}, contentOf(sec.Record())...),
+			})
+		}
+	}
+	return ret
+}
+
+// hashes computes the cartridge hash, and if withSectors is set, includes the
+// sector hashes in the result
+func (c *content) hashes(withSectors bool) *Hashes {
+
+	type entry struct {
+		number int
+		hash   []byte
+	}
+
+	entries := make([]entry, len(c.sectors))
+	for ix, s := range c.sectors {
+		entries[ix] = entry{s.number, contentHash(s.header, s.record)}
+	}
+
+	// sectors with duplicate numbers are ordered by hash for a stable result
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].number != entries[j].number {
+			return entries[i].number < entries[j].number
+		}
+		return bytes.Compare(entries[i].hash, entries[j].hash) < 0
+	})
+
+	ret := &Hashes{}
+	h := sha256.New()
+	h.Write([]byte{byte(c.client)})
+
+	for _, e := range entries {
+		h.Write(e.hash)
+		if withSectors {
+			ret.Sectors = append(ret.Sectors, &HashedSector{
+				Number: e.number, Hash: hex.EncodeToString(e.hash)})
+		}
+	}
+
+	ret.Cartridge = hex.EncodeToString(h.Sum(nil))
+	return ret
+}
+
+//
+func contentHash(header, record []byte) []byte {
+
+	h := sha256.New()
+
+	// length prefixes keep header and record content apart
+	for _, data := range [][]byte{header, record} {
+		h.Write([]byte{byte(len(data)), byte(len(data) >> 8)})
+		h.Write(data)
+	}
+
+	return h.Sum(nil)
+}
+
+//
+type contenter interface {
+	Content() []byte
+}
+
+//
+func contentOf(c contenter) []byte {
+	if c == nil {
+		return nil
+	}
+	return c.Content()
+}
+
+// hashCache caches the content hash of a cartridge. Each change to the
+// cartridge starts a new generation, and a hash is only cached if it was
+// computed from the content of the current generation.
+type hashCache struct {
+	lock  sync.Mutex
+	gen   uint64
+	valid bool
+	hash  string
+}
+
+//
+func (h *hashCache) invalidate() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.gen++
+	h.valid = false
+}
+
+//
+func (h *hashCache) get() (string, uint64, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.hash, h.gen, h.valid
+}
+
+//
+func (h *hashCache) set(hash string, gen uint64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if gen == h.gen {
+		h.hash = hash
+		h.valid = true
+	}
+}
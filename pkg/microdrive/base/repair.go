@@ -0,0 +1,110 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package base
+
+/*
+	ReassignSectorNumbers makes sure each sector in the cartridge has a unique
+	sector number within min and max. Sectors are visited in slot order, and
+	the first sector using a valid number keeps it. The remaining sectors get
+	unused numbers, preferring the number that follows from the preceding
+	sector, or are dropped if no more numbers are available. set is called for
+	changing the number of a sector.
+*/
+func ReassignSectorNumbers(c CartridgeBase, min, max int,
+	set func(s Sector, num int) error, r *CheckReport) {
+
+	used := make(map[int]bool)
+	var reassign []int
+	cmp := 0
+	last := -1
+
+	for ix := 0; ix < c.SectorCount(); ix++ {
+		sec := c.GetSectorAt(ix)
+		if sec == nil {
+			continue
+		}
+		num := sec.Index()
+		if num < min || num > max || used[num] {
+			reassign = append(reassign, ix)
+			continue
+		}
+		used[num] = true
+		if last > -1 {
+			if num > last {
+				cmp++
+			} else {
+				cmp--
+			}
+		}
+		last = num
+	}
+
+	// direction in which sector numbers change from slot to slot
+	step := 1
+	if cmp < 0 {
+		step = -1
+	}
+
+	for _, ix := range reassign {
+
+		sec := c.GetSectorAt(ix)
+		old := sec.Index()
+
+		want := min
+		for p := ix - 1; p >= 0; p-- {
+			if prev := c.GetSectorAt(p); prev != nil {
+				want = prev.Index() + step
+				break
+			}
+		}
+
+		num := findUnused(used, want, step, min, max)
+		if num < 0 {
+			c.SetSectorAt(ix, nil)
+			r.AddRepaired(SeverityError, old, "",
+				"dropped sector, no unused sector number left")
+			continue
+		}
+
+		if err := set(sec, num); err != nil {
+			r.Add(SeverityError, old, "",
+				"cannot change sector number to %d: %v", num, err)
+			continue
+		}
+
+		used[num] = true
+		r.AddRepaired(SeverityWarning, old, "",
+			"changed duplicate or invalid sector number to %d", num)
+	}
+}
+
+// findUnused finds an unused number in the range min through max, starting
+// at want and moving in direction step, wrapping around; -1 if none left
+func findUnused(used map[int]bool, want, step, min, max int) int {
+	size := max - min + 1
+	for n := 0; n < size; n++ {
+		num := min + ((want-min+n*step)%size+size)%size
+		if !used[num] {
+			return num
+		}
+	}
+	return -1
+}
@@ -33,6 +33,22 @@ type Cartridge interface {
 	CartridgeBase
 
 	List(w io.Writer)
+
+	// Files returns the files stored on the cartridge. Files for which not
+	// all records could be found are included, but marked as incomplete.
+	Files() []File
+
+	// Check checks the logical structure of the cartridge and reports issues
+	// found. If repair is set, issues that can be safely repaired are fixed.
+	Check(repair bool) *CheckReport
+
+	// Reconstruct tries to repair structural damage of the cartridge, such as
+	// lost headers and duplicate sector numbers, and reports what was done.
+	Reconstruct() *CheckReport
+
+	// AddFile adds a file with given file system header and data to the
+	// cartridge. The header is client specific, see File.Header.
+	AddFile(name string, header, data []byte) error
 }
 
 //
@@ -93,6 +109,14 @@ type CartridgeBase interface {
 
 	SetAutoSaved(a bool)
 
+	// Hash returns the content hash of the cartridge; it is cached until the
+	// cartridge changes
+	Hash() string
+
+	// PrepareHash returns a function for computing the content hash of the
+	// cartridge, which can be called without holding the cartridge lock
+	PrepareHash() func() string
+
 	AccessIx() int
 
 	AdvanceAccessIx(skipEmpty bool) int
@@ -102,6 +126,30 @@ type CartridgeBase interface {
 	Emit(w io.Writer)
 }
 
+//
+type File interface {
+
+	// Name returns the name of the file, as stored on the cartridge
+	Name() string
+
+	// Size returns the size of the file's content
+	Size() int
+
+	// Header returns the file system header of the file, if any; for IF1 this
+	// is the 9 byte header of SAVEd files, for QL the 64 byte file header
+	Header() []byte
+
+	// Data returns the content of the file, without file system header
+	Data() []byte
+
+	// Sectors returns the numbers of the sectors holding the file's records,
+	// in record order
+	Sectors() []int
+
+	// IsComplete determines whether all records of the file were found
+	IsComplete() bool
+}
+
 //
 type Sector interface {
 	//
@@ -131,6 +179,10 @@ type Header interface {
 	// Demuxed returns the plain data bytes of the header
 	Demuxed() []byte
 
+	// Content returns the bytes that make up the content of the header, i.e.
+	// without sync pattern and checksum; used for content hashing
+	Content() []byte
+
 	//
 	Flags() byte
 
@@ -159,6 +211,10 @@ type Record interface {
 	// Demuxed returns the plain data bytes of the record
 	Demuxed() []byte
 
+	// Content returns the bytes that make up the content of the record, i.e.
+	// without sync patterns, checksums, and padding; used for content hashing
+	Content() []byte
+
 	//
 	Flags() byte
 
@@ -168,6 +224,9 @@ type Record interface {
 	//
 	Length() int
 
+	// Data returns the data section of the record
+	Data() []byte
+
 	// Name returns the name of the record, if applicable
 	Name() string
 
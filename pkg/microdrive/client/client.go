@@ -38,7 +38,7 @@ func GetClient(c string) Client {
 
 	switch strings.ToLower(c) {
 
-	case "if1":
+	case "if1", "interface 1":
 		return IF1
 
 	case "ql":
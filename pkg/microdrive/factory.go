@@ -31,20 +31,149 @@ import (
 
 //
 func NewCartridge(cl client.Client) (base.Cartridge, error) {
+	return NewCartridgeOfSize(cl, 0)
+}
+
+// NewCartridgeOfSize creates a cartridge for the given client, with room for
+// the given number of sectors. If sectors is 0, the client's default sector
+// count is used.
+func NewCartridgeOfSize(cl client.Client, sectors int) (base.Cartridge,
+	error) {
+
+	max, err := MaxSectorCount(cl)
+	if err != nil {
+		return nil, err
+	}
+
+	if sectors == 0 {
+		sectors = DefaultSectorCount(cl)
+	}
+
+	if sectors < 1 || sectors > max {
+		return nil, fmt.Errorf(
+			"invalid sector count for %v cartridge: %d, needs to be within 1 and %d",
+			cl, sectors, max)
+	}
+
+	switch cl {
+
+	case client.IF1:
+		return if1.NewCartridgeOfSize(sectors), nil
+
+	default:
+		return ql.NewCartridgeOfSize(sectors), nil
+	}
+}
+
+/*
+	CopyCartridge creates a deep copy of cart. Headers and records are copied
+	as well, since some operations such as repair or adding files change them
+	in place. Defective headers and records are copied as they are.
+*/
+func CopyCartridge(cart base.Cartridge) (base.Cartridge, error) {
+
+	ret, err := NewCartridgeOfSize(cart.Client(), cart.SectorCount())
+	if err != nil {
+		return nil, err
+	}
+
+	for ix := 0; ix < cart.SectorCount(); ix++ {
+		if sec := cart.GetSectorAt(ix); sec != nil {
+			cp, err := copySector(cart.Client(), sec)
+			if err != nil {
+				return nil, err
+			}
+			ret.SetSectorAt(ix, cp)
+		}
+	}
+
+	ret.SetName(cart.Name())
+	ret.SetWriteProtected(cart.IsWriteProtected())
+	ret.SetModified(cart.IsModified())
+
+	return ret, nil
+}
+
+// copySector creates a deep copy of sec; validation errors of header and record
+// are ignored, so that defects get copied as well
+func copySector(cl client.Client, sec base.Sector) (base.Sector, error) {
+
+	var hd base.Header
+	var rec base.Record
+
+	if h := sec.Header(); h != nil {
+		var err error
+		if hd, err = NewHeader(cl, h.Demuxed(), false); hd == nil {
+			return nil, err
+		}
+	}
+
+	if r := sec.Record(); r != nil {
+		var err error
+		if rec, err = NewRecord(cl, r.Demuxed(), false); rec == nil {
+			return nil, err
+		}
+	}
+
+	return NewSector(hd, rec)
+}
+
+// DefaultSectorCount returns the number of sectors of a full length cartridge
+// for the given client
+func DefaultSectorCount(cl client.Client) int {
+	switch cl {
+	case client.IF1:
+		return if1.SectorCount
+	case client.QL:
+		return ql.SectorCount
+	default:
+		return 0
+	}
+}
+
+// MaxSectorCount returns the maximum number of sectors a cartridge for the
+// given client can have
+func MaxSectorCount(cl client.Client) (int, error) {
+	switch cl {
+	case client.IF1:
+		return if1.SectorCount, nil
+	case client.QL:
+		return ql.MaxSectorCount, nil
+	default:
+		return 0, fmt.Errorf("unsupported client type for cartridge: %d", cl)
+	}
+}
+
+// NewFormattedCartridge creates a cartridge for the given client, with all
+// sectors formatted and no files
+func NewFormattedCartridge(cl client.Client, name string) (base.Cartridge,
+	error) {
 
 	switch cl {
 
 	case client.IF1:
-		return if1.NewCartridge(), nil
+		return if1.NewFormattedCartridge(name)
 
 	case client.QL:
-		return ql.NewCartridge(), nil
+		return ql.NewFormattedCartridge(name)
 
 	default:
 		return nil, fmt.Errorf("unsupported client type for cartridge: %d", cl)
 	}
 }
 
+// FindFile finds the file with given name on the cartridge; for IF1, the name
+// may also be given in translated form
+func FindFile(cart base.Cartridge, name string) base.File {
+	for _, f := range cart.Files() {
+		if f.Name() == name || (cart.Client() == client.IF1 &&
+			if1.TranslateName(f.Name()) == name) {
+			return f
+		}
+	}
+	return nil
+}
+
 //
 func NewSector(h base.Header, r base.Record) (base.Sector, error) {
 	return base.NewSector(h, r)
@@ -0,0 +1,89 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+package microdrive
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/client"
+)
+
+// TestCopyCartridge checks that changing headers and records of a cartridge in
+// place does not affect its copies
+func TestCopyCartridge(t *testing.T) {
+
+	for _, cl := range []client.Client{client.IF1, client.QL} {
+
+		cart, err := NewFormattedCartridge(cl, "copy")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cp, err := CopyCartridge(cart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash := cp.Hash()
+
+		// adding a file changes records in place
+		if err := cart.AddFile("file", nil, make([]byte, 2000)); err != nil {
+			t.Fatalf("%v: %v", cl, err)
+		}
+		if cart.Hash() == hash {
+			t.Fatalf("%v: adding file did not change cartridge", cl)
+		}
+
+		if cp.Hash() != hash {
+			t.Errorf("%v: copy changed along with original", cl)
+		}
+		if len(cp.Files()) != 0 {
+			t.Errorf("%v: file shows up in copy", cl)
+		}
+	}
+}
+
+// TestCopyCartridgeDefective checks that defective sectors are copied as they
+// are
+func TestCopyCartridgeDefective(t *testing.T) {
+
+	cart, err := NewFormattedCartridge(client.IF1, "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sec := cart.GetSectorAt(0)
+	sec.Header().Demuxed()[20] ^= 0xff
+	sec.Record().Demuxed()[100] ^= 0xff
+
+	cp, err := CopyCartridge(cart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copied := cp.GetSectorAt(0)
+	if !bytes.Equal(copied.Header().Demuxed(), sec.Header().Demuxed()) ||
+		!bytes.Equal(copied.Record().Demuxed(), sec.Record().Demuxed()) {
+		t.Errorf("defective sector not copied as is")
+	}
+	if copied.Header().Validate() == nil {
+		t.Errorf("copied header should be defective")
+	}
+}
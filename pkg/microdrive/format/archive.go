@@ -0,0 +1,291 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+package format
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// maximum size of a cartridge image extracted from an archive
+const MaxEntrySize = 1048576
+
+// maximum size of an archive, compressed as well as uncompressed; for the
+// latter, the sizes of all extracted members add up
+const MaxArchiveSize = 16 * MaxEntrySize
+
+// Entry is a cartridge image found inside an archive
+type Entry struct {
+	Name string
+	Data []byte
+}
+
+// Format returns the format indicated by the entry's file extension
+func (e *Entry) Format() string {
+	return strings.ToLower(strings.TrimPrefix(path.Ext(e.Name), "."))
+}
+
+// IsArchive determines whether data is a ZIP or gzip archive
+func IsArchive(data []byte) bool {
+	return sniffZIP(data) > 0 || sniffGZIP(data) > 0
+}
+
+/*
+	Unpack extracts a cartridge image from data, if data is a ZIP, gzip, or
+	gzipped tar archive. The image to extract is selected via entry, which is
+	matched case-insensitively against the full path and the base name of the
+	archive members. When entry is empty and the archive contains exactly one
+	candidate image, that image is picked. If data is not an archive, it is
+	returned as is, in an entry with an empty name.
+*/
+func Unpack(data []byte, entry string) (*Entry, error) {
+
+	if !IsArchive(data) {
+		return &Entry{Data: data}, nil
+	}
+
+	// when an entry is given, only matching members get extracted
+	entries, err := extract(data, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == "" {
+		switch len(entries) {
+		case 0:
+			return nil, fmt.Errorf("archive contains no cartridge images")
+		case 1:
+			return entries[0], nil
+		}
+		return nil, fmt.Errorf(
+			"archive contains several cartridge images, select one of: %s",
+			entryNames(entries))
+	}
+
+	for _, e := range entries {
+		if matchesEntry(e.Name, entry) {
+			return e, nil
+		}
+	}
+
+	if entries, err = Entries(data); err != nil {
+		return nil, err
+	}
+
+	return nil, fmt.Errorf(
+		"no cartridge image '%s' in archive, available are: %s",
+		entry, entryNames(entries))
+}
+
+// Entries returns all candidate cartridge images contained in archive data.
+// An archive member is a candidate if its file extension denotes a cartridge
+// format, or if its content is detected as such.
+func Entries(data []byte) ([]*Entry, error) {
+	return extract(data, "")
+}
+
+// extract returns the candidate cartridge images contained in archive data. If
+// entry is not empty, only members matching it are extracted from ZIP and tar
+// archives.
+func extract(data []byte, entry string) ([]*Entry, error) {
+
+	var all []*Entry
+	var err error
+
+	if sniffZIP(data) > 0 {
+		all, err = unzip(data, entry)
+
+	} else if sniffGZIP(data) > 0 {
+		all, err = gunzip(data, entry)
+
+	} else {
+		return nil, fmt.Errorf("not an archive")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []*Entry
+	for _, e := range all {
+		if isCartridge(e) {
+			ret = append(ret, e)
+		}
+	}
+	return ret, nil
+}
+
+// matchesEntry determines whether the archive member with given name matches
+// entry, by full path or base name; an empty entry matches all members
+func matchesEntry(name, entry string) bool {
+	return entry == "" || strings.EqualFold(name, entry) ||
+		strings.EqualFold(path.Base(name), entry)
+}
+
+//
+func isCartridge(e *Entry) bool {
+
+	if _, err := NewFormat(e.Format()); err == nil {
+		return true
+	}
+
+	if c := Sniff(e.Data); len(c) > 0 && c[0].Confidence >= MinConfidence {
+		_, err := NewFormat(c[0].Format)
+		return err == nil
+	}
+
+	return false
+}
+
+// unzip extracts the members of ZIP archive data that match entry. Member sizes
+// given in the archive are not trusted, so the extracted data is counted
+// against MaxArchiveSize.
+func unzip(data []byte, entry string) ([]*Entry, error) {
+
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("error opening ZIP archive: %v", err)
+	}
+
+	var ret []*Entry
+	total := 0
+
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || f.UncompressedSize64 > MaxEntrySize ||
+			!matchesEntry(f.Name, entry) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("error opening '%s' in ZIP archive: %v",
+				f.Name, err)
+		}
+		d, err := readEntry(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading '%s' from ZIP archive: %v",
+				f.Name, err)
+		}
+		if total += len(d); total > MaxArchiveSize {
+			return nil, fmt.Errorf("decompressed ZIP archive too large")
+		}
+		ret = append(ret, &Entry{Name: f.Name, Data: d})
+	}
+
+	return ret, nil
+}
+
+// gunzip decompresses data; if the result is a tar archive, its members that
+// match entry are returned, otherwise the decompressed data as a single entry
+func gunzip(data []byte, entry string) ([]*Entry, error) {
+
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error opening gzip archive: %v", err)
+	}
+	defer r.Close()
+
+	// tar archives can get larger than a single image
+	d, err := ioutil.ReadAll(io.LimitReader(r, MaxArchiveSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing gzip archive: %v", err)
+	}
+	if len(d) > MaxArchiveSize {
+		return nil, fmt.Errorf("decompressed gzip archive too large")
+	}
+
+	if isTar(d) {
+		return untar(d, entry)
+	}
+
+	if len(d) > MaxEntrySize {
+		return nil, fmt.Errorf("decompressed data too large")
+	}
+
+	name := r.Name
+	if name == "" {
+		name = "unnamed"
+	}
+	return []*Entry{{Name: name, Data: d}}, nil
+}
+
+//
+func isTar(data []byte) bool {
+	return len(data) >= 512 && bytes.HasPrefix(data[257:], []byte("ustar"))
+}
+
+//
+func untar(data []byte, entry string) ([]*Entry, error) {
+
+	r := tar.NewReader(bytes.NewReader(data))
+	var ret []*Entry
+
+	for {
+		hdr, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading tar archive: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg || hdr.Size > MaxEntrySize ||
+			!matchesEntry(hdr.Name, entry) {
+			continue
+		}
+		d, err := readEntry(r)
+		if err != nil {
+			return nil, fmt.Errorf("error reading '%s' from tar archive: %v",
+				hdr.Name, err)
+		}
+		ret = append(ret, &Entry{Name: hdr.Name, Data: d})
+	}
+
+	return ret, nil
+}
+
+// readEntry reads an archive member, making sure it does not exceed the
+// maximum size; archive headers may lie about sizes
+func readEntry(r io.Reader) ([]byte, error) {
+	d, err := ioutil.ReadAll(io.LimitReader(r, MaxEntrySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(d) > MaxEntrySize {
+		return nil, fmt.Errorf("entry too large")
+	}
+	return d, nil
+}
+
+//
+func entryNames(entries []*Entry) string {
+	var names []string
+	for _, e := range entries {
+		names = append(names, filepath.ToSlash(e.Name))
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,221 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+package format
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+//
+func TestUnpackNoArchive(t *testing.T) {
+	data := mdrData(t)
+	e, err := Unpack(data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Name != "" || !bytes.Equal(e.Data, data) {
+		t.Errorf("want data unchanged")
+	}
+}
+
+//
+func TestUnpack(t *testing.T) {
+
+	mdr := mdrData(t)
+	mdv := mdvData(t, MDVVariantQLay)
+
+	single := zipData(t, map[string][]byte{
+		"games/a.mdr": mdr,
+		"readme.txt":  []byte("not a cartridge"),
+	})
+	several := zipData(t, map[string][]byte{
+		"games/a.mdr": mdr,
+		"games/b.mdv": mdv,
+		"c.bin":       mdr, // misnamed, detected by content
+	})
+	tgz := tarGzipData(t, map[string][]byte{
+		"a.mdr":      mdr,
+		"b.mdv":      mdv,
+		"readme.txt": []byte("not a cartridge"),
+	})
+
+	for _, tc := range []struct {
+		name  string
+		data  []byte
+		entry string
+		want  string
+		err   string
+	}{
+		{"zip single", single, "", "games/a.mdr", ""},
+		{"zip by path", several, "games/b.mdv", "games/b.mdv", ""},
+		{"zip by base name", several, "A.MDR", "games/a.mdr", ""},
+		{"zip by content", several, "c.bin", "c.bin", ""},
+		{"zip ambiguous", several, "", "", "several cartridge images"},
+		{"zip unknown entry", several, "x.mdr", "", "available are"},
+		{"zip no images", zipData(t, map[string][]byte{"a.txt": []byte("a")}),
+			"", "", "no cartridge images"},
+		{"gz", gzipData(t, "a.mdr", mdr), "", "a.mdr", ""},
+		{"gz unnamed", gzipData(t, "", mdr), "", "unnamed", ""},
+		{"tgz by name", tgz, "b.mdv", "b.mdv", ""},
+		{"tgz ambiguous", tgz, "", "", "several cartridge images"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e, err := Unpack(tc.data, tc.entry)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("want error containing '%s', got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.Name != tc.want {
+				t.Errorf("want entry %s, got %s", tc.want, e.Name)
+			}
+		})
+	}
+}
+
+// TestUnpackZipBomb checks that an archive of highly compressed members cannot
+// be used for exhausting memory
+func TestUnpackZipBomb(t *testing.T) {
+
+	files := make(map[string][]byte)
+	member := make([]byte, MaxEntrySize)
+	for ix := 0; ix <= MaxArchiveSize/MaxEntrySize; ix++ {
+		files[fmt.Sprintf("m%02d.mdr", ix)] = member
+	}
+	data := zipData(t, files)
+	if len(data) > MaxEntrySize {
+		t.Fatalf("test archive not compressed enough: %d bytes", len(data))
+	}
+
+	if _, err := Entries(data); err == nil ||
+		!strings.Contains(err.Error(), "too large") {
+		t.Errorf("want error for archive too large, got %v", err)
+	}
+
+	// selecting an entry only extracts that one
+	if e, err := Unpack(data, "m03.mdr"); err != nil || e.Name != "m03.mdr" {
+		t.Errorf("want m03.mdr, got %v, %v", e, err)
+	}
+}
+
+//
+func TestUnpackOversized(t *testing.T) {
+
+	big := make([]byte, MaxEntrySize+1)
+
+	// oversized members are skipped
+	data := zipData(t, map[string][]byte{"big.mdr": big, "a.mdr": mdrData(t)})
+	if e, err := Unpack(data, ""); err != nil || e.Name != "a.mdr" {
+		t.Errorf("want a.mdr, got %v, %v", e, err)
+	}
+
+	data = tarGzipData(t, map[string][]byte{"big.mdr": big})
+	if _, err := Unpack(data, ""); err == nil {
+		t.Errorf("want error for oversized tar member")
+	}
+
+	if _, err := Unpack(gzipData(t, "big.mdr", big), ""); err == nil ||
+		!strings.Contains(err.Error(), "too large") {
+		t.Errorf("want error for oversized gzip content, got %v", err)
+	}
+
+	huge := make([]byte, MaxArchiveSize+1)
+	if _, err := Unpack(gzipData(t, "huge.mdr", huge), ""); err == nil ||
+		!strings.Contains(err.Error(), "too large") {
+		t.Errorf("want error for oversized gzip archive, got %v", err)
+	}
+}
+
+// TestUnpackTruncated checks that truncated and corrupted archives produce
+// errors, not panics
+func TestUnpackTruncated(t *testing.T) {
+
+	mdr := mdrData(t)
+	samples := map[string][]byte{
+		"zip": zipData(t, map[string][]byte{"a.mdr": mdr}),
+		"gz":  gzipData(t, "a.mdr", mdr),
+		"tgz": tarGzipData(t, map[string][]byte{"a.mdr": mdr, "b.mdr": mdr}),
+	}
+
+	for name, data := range samples {
+		for _, l := range truncations(len(data)) {
+			if !IsArchive(data[:l]) {
+				continue
+			}
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Errorf("%s truncated to %d panics: %v", name, l, r)
+					}
+				}()
+				if _, err := Unpack(data[:l], ""); err == nil {
+					t.Errorf("%s truncated to %d: want error", name, l)
+				}
+			}()
+		}
+
+		corrupt := append([]byte{}, data...)
+		for ix := 10; ix < len(corrupt); ix += 7 {
+			corrupt[ix] ^= 0x5a
+		}
+		if _, err := Unpack(corrupt, ""); err == nil {
+			t.Errorf("corrupted %s: want error", name)
+		}
+	}
+}
+
+//
+func tarGzipData(t *testing.T, files map[string][]byte) []byte {
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	for name, data := range files {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(data)),
+			Typeflag: tar.TypeReg,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
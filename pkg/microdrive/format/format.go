@@ -25,7 +25,9 @@ import (
 	"io"
 	"strings"
 
+	"github.com/xelalexv/oqtadrive/pkg/microdrive"
 	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/client"
 )
 
 // Reader interface for reading in a cartridge
@@ -65,3 +67,29 @@ func NewFormat(typ string) (ReaderWriter, error) {
 		return nil, fmt.Errorf("unsupported cartridge format: %s", typ)
 	}
 }
+
+/*
+	newCartridge creates a cartridge for reading in count sectors from a file.
+	Unless a sector count is requested via the "sectors" parameter, the
+	cartridge is sized to hold exactly the sectors from the file, so that the
+	tape length is kept. Requesting fewer sectors than the file holds is an
+	error.
+*/
+func newCartridge(cl client.Client, count int,
+	params map[string]interface{}) (base.Cartridge, error) {
+
+	sectors := count
+
+	if v, ok := params["sectors"]; ok && v != nil {
+		if n, ok := v.(int); ok && n > 0 {
+			if n < count {
+				return nil, fmt.Errorf(
+					"file contains %d sectors, more than requested %d",
+					count, n)
+			}
+			sectors = n
+		}
+	}
+
+	return microdrive.NewCartridgeOfSize(cl, sectors)
+}
@@ -44,22 +44,42 @@ const ixVersion = 0
 const ixClient = 1
 const ixFlags = 2
 
+// The sector count was added to the preamble later on. Auto-saves without it
+// are still compatible, and loaded with the sector count found in the file.
+const ixSectors = 3
+const preambleLength = 5
+
+// auto-save files in a drive's folder; the master file holds the immutable
+// cartridge underneath the cartridge in the drive when in overlay mode
+const fileCartridge = "cart"
+const fileMaster = "master"
+
 //
 func AutoSave(drive int, cart base.Cartridge) error {
+	return autoSave(drive, fileCartridge, cart)
+}
+
+//
+func AutoSaveMaster(drive int, cart base.Cartridge) error {
+	return autoSave(drive, fileMaster, cart)
+}
+
+//
+func autoSave(drive int, name string, cart base.Cartridge) error {
 
 	if cart == nil || !cart.IsFormatted() || cart.IsAutoSaved() {
 		return nil
 	}
 
 	start := time.Now()
-	log.Infof("auto-saving drive %d", drive)
+	log.Infof("auto-saving drive %d (%s)", drive, name)
 
 	fm, err := format.NewFormat(cart.Client().DefaultFormat())
 	if err != nil {
 		return err
 	}
 
-	_, file, err := autoSavePath(drive, true)
+	_, file, err := autoSavePath(drive, name, true)
 	if err != nil {
 		return err
 	}
@@ -73,7 +93,7 @@ func AutoSave(drive int, cart base.Cartridge) error {
 
 	out := bufio.NewWriter(fd)
 
-	preamble := make([]byte, 3)
+	preamble := make([]byte, preambleLength)
 
 	var flags byte = 0
 	if cart.IsModified() {
@@ -86,6 +106,8 @@ func AutoSave(drive int, cart base.Cartridge) error {
 	preamble[ixVersion] = AutoSaveVersion
 	preamble[ixClient] = byte(cart.Client())
 	preamble[ixFlags] = flags
+	preamble[ixSectors] = byte(cart.SectorCount())
+	preamble[ixSectors+1] = byte(cart.SectorCount() >> 8)
 
 	if err := writeRaw(preamble, out); err != nil {
 		return err
@@ -119,10 +141,20 @@ func AutoSave(drive int, cart base.Cartridge) error {
 
 //
 func AutoLoad(drive int) (base.Cartridge, error) {
+	return autoLoad(drive, fileCartridge)
+}
+
+//
+func AutoLoadMaster(drive int) (base.Cartridge, error) {
+	return autoLoad(drive, fileMaster)
+}
+
+//
+func autoLoad(drive int, name string) (base.Cartridge, error) {
 
-	log.Infof("loading auto-save for drive %d", drive)
+	log.Infof("loading auto-save for drive %d (%s)", drive, name)
 
-	_, file, err := autoSavePath(drive, false)
+	_, file, err := autoSavePath(drive, name, false)
 	if err != nil {
 		return nil, err
 	}
@@ -132,7 +164,7 @@ func AutoLoad(drive int) (base.Cartridge, error) {
 		if !os.IsNotExist(err) {
 			return nil, err
 		}
-		log.Infof("no auto-save file for drive %d", drive)
+		log.Infof("no auto-save file for drive %d (%s)", drive, name)
 		return nil, nil
 	}
 	defer fd.Close()
@@ -156,7 +188,13 @@ func AutoLoad(drive int) (base.Cartridge, error) {
 		return nil, err
 	}
 
-	if cart, err := fm.Read(in, true, false, nil); err != nil {
+	var params map[string]interface{}
+	if len(preamble) >= ixSectors+2 {
+		params = map[string]interface{}{"sectors": int(preamble[ixSectors]) +
+			256*int(preamble[ixSectors+1])}
+	}
+
+	if cart, err := fm.Read(in, true, false, params); err != nil {
 		return nil, err
 
 	} else {
@@ -169,8 +207,18 @@ func AutoLoad(drive int) (base.Cartridge, error) {
 
 //
 func AutoRemove(drive int) error {
+	return autoRemove(drive, fileCartridge)
+}
+
+//
+func AutoRemoveMaster(drive int) error {
+	return autoRemove(drive, fileMaster)
+}
+
+//
+func autoRemove(drive int, name string) error {
 
-	if _, file, err := autoSavePath(drive, false); err != nil {
+	if _, file, err := autoSavePath(drive, name, false); err != nil {
 		return err
 	} else {
 		if err := os.Remove(file); err != nil {
@@ -178,7 +226,7 @@ func AutoRemove(drive int) error {
 				return err
 			}
 		} else {
-			log.Infof("removed auto-save for drive %d", drive)
+			log.Infof("removed auto-save for drive %d (%s)", drive, name)
 		}
 	}
 
@@ -186,7 +234,7 @@ func AutoRemove(drive int) error {
 }
 
 //
-func autoSavePath(drive int, create bool) (string, string, error) {
+func autoSavePath(drive int, name string, create bool) (string, string, error) {
 
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -201,7 +249,7 @@ func autoSavePath(drive int, create bool) (string, string, error) {
 		}
 	}
 
-	return dir, filepath.Join(dir, "cart"), nil
+	return dir, filepath.Join(dir, name), nil
 }
 
 //
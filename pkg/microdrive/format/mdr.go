@@ -29,6 +29,7 @@ import (
 
 	"github.com/xelalexv/oqtadrive/pkg/microdrive"
 	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/client"
 	"github.com/xelalexv/oqtadrive/pkg/microdrive/if1"
 	"github.com/xelalexv/oqtadrive/pkg/microdrive/raw"
 )
@@ -47,11 +48,12 @@ func NewMDR() *MDR {
 func (m *MDR) Read(in io.Reader, strict, repair bool,
 	params map[string]interface{}) (base.Cartridge, error) {
 
-	cart := if1.NewCartridge()
+	var sectors []base.Sector
+	writeProtected := false
 	r := 0
 
 	// TODO: possibly add switch to reassign or keep order from MDR file?
-	for ; r < cart.SectorCount(); r++ {
+	for ; r < if1.SectorCount; r++ {
 
 		header := make([]byte, 27)
 		ix := raw.CopySyncPattern(header)
@@ -60,10 +62,9 @@ func (m *MDR) Read(in io.Reader, strict, repair bool,
 		if err != nil {
 			if err == io.ErrUnexpectedEOF {
 				if read == 1 {
-					cart.SetWriteProtected(header[ix] > 0)
+					writeProtected = header[ix] > 0
 				} else {
 					log.Warnf("expected one final byte, but got %d", read)
-					cart.SetWriteProtected(false)
 				}
 				break
 			}
@@ -120,13 +121,23 @@ func (m *MDR) Read(in io.Reader, strict, repair bool,
 			}
 		}
 
-		cart.SetNextSector(sec)
+		sectors = append(sectors, sec)
 
 		if log.IsLevelEnabled(log.TraceLevel) {
 			sec.Emit(os.Stdout)
 		}
 	}
 
+	cart, err := newCartridge(client.IF1, len(sectors), params)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sec := range sectors {
+		cart.SetNextSector(sec)
+	}
+	cart.SetWriteProtected(writeProtected)
+
 	if repair {
 		RepairOrder(cart)
 	}
@@ -143,8 +154,10 @@ func (m *MDR) Write(cart base.Cartridge, out io.Writer,
 
 	cart.SeekToStart()
 
+	// visit each slot exactly once, so that cartridges with missing sectors
+	// are not written with repeated sectors
 	for ix := 0; ix < cart.SectorCount(); ix++ {
-		if sec := cart.GetNextSector(); sec != nil {
+		if sec := cart.GetSectorAt(cart.AdvanceAccessIx(false)); sec != nil {
 
 			if _, err := out.Write(
 				sec.Header().Demuxed()[raw.SyncPatternLength:]); err != nil {
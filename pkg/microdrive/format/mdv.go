@@ -23,13 +23,16 @@ package format
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/xelalexv/oqtadrive/pkg/microdrive"
 	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/client"
 	"github.com/xelalexv/oqtadrive/pkg/microdrive/ql"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/raw"
 )
 
 // Strangely, a sector in an MDV file is longer than what the QL actually writes
@@ -38,8 +41,19 @@ import (
 // Dickens).
 const MDVSectorLength = 686
 
+// Raw dumps and some emulators however do store sectors with just these 652
+// bytes. When reading, the sector length is detected, when writing, it is
+// selected via the variant parameter.
+const MDVRawSectorLength = ql.MaxSectorLength
+
+// MDV variants
+const MDVVariantQLay = "qlay"
+const MDVVariantRaw = "raw"
+
 // MDV is a reader/writer for MDV format
-// MDV files contain the sectors in reverted replay order.
+// MDV files contain the sectors in reverted replay order. The number of
+// sectors may differ from the usual 255, depending on the tape the image was
+// taken from.
 //
 type MDV struct{}
 
@@ -51,20 +65,43 @@ func NewMDV() *MDV {
 func (m *MDV) Read(in io.Reader, strict, repair bool,
 	params map[string]interface{}) (base.Cartridge, error) {
 
-	cart := ql.NewCartridge()
-	ix := 0
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, fmt.Errorf("error reading MDV file: %v", err)
+	}
 
-	for ; ix < cart.SectorCount(); ix++ {
+	length := mdvSectorLength(data)
+	count := len(data) / length
+	log.Debugf("MDV sector length is %d", length)
 
-		sector := make([]byte, MDVSectorLength)
-		read, err := io.ReadFull(in, sector)
+	if rest := len(data) % length; rest != 0 {
+		msg := fmt.Sprintf("incomplete sector of %d bytes at end of MDV file",
+			rest)
+		if strict {
+			return nil, fmt.Errorf(msg)
+		}
+		log.Warn(msg)
+	}
 
-		if err != nil {
-			if err == io.EOF && read == 0 {
-				break
-			}
-			return nil, fmt.Errorf("error reading MDV file: %v", err)
+	if count > ql.MaxSectorCount {
+		msg := fmt.Sprintf("MDV file contains %d sectors, maximum is %d",
+			count, ql.MaxSectorCount)
+		if strict {
+			return nil, fmt.Errorf(msg)
 		}
+		log.Warn(msg)
+		count = ql.MaxSectorCount
+	}
+
+	cart, err := newCartridge(client.QL, count, params)
+	if err != nil {
+		return nil, err
+	}
+	ix := 0
+
+	for ; ix < count; ix++ {
+
+		sector := data[ix*length : (ix+1)*length]
 
 		hd, err := ql.NewHeader(sector[:ql.HeaderLength], false)
 		if err != nil && repair {
@@ -132,23 +169,45 @@ func (m *MDV) Read(in io.Reader, strict, repair bool,
 func (m *MDV) Write(cart base.Cartridge, out io.Writer,
 	params map[string]interface{}) error {
 
+	length := MDVSectorLength
+	if params != nil {
+		if v, ok := params["variant"]; ok && v != nil {
+			switch v {
+			case "", MDVVariantQLay:
+			case MDVVariantRaw:
+				length = MDVRawSectorLength
+			default:
+				return fmt.Errorf("unsupported MDV variant: %v", v)
+			}
+		}
+	}
+
 	padding := make([]byte, 256)
 	for ix := range padding {
 		padding[ix] = 0x5a
 	}
 
+	// only sectors present on the cartridge are written, so that shorter tapes
+	// keep their length
+	count := 0
+	for ix := 0; ix < cart.SectorCount(); ix++ {
+		if cart.GetSectorAt(ix) != nil {
+			count++
+		}
+	}
+
 	cart.SeekToStart()
 	cart.AdvanceAccessIx(false)
 
-	for ix := 0; ix < cart.SectorCount(); ix++ {
+	for ix := 0; ix < count; ix++ {
 
 		sec := cart.GetPreviousSector()
 
-		if sec == nil { // MDV requires all sectors; FIXME: add blank one
+		if sec == nil {
 			return fmt.Errorf("missing sector %d", ix)
 		}
 
-		missing := MDVSectorLength
+		missing := length
 		var written int
 		var err error
 
@@ -175,3 +234,37 @@ func (m *MDV) Write(cart base.Cartridge, out io.Writer,
 
 	return nil
 }
+
+// mdvSectorLength determines the length of sectors in MDV data, by checking
+// which of the known lengths divides the data evenly, and leads to a valid
+// sector header for the second sector
+func mdvSectorLength(data []byte) int {
+
+	ret := MDVSectorLength
+	best := -1
+
+	for _, l := range []int{MDVSectorLength, MDVRawSectorLength} {
+		score := 0
+		if len(data)%l == 0 {
+			score++
+		}
+		if len(data) >= l+ql.HeaderLength && isMDVHeader(data[l:]) {
+			score += 2
+		}
+		if score > best {
+			ret, best = l, score
+		}
+	}
+
+	return ret
+}
+
+// isMDVHeader checks whether data starts with a valid QL sector header
+func isMDVHeader(data []byte) bool {
+	if len(data) < ql.HeaderLength ||
+		data[raw.SyncPatternLength] != ql.HeaderFlags {
+		return false
+	}
+	_, err := ql.NewHeader(data[:ql.HeaderLength], false)
+	return err == nil
+}
@@ -0,0 +1,275 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package format
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/if1"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/ql"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/raw"
+)
+
+// length of a sector in an MDR file, header and record without sync patterns
+const MDRSectorLength = 543
+
+// minimum confidence for a detection to take precedence over the format
+// indicated by file extension
+const MinConfidence = 0.5
+
+// Candidate is a format that data may be in, with a confidence between 0
+// and 1
+type Candidate struct {
+	Format     string  `json:"format"`
+	Confidence float64 `json:"confidence"`
+}
+
+//
+func (c *Candidate) String() string {
+	return fmt.Sprintf("%s (%.0f%%)", c.Format, c.Confidence*100)
+}
+
+// sniffers for all known formats; archives and TAP files are recognized, but
+// cannot be read as cartridges
+var sniffers = map[string]func(data []byte) float64{
+	"mdr": sniffMDR,
+	"mdv": sniffMDV,
+	"z80": sniffZ80,
+	"tap": sniffTAP,
+	"zip": sniffZIP,
+	"gz":  sniffGZIP,
+}
+
+// Sniff inspects data and returns the formats it may be in, most likely first.
+// Formats that can be ruled out are not included.
+func Sniff(data []byte) []*Candidate {
+
+	var ret []*Candidate
+
+	for f, sniff := range sniffers {
+		if c := sniff(data); c > 0 {
+			ret = append(ret, &Candidate{Format: f, Confidence: c})
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Confidence == ret[j].Confidence {
+			return ret[i].Format < ret[j].Format
+		}
+		return ret[i].Confidence > ret[j].Confidence
+	})
+
+	return ret
+}
+
+/*
+	Detect determines the format of data by its content, and returns the
+	according reader/writer along with the detected format. If detection is
+	inconclusive, the format given as fallback is used, if any. This is usually
+	the format indicated by file extension. A detected format always takes
+	precedence over the fallback, so misnamed files can still be read.
+*/
+func Detect(data []byte, fallback string) (ReaderWriter, *Candidate, error) {
+
+	fallback = strings.ToLower(fallback)
+	candidates := Sniff(data)
+
+	if len(candidates) > 0 && candidates[0].Confidence >= MinConfidence {
+		best := candidates[0]
+		if fallback != "" && fallback != best.Format {
+			log.Warnf("content looks like %s, not %s", best, fallback)
+		}
+		rw, err := NewFormat(best.Format)
+		if err != nil {
+			return nil, best, fmt.Errorf("detected format %s: %v", best, err)
+		}
+		return rw, best, nil
+	}
+
+	if fallback != "" {
+		rw, err := NewFormat(fallback)
+		return rw, &Candidate{Format: fallback}, err
+	}
+
+	if len(candidates) > 0 {
+		return nil, candidates[0], fmt.Errorf(
+			"cannot determine format, best guess is %s", candidates[0])
+	}
+	return nil, nil, fmt.Errorf("cannot determine format")
+}
+
+// sniffMDR checks for a sequence of 543 byte sectors followed by the write
+// protection byte, and for valid sector headers
+func sniffMDR(data []byte) float64 {
+
+	count := len(data) / MDRSectorLength
+	if count == 0 || count > if1.SectorCount {
+		return 0
+	}
+
+	ret := 0.0
+	switch len(data) % MDRSectorLength {
+	case 1:
+		ret = 0.4
+	case 0:
+		ret = 0.3
+	default:
+		return 0
+	}
+
+	valid := 0
+	header := make([]byte, if1.HeaderLength)
+	length := if1.HeaderLength - raw.SyncPatternLength
+	for ix := 0; ix < count; ix++ {
+		raw.CopySyncPattern(header)
+		sector := data[ix*MDRSectorLength:]
+		copy(header[raw.SyncPatternLength:], sector[:length])
+		if sector[0]&0x01 == 1 && sector[length]&0x01 == 0 {
+			if _, err := if1.NewHeader(header, false); err == nil {
+				valid++
+			}
+		}
+	}
+
+	return ret + 0.6*float64(valid)/float64(count)
+}
+
+// sniffMDV checks for a sequence of 686 or 652 byte sectors, each starting
+// with a sync pattern and a valid sector header
+func sniffMDV(data []byte) float64 {
+
+	length := mdvSectorLength(data)
+	count := len(data) / length
+	if count == 0 || count > ql.MaxSectorCount || len(data)%length != 0 {
+		return 0
+	}
+
+	valid := 0
+	for ix := 0; ix < count; ix++ {
+		if isMDVHeader(data[ix*length:]) {
+			valid++
+		}
+	}
+
+	return 0.3 + 0.7*float64(valid)/float64(count)
+}
+
+// sniffZ80 checks the plausibility of a Z80 snapshot header; version 2 & 3
+// snapshots are recognized by their additional header block and the first
+// memory page following it, version 1 snapshots by their size or end marker
+func sniffZ80(data []byte) float64 {
+
+	if len(data) < 30 {
+		return 0
+	}
+
+	if data[6] != 0 || data[7] != 0 { // version 1
+		switch {
+		case data[12]&0x20 == 0 && len(data) == 30+49152:
+			return 0.8
+		case data[12]&0x20 != 0 &&
+			bytes.HasSuffix(data, []byte{0x00, 0xed, 0xed, 0x00}):
+			return 0.8
+		}
+		return 0.1
+	}
+
+	// additional header block length, and hardware mode
+	if len(data) < 35 {
+		return 0
+	}
+
+	extra := int(binary.LittleEndian.Uint16(data[30:]))
+	if extra != 23 && extra != 54 && extra != 55 {
+		return 0
+	}
+
+	ret := 0.5
+	if data[34] < 16 { // hardware mode
+		ret += 0.1
+	}
+
+	// first memory page
+	ix := 32 + extra
+	if len(data) >= ix+3 {
+		length := int(binary.LittleEndian.Uint16(data[ix:]))
+		if page := data[ix+2]; page < 12 &&
+			(length == 0xffff || length <= 16384) {
+			ret += 0.3
+		}
+	}
+
+	return ret
+}
+
+// sniffTAP checks whether data consists of a sequence of TAP blocks with valid
+// check sums
+func sniffTAP(data []byte) float64 {
+
+	blocks := 0
+
+	for ix := 0; ix < len(data); {
+		if ix+2 > len(data) {
+			return 0
+		}
+		length := int(binary.LittleEndian.Uint16(data[ix:]))
+		ix += 2
+		if length < 2 || ix+length > len(data) {
+			return 0
+		}
+		sum := byte(0)
+		for _, b := range data[ix : ix+length] {
+			sum ^= b
+		}
+		if sum != 0 {
+			return 0
+		}
+		ix += length
+		blocks++
+	}
+
+	if blocks == 0 {
+		return 0
+	}
+	return 0.9
+}
+
+//
+func sniffZIP(data []byte) float64 {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return 0.95
+	}
+	return 0
+}
+
+//
+func sniffGZIP(data []byte) float64 {
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b, 0x08}) {
+		return 0.95
+	}
+	return 0
+}
@@ -0,0 +1,260 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+package format
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"math/rand"
+	"testing"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/if1"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/ql"
+)
+
+//
+func TestSniffValid(t *testing.T) {
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"mdr", mdrData(t), "mdr"},
+		{"mdv", mdvData(t, MDVVariantQLay), "mdv"},
+		{"mdv raw", mdvData(t, MDVVariantRaw), "mdv"},
+		{"z80 v1", z80V1Data(), "z80"},
+		{"z80 v3", z80V3Data(), "z80"},
+		{"tap", tapData(), "tap"},
+		{"zip", zipData(t, map[string][]byte{"a.mdr": mdrData(t)}), "zip"},
+		{"gz", gzipData(t, "a.mdr", mdrData(t)), "gz"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := Sniff(tc.data)
+			if len(c) == 0 {
+				t.Fatalf("no format detected")
+			}
+			if c[0].Format != tc.want || c[0].Confidence < MinConfidence {
+				t.Errorf("want %s, got %s", tc.want, c[0])
+			}
+		})
+	}
+}
+
+// TestSniffTruncated feeds all sniffers with truncated data, none of them may
+// panic
+func TestSniffTruncated(t *testing.T) {
+
+	samples := map[string][]byte{
+		"mdr":     mdrData(t),
+		"mdv":     mdvData(t, MDVVariantQLay),
+		"mdv raw": mdvData(t, MDVVariantRaw),
+		"z80 v1":  z80V1Data(),
+		"z80 v3":  z80V3Data(),
+		"tap":     tapData(),
+		"zip":     zipData(t, map[string][]byte{"a.mdr": mdrData(t)}),
+		"gz":      gzipData(t, "a.mdr", mdrData(t)),
+	}
+
+	for name, data := range samples {
+		for _, l := range truncations(len(data)) {
+			for f, sniff := range sniffers {
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							t.Errorf("%s sniffer panics on %s truncated to %d: %v",
+								f, name, l, r)
+						}
+					}()
+					sniff(data[:l])
+				}()
+			}
+		}
+	}
+}
+
+//
+func TestSniffZ80Short(t *testing.T) {
+	for l := 0; l < 40; l++ {
+		data := make([]byte, l)
+		if l > 31 {
+			data[30] = 54
+		}
+		if c := sniffZ80(data); l < 35 && c != 0 {
+			t.Errorf("length %d: want 0, got %f", l, c)
+		}
+	}
+}
+
+//
+func TestSniffGarbage(t *testing.T) {
+
+	r := rand.New(rand.NewSource(1))
+
+	for _, l := range []int{0, 1, 30, 35, 543, 544, 686, 4096, 65536} {
+		data := make([]byte, l)
+		r.Read(data)
+		if c := Sniff(data); len(c) > 0 && c[0].Confidence >= MinConfidence {
+			t.Errorf("random data of length %d detected as %s", l, c[0])
+		}
+		if _, _, err := Detect(data, ""); err == nil {
+			t.Errorf("random data of length %d: want error", l)
+		}
+	}
+}
+
+//
+func TestDetectFallback(t *testing.T) {
+
+	// content takes precedence over a misleading extension
+	if _, c, err := Detect(mdrData(t), "mdv"); err != nil || c.Format != "mdr" {
+		t.Errorf("want mdr, got %v, %v", c, err)
+	}
+
+	// inconclusive content uses extension
+	if _, c, err := Detect([]byte{1, 2, 3}, "mdr"); err != nil ||
+		c.Format != "mdr" {
+		t.Errorf("want mdr fallback, got %v, %v", c, err)
+	}
+
+	if _, _, err := Detect([]byte{1, 2, 3}, "xyz"); err == nil {
+		t.Errorf("want error for unknown fallback")
+	}
+}
+
+// truncations returns the lengths to which test data is truncated: all
+// lengths around the start, and a selection of lengths around typical block
+// boundaries
+func truncations(length int) []int {
+	var ret []int
+	for l := 0; l < 64 && l < length; l++ {
+		ret = append(ret, l)
+	}
+	for _, b := range []int{MDRSectorLength, MDVSectorLength,
+		MDVRawSectorLength, 512, 16384} {
+		for _, l := range []int{b - 1, b, b + 1, 2*b - 1, 2 * b, 2*b + 1} {
+			if 0 <= l && l < length {
+				ret = append(ret, l)
+			}
+		}
+	}
+	return append(ret, length-1)
+}
+
+//
+func mdrData(t *testing.T) []byte {
+	return cartridgeData(t, "mdr", "", if1.NewFormattedCartridge)
+}
+
+//
+func mdvData(t *testing.T, variant string) []byte {
+	return cartridgeData(t, "mdv", variant, ql.NewFormattedCartridge)
+}
+
+//
+func cartridgeData(t *testing.T, typ, variant string,
+	create func(name string) (base.Cartridge, error)) []byte {
+
+	cart, err := create("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fm, err := NewFormat(typ)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var params map[string]interface{}
+	if variant != "" {
+		params = map[string]interface{}{"variant": variant}
+	}
+
+	var buf bytes.Buffer
+	if err := fm.Write(cart, &buf, params); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// z80V1Data returns an uncompressed version 1 snapshot
+func z80V1Data() []byte {
+	data := make([]byte, 30+49152)
+	data[6] = 0x00 // PC
+	data[7] = 0x80
+	return data
+}
+
+// z80V3Data returns a version 3 snapshot with one uncompressed memory page
+func z80V3Data() []byte {
+	data := make([]byte, 32+54)
+	data[30] = 54 // additional header length
+	page := make([]byte, 3+16384)
+	page[0], page[1] = 0xff, 0xff // uncompressed
+	page[2] = 8
+	return append(data, page...)
+}
+
+// tapData returns a TAP file with a single header block
+func tapData() []byte {
+	block := append([]byte{0x00, 0x03}, []byte("test      ")...)
+	block = append(block, 0x00, 0x1b, 0x00, 0x40, 0x00, 0x80)
+	sum := byte(0)
+	for _, b := range block {
+		sum ^= b
+	}
+	block = append(block, sum)
+	return append([]byte{byte(len(block)), 0x00}, block...)
+}
+
+//
+func zipData(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, data := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+//
+func gzipData(t *testing.T, name string, data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Name = name
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -27,8 +27,8 @@ import (
 	"github.com/xelalexv/oqtadrive/pkg/microdrive/format/z80"
 )
 
-// Z80 is a format for loading Z80 snapshots. It is an asymmetrical format in
-// the sense that it reads Z80 snapshots, but writes MDRs.
+// Z80 is a format for loading Z80 snapshots. Reading converts a snapshot into
+// a cartridge, writing turns a cartridge created this way back into a snapshot.
 type Z80 struct{}
 
 //
@@ -41,15 +41,27 @@ func (z *Z80) Read(in io.Reader, strict, repair bool,
 	params map[string]interface{}) (base.Cartridge, error) {
 
 	name := ""
+	screen := &z80.ScreenOptions{}
+
 	if params != nil {
 		if v, ok := params["name"]; ok && v != nil {
 			if n, ok := v.(string); ok {
 				name = n
 			}
 		}
+		if v, ok := params["screen"]; ok && v != nil {
+			if m, ok := v.(string); ok {
+				screen.Mode = m
+			}
+		}
+		if v, ok := params["screenData"]; ok && v != nil {
+			if d, ok := v.([]byte); ok {
+				screen.Data = d
+			}
+		}
 	}
 
-	cart, err := z80.LoadZ80(in, name)
+	cart, err := z80.LoadZ80(in, name, screen)
 	if err != nil {
 		return nil, err
 	}
@@ -66,5 +78,14 @@ func (z *Z80) Read(in io.Reader, strict, repair bool,
 func (z *Z80) Write(cart base.Cartridge, out io.Writer,
 	params map[string]interface{}) error {
 
-	return NewMDR().Write(cart, out, params)
+	prefix := ""
+	if params != nil {
+		if v, ok := params["prefix"]; ok && v != nil {
+			if p, ok := v.(string); ok {
+				prefix = p
+			}
+		}
+	}
+
+	return z80.ExportZ80(cart, prefix, out)
 }
@@ -28,6 +28,7 @@ package z80
 
 import (
 	"bufio"
+	"fmt"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -442,6 +443,99 @@ func zxLayout(pos int) int {
 	return int(p) // return byte position as an int
 }
 
+/*
+	unzxsc is the counterpart of zxsc, and decompresses in into out, which needs
+	to have the size of the original data. Each control byte is followed either
+	by a run of literals, or describes a match with the length in its top three
+	bits, and the high byte of the offset in the lower five bits. Long matches
+	carry an extra length byte, followed by the low byte of the offset. 0xff
+	marks the end.
+
+	In screen mode, data is stored in zxLayout order starting with the
+	attributes, and offsets are absolute positions within display memory.
+	Otherwise, offsets are relative to the current position.
+*/
+func unzxsc(in, out []byte, screen bool) error {
+
+	pos := 0
+	if screen {
+		pos = 6144
+	}
+
+	next := func(p int) int {
+		if screen {
+			return zxLayout(p)
+		}
+		return p + 1
+	}
+
+	ix := 0
+	read := func() (byte, error) {
+		if ix >= len(in) {
+			return 0, fmt.Errorf("compressed data truncated")
+		}
+		ix++
+		return in[ix-1], nil
+	}
+
+	for {
+		ctrl, err := read()
+		if err != nil {
+			return err
+		}
+
+		if ctrl == 0xff { // end marker
+			break
+		}
+
+		if ctrl < 0x20 { // literal run
+			for n := 0; n <= int(ctrl); n++ {
+				if pos >= len(out) {
+					return fmt.Errorf("decompressed data overflow")
+				}
+				if out[pos], err = read(); err != nil {
+					return err
+				}
+				pos = next(pos)
+			}
+			continue
+		}
+
+		length := int(ctrl>>5) + 2
+		if ctrl>>5 == 7 {
+			b, err := read()
+			if err != nil {
+				return err
+			}
+			length += int(b)
+		}
+
+		b, err := read()
+		if err != nil {
+			return err
+		}
+		offset := int(ctrl&0x1f)<<8 | int(b)
+		if !screen {
+			offset = pos - offset - 1
+		}
+
+		for n := 0; n < length; n++ {
+			if pos >= len(out) || offset < 0 || offset >= len(out) {
+				return fmt.Errorf("decompressed data overflow")
+			}
+			out[pos] = out[offset]
+			pos = next(pos)
+			offset = next(offset)
+		}
+	}
+
+	if pos != len(out) {
+		return fmt.Errorf("decompressed data incomplete")
+	}
+
+	return nil
+}
+
 // check compression to ensure it can be decompressed within Spectrum memory
 func decompressf(comp []byte, compSize int) int {
 
@@ -48,6 +48,18 @@ var mdrBl48k = []byte{
 // --- 128k loader ------------------------------------------------------------
 const ix128kBrd = 16
 const ix128kPap = 22
+const ix128kUsr = 33 // hidden number of randomize usr 23964
+
+// --- loader statements for loading screen, contained in both loaders ---------
+// : load *"m";d;"S" code
+var loadScreen = []byte{
+	0x3a, 0xef, 0x2a, 0x22, 0x6d, 0x22, 0x3b, 0x64, 0x3b, 0x22, 0x53, 0x22, 0xaf,
+}
+
+// : randomize usr 25088
+var unpackScreen = []byte{
+	0x3a, 0xf9, 0xc0, 0x30, 0x0e, 0x00, 0x00, 0x00, 0x62, 0x00,
+}
 
 var mdrBl128k = []byte{
 	0x00, 0x00, 0x8e, 0x00, 0xfd, 0x30, 0x0e, 0x00, //(0)
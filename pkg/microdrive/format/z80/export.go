@@ -0,0 +1,290 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package z80
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+)
+
+// length of version 3 Z80 header, including additional header block
+const z80V3HeaderLength = 86
+
+// memory pages of 128K snapshots, as page number and position within main
+var pages128k = [][2]int{
+	{0, 32768}, {1, 49152}, {2, 16384}, {3, 65536},
+	{4, 81920}, {5, 0}, {6, 98304}, {7, 114688},
+}
+
+// memory pages of 48K snapshots, as page number in Z80 file and position
+// within main; pages need to be in ascending order, since loading stops after
+// the highest page
+var pages48k = [][2]int{{4, 16384}, {5, 32768}, {8, 0}}
+
+/*
+	ExportZ80 reconstructs a version 3 Z80 snapshot from a cartridge created by
+	the Z80 converter, and writes it to w. This reverses the conversion: the
+	machine state is taken from the launcher L, main memory from the screen
+	file S and the main block M, and for 128K snapshots the remaining pages from
+	files 1 through 5. For multi-snapshot cartridges, prefix selects the
+	snapshot, otherwise it is empty. If the cartridge has no screen file,
+	display memory is left blank.
+*/
+func ExportZ80(cart base.Cartridge, prefix string, w io.Writer) error {
+
+	files := make(map[string]base.File)
+	for _, f := range cart.Files() {
+		files[strings.TrimSpace(f.Name())] = f
+	}
+
+	get := func(name string) ([]byte, error) {
+		f, ok := files[prefix+name]
+		if !ok {
+			return nil, fmt.Errorf(
+				"file %s missing, not a converted Z80 snapshot", prefix+name)
+		}
+		if !f.IsComplete() {
+			return nil, fmt.Errorf("file %s is incomplete", prefix+name)
+		}
+		return f.Data(), nil
+	}
+
+	launcher, err := get("L")
+	if err != nil {
+		return err
+	}
+	if len(launcher) < launchMDRFullLen ||
+		!bytes.Equal(launcher[7:ixLCF], launchMDRFull[7:ixLCF]) {
+		return fmt.Errorf("file %sL is not a Z80 snapshot launcher", prefix)
+	}
+
+	delta := int(launcher[ixLCS]) | int(launcher[ixLCS+1])<<8
+	if delta > BGap || len(launcher) < launchMDRFullLen+delta {
+		return fmt.Errorf("invalid delta in launcher: %d", delta)
+	}
+
+	_, otek := files[prefix+"1"]
+
+	size := 49152
+	if otek {
+		size = 131072
+	}
+	main := make([]byte, size)
+
+	if data, err := get("M"); err != nil {
+		return err
+	} else if err := unzxsc(data, main[6912:49152-delta], false); err != nil {
+		return fmt.Errorf("main block corrupted: %v", err)
+	}
+	copy(main[49152-delta:49152], launcher[launchMDRFullLen:])
+
+	if f, ok := files[prefix+"S"]; ok {
+		scr, err := GetScreen(f)
+		if err != nil {
+			return err
+		}
+		copy(main, scr)
+	} else {
+		log.Warnf("no screen file %sS, display memory will be blank", prefix)
+	}
+
+	if otek {
+		if err := unpackPages(get, main); err != nil {
+			return err
+		}
+	}
+
+	border := byte(0)
+	if code, err := get("run"); err == nil {
+		// border VAL "n"
+		if ix := bytes.Index(code, []byte{0xe7, 0xb0, 0x22}); ix > -1 &&
+			ix+3 < len(code) {
+			border = (code[ix+3] - '0') & 0x07
+		}
+	}
+
+	return writeZ80(w, launcher, border, otek, main)
+}
+
+// unpackPages decompresses the page files of a 128K snapshot into main
+func unpackPages(get func(name string) ([]byte, error), main []byte) error {
+
+	for n := 1; n <= 5; n++ {
+
+		data, err := get(fmt.Sprintf("%d", n))
+		if err != nil {
+			return err
+		}
+
+		page := 1
+		if n == 1 {
+			if !bytes.HasPrefix(data, unpack) {
+				return fmt.Errorf("page file 1 has no unpacker")
+			}
+			data = data[len(unpack):]
+		} else {
+			if len(data) < 1 {
+				return fmt.Errorf("page file %d is empty", n)
+			}
+			page = int(data[0] & 0x07)
+			data = data[1:]
+		}
+
+		pos := -1
+		for _, p := range pages128k {
+			if p[0] == page {
+				pos = p[1]
+			}
+		}
+		if pos < 49152 {
+			return fmt.Errorf("page file %d has invalid page %d", n, page)
+		}
+
+		if err := unzxsc(data, main[pos:pos+16384], false); err != nil {
+			return fmt.Errorf("page %d corrupted: %v", page, err)
+		}
+	}
+
+	return nil
+}
+
+// writeZ80 writes a version 3 Z80 snapshot, taking the machine state from the
+// launcher, and memory pages from main
+func writeZ80(w io.Writer, launcher []byte, border byte, otek bool,
+	main []byte) error {
+
+	h := make([]byte, z80V3HeaderLength)
+	l := launcher
+
+	h[0] = l[ixA]
+	h[1] = l[ixIF]
+	h[2], h[3] = l[ixBC], l[ixBC+1]
+	h[4], h[5] = l[ixHL], l[ixHL+1]
+	// PC at 6 & 7 is zero for version 2 & 3
+	h[8], h[9] = l[ixSP], l[ixSP+1]
+	h[10] = l[ixIF+1]           // I register
+	h[11] = (l[ixR] + 6) & 0x7f // launcher has R reduced by 6
+	h[12] = l[ixR]>>7 | border<<1
+	h[13], h[14] = l[ixDE], l[ixDE+1]
+	h[15], h[16] = l[ixBCA], l[ixBCA+1]
+	h[17], h[18] = l[ixDEA], l[ixDEA+1]
+	h[19], h[20] = l[ixHLA], l[ixHLA+1]
+	h[21], h[22] = l[ixAFA+1], l[ixAFA]
+	h[23], h[24] = l[ixIY], l[ixIY+1]
+	h[25], h[26] = l[ixIX], l[ixIX+1]
+
+	if l[ixEI] == 0xfb {
+		h[27], h[28] = 1, 1
+	}
+
+	switch l[ixIM] {
+	case 0x56:
+		h[29] = 1
+	case 0x5e:
+		h[29] = 2
+	}
+
+	h[30] = z80V3HeaderLength - 32 // length of additional header block
+	h[32], h[33] = l[ixJP], l[ixJP+1]
+
+	pages := pages48k
+	if otek {
+		h[34] = 4 // 128K
+		h[35] = l[ixOUT]
+		pages = make([][2]int, len(pages128k))
+		for ix, p := range pages128k {
+			pages[ix] = [2]int{p[0] + 3, p[1]}
+		}
+	}
+
+	h[61], h[62] = 0xff, 0xff // ROM at 0 - 16383
+
+	if _, err := w.Write(h); err != nil {
+		return err
+	}
+
+	for _, p := range pages {
+		if err := writePage(w, p[0], main[p[1]:p[1]+16384]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writePage writes a memory page to a Z80 snapshot, compressed if that makes
+// it smaller
+func writePage(w io.Writer, page int, data []byte) error {
+
+	var b bytes.Buffer
+	comp := compressZ80(data)
+
+	if len(comp) < len(data) {
+		writeUInt16(&b, len(comp))
+		b.WriteByte(byte(page))
+		b.Write(comp)
+	} else {
+		writeUInt16(&b, 0xffff)
+		b.WriteByte(byte(page))
+		b.Write(data)
+	}
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// compressZ80 is the counterpart of decompressZ80: runs of at least five equal
+// bytes, or of at least two 0xed, are stored as 0xed 0xed {count} {byte}. A
+// byte directly following a single 0xed is never part of a run.
+func compressZ80(data []byte) []byte {
+
+	var b bytes.Buffer
+
+	for i := 0; i < len(data); {
+
+		c := data[i]
+		run := 1
+		for i+run < len(data) && data[i+run] == c && run < 255 {
+			run++
+		}
+
+		if run >= 5 || (c == 0xed && run >= 2) {
+			b.Write([]byte{0xed, 0xed, byte(run), c})
+			i += run
+			continue
+		}
+
+		b.WriteByte(c)
+		i++
+		if c == 0xed && i < len(data) {
+			b.WriteByte(data[i])
+			i++
+		}
+	}
+
+	return b.Bytes()
+}
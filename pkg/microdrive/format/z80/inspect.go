@@ -0,0 +1,147 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package z80
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/if1"
+)
+
+// FileStats describes a file that the converter would write to cartridge
+type FileStats struct {
+	Name    string `json:"name"`
+	Length  int    `json:"length"`
+	Sectors int    `json:"sectors"`
+}
+
+/*
+	Stats describes the result of converting a Z80 snapshot into a cartridge,
+	without actually creating it. Delta is the number of bytes at the end of the
+	main block that are carried by the launcher, since decompression would
+	otherwise overwrite data not yet decompressed. It must not exceed MaxDelta.
+	The compressed main block must not exceed MainMax, to stay clear of the
+	loader. Problem is set if the snapshot cannot be converted. In that case,
+	Files and Sectors only cover the files up to where conversion failed.
+*/
+type Stats struct {
+	Version    int          `json:"version"`
+	Model      string       `json:"model"`
+	Screen     string       `json:"screen"`
+	Files      []*FileStats `json:"files"`
+	Delta      int          `json:"delta"`
+	MaxDelta   int          `json:"maxDelta"`
+	MainLength int          `json:"mainLength"`
+	MainMax    int          `json:"mainMax"`
+	Sectors    int          `json:"sectors"`
+	Capacity   int          `json:"capacity"`
+	Problem    string       `json:"problem,omitempty"`
+}
+
+//
+func (s *Stats) addFile(name string, length, sectors int) {
+	s.Files = append(s.Files,
+		&FileStats{Name: name, Length: length, Sectors: sectors})
+	s.Sectors += sectors
+}
+
+// Fits determines whether the snapshot can be converted and fits onto a
+// cartridge
+func (s *Stats) Fits() bool {
+	return s.Problem == ""
+}
+
+//
+func (s *Stats) Emit(w io.Writer) {
+
+	fmt.Fprintf(w, "\nsnapshot version: %d, %s\n", s.Version, s.Model)
+	fmt.Fprintf(w, "screen:           %s\n\n", s.Screen)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "file\tlength\tsectors\t")
+	for _, f := range s.Files {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t\n", f.Name, f.Length, f.Sectors)
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nmain block:       %d of max %d bytes\n",
+		s.MainLength, s.MainMax)
+	fmt.Fprintf(w, "delta:            %d of max %d bytes\n",
+		s.Delta, s.MaxDelta)
+	fmt.Fprintf(w, "sectors:          %d of %d\n\n", s.Sectors, s.Capacity)
+
+	if s.Fits() {
+		fmt.Fprintln(w, "snapshot fits onto cartridge")
+	} else {
+		fmt.Fprintf(w, "snapshot does not fit: %s\n", s.Problem)
+	}
+}
+
+/*
+	InspectZ80 reads a Z80 snapshot and determines what converting it into a
+	cartridge would yield, without creating the cartridge. An error is only
+	returned if the snapshot cannot be read at all. If it cannot be converted,
+	or would not fit onto a cartridge, this is given as the problem in the
+	returned stats.
+*/
+func InspectZ80(in io.Reader, screen *ScreenOptions) (*Stats, error) {
+
+	if err := screen.validate(); err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{
+		Screen:   ScreenPacked,
+		MaxDelta: BGap,
+		Capacity: if1.SectorCount,
+	}
+
+	snap := &snapshot{screenMode: ScreenPacked, inspect: stats}
+	if screen != nil {
+		if screen.Mode != "" {
+			snap.screenMode = screen.Mode
+		}
+		snap.screen = screen.Data
+	}
+	stats.Screen = snap.screenMode
+
+	if err := snap.unpack(in); err != nil {
+		return nil, fmt.Errorf("error unpacking Z80 snapshot: %v", err)
+	}
+
+	stats.Version = snap.version
+	stats.Model = "48K"
+	if snap.otek {
+		stats.Model = "128K"
+	}
+
+	if err := snap.packFiles(""); err != nil {
+		stats.Problem = err.Error()
+	} else if stats.Sectors > stats.Capacity {
+		stats.Problem = fmt.Sprintf(
+			"needs %d sectors, but cartridge has only %d",
+			stats.Sectors, stats.Capacity)
+	}
+
+	return stats, nil
+}
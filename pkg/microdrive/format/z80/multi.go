@@ -0,0 +1,148 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package z80
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/if1"
+)
+
+// maximum number of snapshots on a multi-snapshot cartridge, limited by using
+// single digits for selecting them in the menu
+const MaxGames = 9
+
+// Game is a Z80 snapshot to be placed on a multi-snapshot cartridge
+type Game struct {
+	Name string
+	In   io.Reader
+}
+
+/*
+	LoadMultiZ80 reads several 48K Z80 snapshots and converts them into one
+	cartridge. The files of each snapshot are prefixed with the snapshot's
+	number in the menu, e.g. 1run, 1S, 1M, and 1L for the first one. The run
+	program of the cartridge is a BASIC menu listing all snapshots, which loads
+	the loader of the selected one. Snapshots are added in the given order, and
+	an error is returned if they don't all fit onto the cartridge.
+*/
+func LoadMultiZ80(games []*Game, name string, screen *ScreenOptions) (
+	base.Cartridge, error) {
+
+	if len(games) == 0 {
+		return nil, fmt.Errorf("no snapshots given")
+	}
+	if len(games) > MaxGames {
+		return nil, fmt.Errorf("too many snapshots, maximum is %d", MaxGames)
+	}
+	if err := screen.validate(); err != nil {
+		return nil, err
+	}
+
+	cart := if1.NewCartridge()
+	cart.SetName(fmt.Sprintf("%.10s", fmt.Sprintf("%-10s", name)))
+
+	menu := &snapshot{cart: cart}
+	prog, autostart, err := if1.Tokenize(
+		strings.NewReader(menuListing(cart.Name(), games)))
+	if err != nil {
+		return nil, fmt.Errorf("error creating menu: %v", err)
+	}
+	log.Debugf("menu file: %d", len(prog))
+	if err := menu.addToCartridge(fmt.Sprintf("%-10s", "run"), prog,
+		len(prog), 23813, autostart, 0x00); err != nil {
+		return nil, err
+	}
+
+	for ix, g := range games {
+
+		snap := &snapshot{screenMode: ScreenPacked, cart: cart}
+		if screen != nil {
+			if screen.Mode != "" {
+				snap.screenMode = screen.Mode
+			}
+			snap.screen = screen.Data
+		}
+
+		if err := snap.unpack(g.In); err != nil {
+			return nil, fmt.Errorf("error unpacking Z80 snapshot %s: %v",
+				g.Name, err)
+		}
+		if snap.otek {
+			return nil, fmt.Errorf(
+				"%s is a 128K snapshot, only 48K snapshots are supported", g.Name)
+		}
+
+		if err := snap.packFiles(fmt.Sprintf("%d", ix+1)); err != nil {
+			return nil, fmt.Errorf(
+				"error storing Z80 snapshot %s into cartridge: %v", g.Name, err)
+		}
+	}
+
+	if err := padCartridge(cart); err != nil {
+		return nil, err
+	}
+
+	return cart, nil
+}
+
+// menuListing creates the BASIC listing of the menu program
+func menuListing(title string, games []*Game) string {
+
+	var b bytes.Buffer
+
+	fmt.Fprintln(&b, "#autostart 10")
+	fmt.Fprintln(&b, "10 BORDER 0: PAPER 0: INK 7: CLS")
+	fmt.Fprintf(&b, "20 PRINT AT 1,2; INK 6;\"%s\"\n", menuText(title, 28))
+
+	for ix, g := range games {
+		fmt.Fprintf(&b, "%d PRINT AT %d,2; INK 5;\"%d\"; INK 7;\"  %s\"\n",
+			30+ix, 4+2*ix, ix+1, menuText(g.Name, 25))
+	}
+
+	fmt.Fprintf(&b, "50 PRINT AT 21,2;\"Select 1-%d\"\n", len(games))
+	fmt.Fprintln(&b, "60 LET d=PEEK 23766")
+	fmt.Fprintf(&b,
+		"70 LET k$=INKEY$: IF k$<\"1\" OR k$>\"%d\" THEN GO TO 70\n", len(games))
+	fmt.Fprintln(&b, "80 CLS : LOAD *\"m\";d;k$+\"run\"")
+
+	return b.String()
+}
+
+// menuText makes s safe for use in a string literal in the menu listing
+func menuText(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' || r == '"' || r == '{' || r == '}' {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(s))
+	if len(s) > max {
+		s = s[:max]
+	}
+	return s
+}
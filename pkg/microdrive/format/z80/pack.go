@@ -29,6 +29,7 @@ package z80
 import (
 	"bytes"
 	"fmt"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 
@@ -43,6 +44,33 @@ func (s *snapshot) pack() error {
 	s.cart = if1.NewCartridge()
 	s.cart.SetName(s.name)
 
+	if err := s.packFiles(""); err != nil {
+		return err
+	}
+
+	return padCartridge(s.cart)
+}
+
+// packFiles adds the files of this snapshot to the cartridge, with prefix
+// prepended to their names
+func (s *snapshot) packFiles(prefix string) error {
+
+	if s.screenMode == ScreenNone {
+		if err := s.removeFromLoader(loadScreen, unpackScreen); err != nil {
+			return err
+		}
+	} else if s.screenMode == ScreenPlain {
+		if err := s.removeFromLoader(unpackScreen); err != nil {
+			return err
+		}
+	}
+
+	if prefix != "" {
+		if err := s.prefixLoader(prefix); err != nil {
+			return err
+		}
+	}
+
 	// write 'run' file
 	start := 23813
 	param := 0
@@ -50,25 +78,49 @@ func (s *snapshot) pack() error {
 	length := len(s.code)
 
 	log.Debugf("run file: %d", length)
-	if err := s.addToCartridge(fmt.Sprintf("%-10s", "run"), s.code, length,
-		start, param, 0x00); err != nil {
+	if err := s.addToCartridge(fmt.Sprintf("%-10s", prefix+"run"), s.code,
+		length, start, param, 0x00); err != nil {
 		return err
 	}
 
-	comp := make([]byte, 6912+216+109)
+	screen := s.main[:6912]
+	if s.screen != nil {
+		screen = s.screen
+	}
 
-	// screen
-	length = zxsc(s.main, comp[len(scrLoad):], 6912, true)
-	length += copy(comp, scrLoad) // add m/c
+	var comp []byte
 
-	// write screen
-	start = 25088
-	param = 0xffff
+	switch s.screenMode {
 
-	log.Debugf("screen file: %d", length)
-	if err := s.addToCartridge(fmt.Sprintf("%-10s", "S"), comp, length, start,
-		param, 0x03); err != nil {
-		return err
+	case ScreenNone:
+		log.Debug("no screen file")
+
+	case ScreenPlain:
+		length = len(screen)
+		start = 16384
+		param = 0xffff
+		log.Debugf("plain screen file: %d", length)
+		if err := s.addToCartridge(fmt.Sprintf("%-10s", prefix+"S"), screen,
+			length, start, param, 0x03); err != nil {
+			return err
+		}
+
+	default:
+		comp = make([]byte, 6912+216+109)
+
+		// screen
+		length = zxsc(screen, comp[len(scrLoad):], 6912, true)
+		length += copy(comp, scrLoad) // add m/c
+
+		// write screen
+		start = 25088
+		param = 0xffff
+
+		log.Debugf("screen file: %d", length)
+		if err := s.addToCartridge(fmt.Sprintf("%-10s", prefix+"S"), comp,
+			length, start, param, 0x03); err != nil {
+			return err
+		}
 	}
 
 	// otek pages
@@ -133,6 +185,7 @@ func (s *snapshot) pack() error {
 	// main
 	comp = make([]byte, 42240+1320)
 	delta := 3
+	maxSize := 40704 // 0x6100 lowest point
 
 	for {
 		//delta++
@@ -140,6 +193,11 @@ func (s *snapshot) pack() error {
 		length = zxsc(s.main[6912:], comp, 42240-delta, false)
 		i := decompressf(comp, length)
 		delta += i
+		if s.inspect != nil {
+			s.inspect.Delta = delta
+			s.inspect.MainLength = length
+			s.inspect.MainMax = maxSize - delta
+		}
 		if delta > BGap {
 			return fmt.Errorf(
 				"cannot compress main block, delta too large: %d > %d",
@@ -150,7 +208,6 @@ func (s *snapshot) pack() error {
 		}
 	}
 
-	maxSize := 40704 // 0x6100 lowest point
 	if length > maxSize-delta {
 		// too big to fit in Spectrum memory
 		return fmt.Errorf(
@@ -162,8 +219,8 @@ func (s *snapshot) pack() error {
 	start = 65536 - length
 	param = 0xffff
 	log.Debugf("main file: %d (delta: %d)", length, delta)
-	if err := s.addToCartridge(fmt.Sprintf("%-10s", "M"), comp, length, start,
-		param, 0x03); err != nil {
+	if err := s.addToCartridge(fmt.Sprintf("%-10s", prefix+"M"), comp, length,
+		start, param, 0x03); err != nil {
 		return err
 	}
 
@@ -181,13 +238,61 @@ func (s *snapshot) pack() error {
 	length = launchMDRFullLen + delta
 	log.Debugf("launcher file: %d", length)
 	start = 16384
-	if err := s.addToCartridge(fmt.Sprintf("%-10s", "L"), s.launcher, length,
-		start, param, 0x03); err != nil {
-		return err
+	return s.addToCartridge(fmt.Sprintf("%-10s", prefix+"L"), s.launcher,
+		length, start, param, 0x03)
+}
+
+// removeFromLoader removes the given statements from the first line of the
+// BASIC loader
+func (s *snapshot) removeFromLoader(statements ...[]byte) error {
+	for _, st := range statements {
+		if err := s.replaceInLoader(st, nil); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	if err := padCartridge(s.cart); err != nil {
-		return err
+// prefixLoader changes the names of the files loaded by the BASIC loader, by
+// prepending prefix
+func (s *snapshot) prefixLoader(prefix string) error {
+	for _, n := range []byte{'S', 'M', 'L'} {
+		if n == 'S' && s.screenMode == ScreenNone {
+			continue
+		}
+		if err := s.replaceInLoader([]byte{';', '"', n, '"'},
+			[]byte(fmt.Sprintf(";\"%s%c\"", prefix, n))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceInLoader replaces the first occurrence of old in the first line of the
+// BASIC loader with new, adjusting line length and, for the 128k loader, the
+// address of the m/c code contained in the following line
+func (s *snapshot) replaceInLoader(old, new []byte) error {
+
+	ix := bytes.Index(s.code, old)
+	if ix < 0 {
+		return fmt.Errorf("statement not found in loader")
+	}
+
+	code := make([]byte, 0, len(s.code)-len(old)+len(new))
+	code = append(code, s.code[:ix]...)
+	code = append(code, new...)
+	s.code = append(code, s.code[ix+len(old):]...)
+
+	delta := len(new) - len(old)
+
+	lineLen := int(s.code[2]) + int(s.code[3])<<8 + delta
+	s.code[2] = byte(lineLen)
+	s.code[3] = byte(lineLen >> 8)
+
+	if s.otek {
+		usr := int(s.code[ix128kUsr]) + int(s.code[ix128kUsr+1])<<8 + delta
+		s.code[ix128kUsr] = byte(usr)
+		s.code[ix128kUsr+1] = byte(usr >> 8)
 	}
 
 	return nil
@@ -211,6 +316,11 @@ func (s *snapshot) addToCartridge(file string, data []byte,
 	// work out how many sectors needed
 	numSec := ((length + 9) / 512) + 1 // +9 for initial header
 
+	if s.inspect != nil {
+		s.inspect.addFile(strings.TrimSpace(file), length, numSec)
+		return nil
+	}
+
 	for sequence := 0; sequence < numSec; sequence++ {
 
 		var b bytes.Buffer
@@ -219,6 +329,10 @@ func (s *snapshot) addToCartridge(file string, data []byte,
 		raw.WriteSyncPattern(&b)
 		b.WriteByte(0x01)
 		secIx := s.cart.AdvanceAccessIx(false)
+		if s.cart.GetSectorAt(secIx) != nil {
+			return fmt.Errorf("cartridge full, cannot add file %s",
+				strings.TrimSpace(file))
+		}
 		b.WriteByte(byte(secIx + 1))
 		b.WriteByte(0x00)
 		b.WriteByte(0x00)
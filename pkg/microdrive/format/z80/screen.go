@@ -0,0 +1,73 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package z80
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/if1"
+)
+
+// GetScreen returns the 6912 bytes of Spectrum display memory contained in
+// file f. This can either be a plain SCREEN$ file, or a screen file as written
+// by the Z80 converter, i.e. compressed and prefixed with its loader.
+func GetScreen(f base.File) ([]byte, error) {
+
+	data := f.Data()
+
+	if IsPackedScreen(data) {
+		return UnpackScreen(data)
+	}
+
+	info := if1.NewFileInfo(f)
+	if info.Type == "code" && info.Start == if1.ScreenStart &&
+		len(data) >= if1.ScreenLength {
+		return data[:if1.ScreenLength], nil
+	}
+
+	if len(data) == if1.ScreenLength {
+		return data, nil
+	}
+
+	return nil, fmt.Errorf("file %+q is not a screen file", f.Name())
+}
+
+// IsPackedScreen determines whether data is a screen file as written by the
+// Z80 converter.
+func IsPackedScreen(data []byte) bool {
+	return len(data) > len(scrLoad) && bytes.Equal(data[:len(scrLoad)], scrLoad)
+}
+
+// UnpackScreen decompresses a screen file written by the Z80 converter
+func UnpackScreen(data []byte) ([]byte, error) {
+
+	if !IsPackedScreen(data) {
+		return nil, fmt.Errorf("not a packed screen")
+	}
+
+	out := make([]byte, if1.ScreenLength)
+	if err := unzxsc(data[len(scrLoad):], out, true); err != nil {
+		return nil, fmt.Errorf("packed screen corrupted: %v", err)
+	}
+	return out, nil
+}
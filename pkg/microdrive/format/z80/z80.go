@@ -31,6 +31,7 @@ import (
 	"io"
 
 	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/if1"
 )
 
 //
@@ -45,8 +46,51 @@ type snapshot struct {
 	bank       []int
 	bankEnd    byte
 	//
-	name string
-	cart base.Cartridge
+	screenMode string
+	screen     []byte
+	//
+	name    string
+	cart    base.Cartridge
+	inspect *Stats
+}
+
+// loading screen modes
+const (
+	// the screen is stored compressed, and unpacked by a small m/c routine;
+	// this is the default
+	ScreenPacked = "packed"
+	// the screen is stored as is and loaded directly into display memory
+	ScreenPlain = "plain"
+	// no loading screen is stored, saving sectors
+	ScreenNone = "none"
+)
+
+/*
+	ScreenOptions control the loading screen written to the cartridge. Mode is
+	one of the screen modes, with ScreenPacked used if empty. Data optionally
+	holds an alternate loading screen in SCR format, i.e. 6912 bytes of display
+	memory. If it is nil, the display memory of the snapshot is used.
+*/
+type ScreenOptions struct {
+	Mode string
+	Data []byte
+}
+
+//
+func (o *ScreenOptions) validate() error {
+	if o == nil {
+		return nil
+	}
+	switch o.Mode {
+	case "", ScreenPacked, ScreenPlain, ScreenNone:
+	default:
+		return fmt.Errorf("invalid screen mode: %s", o.Mode)
+	}
+	if o.Data != nil && len(o.Data) != if1.ScreenLength {
+		return fmt.Errorf("invalid screen data, length is %d instead of %d",
+			len(o.Data), if1.ScreenLength)
+	}
+	return nil
 }
 
 //
@@ -59,9 +103,21 @@ func (s *snapshot) setName(n string) {
 
 // reads Z80 snapshot and converts it into a cartridge on the fly
 //
-func LoadZ80(in io.Reader, name string) (base.Cartridge, error) {
+func LoadZ80(in io.Reader, name string, screen *ScreenOptions) (
+	base.Cartridge, error) {
+
+	if err := screen.validate(); err != nil {
+		return nil, err
+	}
+
+	snap := &snapshot{screenMode: ScreenPacked}
+	if screen != nil {
+		if screen.Mode != "" {
+			snap.screenMode = screen.Mode
+		}
+		snap.screen = screen.Data
+	}
 
-	snap := &snapshot{}
 	if err := snap.unpack(in); err != nil {
 		return nil, fmt.Errorf("error unpacking Z80 snapshot: %v", err)
 	}
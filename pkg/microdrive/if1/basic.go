@@ -0,0 +1,631 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package if1
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// special characters within BASIC program lines
+const (
+	basicNumber  = 0x0e // followed by hidden 5 byte number
+	basicNewline = 0x0d
+	basicQuote   = '"'
+	basicREM     = 0xea
+)
+
+// BASIC program lines with numbers beyond this are variables
+const basicMaxLine = 9999
+
+//
+var tokens = func() map[byte]string {
+	ret := make(map[byte]string)
+	for ix := 0; ix < len(keywords); ix += 2 {
+		ret[keywords[ix][0]] = keywords[ix+1]
+	}
+	return ret
+}()
+
+// colour and position control codes, with their number of parameters
+var controlCodes = map[byte]struct {
+	name   string
+	params int
+}{
+	0x10: {"INK", 1},
+	0x11: {"PAPER", 1},
+	0x12: {"FLASH", 1},
+	0x13: {"BRIGHT", 1},
+	0x14: {"INVERSE", 1},
+	0x15: {"OVER", 1},
+	0x16: {"AT", 2},
+	0x17: {"TAB", 2},
+}
+
+// block graphics characters 0x80 through 0x8f, as Unicode quadrants
+var blockGraphics = []rune(" ▝▘▀▗▐▚▜▖▞▌▛▄▟▙█")
+
+/*
+	Detokenize writes a readable listing of the given tokenized BASIC program
+	to w. Hidden 5 byte numbers are omitted, colour and position control codes
+	are shown symbolically in braces, e.g. {INK 2}, and user defined graphics
+	as {UDG A} through {UDG U}. Listing stops at the end of the program area,
+	i.e. when variables begin.
+*/
+func Detokenize(prog []byte, w io.Writer) error {
+
+	for pos := 0; pos+4 <= len(prog); {
+
+		num := int(binary.BigEndian.Uint16(prog[pos:]))
+		if num > basicMaxLine {
+			break
+		}
+
+		l := int(binary.LittleEndian.Uint16(prog[pos+2:]))
+		pos += 4
+		if pos+l > len(prog) {
+			return fmt.Errorf("line %d is truncated", num)
+		}
+
+		fmt.Fprintf(w, "%4d %s\n", num, detokenizeLine(prog[pos:pos+l]))
+		pos += l
+	}
+
+	return nil
+}
+
+//
+func detokenizeLine(line []byte) string {
+
+	var b strings.Builder
+	inString := false
+	inREM := false
+
+	for ix := 0; ix < len(line); ix++ {
+
+		c := line[ix]
+
+		switch {
+
+		case c == basicNewline:
+			return strings.TrimRight(b.String(), " ")
+
+		case c == basicNumber && !inString && !inREM:
+			ix += 5
+
+		case controlCodes[c].params > 0:
+			cc := controlCodes[c]
+			if ix+cc.params >= len(line) {
+				ix = len(line)
+				break
+			}
+			if c == 0x17 { // TAB takes a 16 bit column
+				fmt.Fprintf(&b, "{%s %d}", cc.name,
+					binary.LittleEndian.Uint16(line[ix+1:]))
+			} else if cc.params == 2 {
+				fmt.Fprintf(&b, "{%s %d,%d}", cc.name, line[ix+1], line[ix+2])
+			} else {
+				fmt.Fprintf(&b, "{%s %d}", cc.name, line[ix+1])
+			}
+			ix += cc.params
+
+		case c < 0x20:
+			fmt.Fprintf(&b, "{%02X}", c)
+
+		case c == basicQuote:
+			if !inREM {
+				inString = !inString
+			}
+			b.WriteByte(c)
+
+		case c == 0x60:
+			b.WriteRune('£')
+
+		case c == 0x7f:
+			b.WriteRune('©')
+
+		case c == ' ' && !inString && !inREM &&
+			strings.HasSuffix(b.String(), " "):
+			// already separated from preceding keyword
+
+		case c < 0x80:
+			b.WriteByte(c)
+
+		case c < 0x90:
+			b.WriteRune(blockGraphics[c-0x80])
+
+		case c < 0xa5:
+			fmt.Fprintf(&b, "{UDG %c}", 'A'+c-0x90)
+
+		default:
+			if !inString && c == basicREM {
+				inREM = true
+			}
+			writeToken(&b, tokens[c])
+		}
+	}
+
+	return strings.TrimRight(b.String(), " ")
+}
+
+// writeToken writes a keyword; keywords starting with a letter are separated
+// from surrounding text by spaces
+func writeToken(b *strings.Builder, t string) {
+	if t == "" || !unicode.IsLetter(rune(t[0])) {
+		b.WriteString(t)
+		return
+	}
+	if s := b.String(); len(s) > 0 && !strings.HasSuffix(s, " ") {
+		b.WriteByte(' ')
+	}
+	b.WriteString(t)
+	b.WriteByte(' ')
+}
+
+// keywords as matched by the tokenizer, longest first; includes common
+// alternative spellings
+var tokenizerKeywords = func() []keyword {
+
+	var ret []keyword
+	for code, text := range tokens {
+		ret = append(ret, keyword{text: text, code: code})
+	}
+
+	for text, code := range map[string]byte{
+		"GOTO": 0xec, "GOSUB": 0xed, "DEFFN": 0xce} {
+		ret = append(ret, keyword{text: text, code: code})
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if len(ret[i].text) != len(ret[j].text) {
+			return len(ret[i].text) > len(ret[j].text)
+		}
+		return ret[i].text < ret[j].text
+	})
+
+	return ret
+}()
+
+//
+type keyword struct {
+	text string
+	code byte
+}
+
+// NoAutoStart is the auto-start line for programs that do not start
+// automatically
+const NoAutoStart = 0xffff
+
+/*
+	Tokenize turns a BASIC listing in text form into a tokenized Spectrum
+	program. It understands the notation produced by Detokenize. Keywords are
+	recognized regardless of case. Numeric literals get their hidden 5 byte
+	form. Lines starting with # are comments, except for #autostart {line},
+	which sets the auto-start line. Tokenize returns the program, and its
+	auto-start line, or NoAutoStart if there is none.
+*/
+func Tokenize(r io.Reader) ([]byte, int, error) {
+
+	var prog []byte
+	autostart := NoAutoStart
+	last := -1
+
+	scanner := bufio.NewScanner(r)
+	for count := 1; scanner.Scan(); count++ {
+
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			f := strings.Fields(strings.ToLower(line))
+			if len(f) == 2 && f[0] == "#autostart" {
+				n, err := strconv.Atoi(f[1])
+				if err != nil || n < 0 || n > basicMaxLine {
+					return nil, 0, fmt.Errorf(
+						"line %d: invalid auto-start line: %s", count, f[1])
+				}
+				autostart = n
+			}
+			continue
+		}
+
+		numLen := 0
+		for numLen < len(line) && '0' <= line[numLen] && line[numLen] <= '9' {
+			numLen++
+		}
+		num, err := strconv.Atoi(line[:numLen])
+		if err != nil || num > basicMaxLine {
+			return nil, 0, fmt.Errorf("line %d: invalid line number", count)
+		}
+		if num <= last {
+			return nil, 0, fmt.Errorf(
+				"line %d: line number %d not ascending", count, num)
+		}
+		last = num
+
+		text, err := tokenizeLine(strings.TrimLeft(line[numLen:], " "))
+		if err != nil {
+			return nil, 0, fmt.Errorf("line %d: %v", count, err)
+		}
+
+		hd := make([]byte, 4)
+		binary.BigEndian.PutUint16(hd, uint16(num))
+		binary.LittleEndian.PutUint16(hd[2:], uint16(len(text)))
+		prog = append(prog, hd...)
+		prog = append(prog, text...)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return prog, autostart, nil
+}
+
+//
+func tokenizeLine(line string) ([]byte, error) {
+
+	var ret []byte
+	src := []rune(line)
+
+	inString := false
+	inREM := false
+	ident := false   // within a variable name
+	inDefFn := false // within parameter list of DEF FN
+	var prev byte    // previous token
+	spaces := 0      // pending spaces
+
+	flush := func() {
+		for ; spaces > 0; spaces-- {
+			ret = append(ret, ' ')
+		}
+	}
+
+	for ix := 0; ix < len(src); {
+
+		c := src[ix]
+
+		if c == ' ' && !inString && !inREM {
+			spaces++
+			ix++
+			ident = false
+			continue
+		}
+
+		// special characters
+		if b, l, ok := matchSpecial(src[ix:]); ok {
+			flush()
+			ret = append(ret, b...)
+			ix += l
+			ident = false
+			continue
+		}
+
+		if c == '"' && !inREM {
+			flush()
+			inString = !inString
+			ret = append(ret, '"')
+			ix++
+			continue
+		}
+
+		if inString || inREM {
+			if c > 0x7f {
+				return nil, fmt.Errorf("unsupported character %q", c)
+			}
+			ret = append(ret, byte(c))
+			ix++
+			continue
+		}
+
+		// within a variable name, only operators such as <= are keywords
+		if kw, l := matchKeyword(src[ix:], !ident); l > 0 {
+			if !isLetter(rune(kw.text[0])) {
+				flush()
+			}
+			spaces = 0
+			ret = append(ret, kw.code)
+			ix += l
+			prev = kw.code
+			ident = false
+			inREM = kw.code == basicREM
+			inDefFn = kw.code == 0xce
+			// skip spaces following a keyword
+			for ix < len(src) && src[ix] == ' ' && !inREM {
+				ix++
+			}
+			if inREM && ix < len(src) && src[ix] == ' ' {
+				ix++
+			}
+			continue
+		}
+
+		if !ident {
+			if l := numberLength(src[ix:]); l > 0 {
+				flush()
+				lit := string(src[ix : ix+l])
+				var val float64
+				var err error
+				if prev == 0xc4 { // BIN
+					var v uint64
+					v, err = strconv.ParseUint(lit, 2, 16)
+					val = float64(v)
+				} else {
+					val, err = strconv.ParseFloat(lit, 64)
+				}
+				if err != nil {
+					return nil, fmt.Errorf("invalid number %s", lit)
+				}
+				ret = append(ret, lit...)
+				n, err := encodeNumber(val)
+				if err != nil {
+					return nil, err
+				}
+				ret = append(ret, basicNumber)
+				ret = append(ret, n...)
+				ix += l
+				prev = 0
+				continue
+			}
+		}
+
+		if c > 0x7f {
+			return nil, fmt.Errorf("unsupported character %q", c)
+		}
+
+		flush()
+		ret = append(ret, byte(c))
+		ix++
+		prev = 0
+
+		if inDefFn {
+			switch {
+			case c == ')':
+				inDefFn = false
+			case isLetter(c) && !ident && ix < len(src) &&
+				(src[ix] == ',' || src[ix] == ')'):
+				// parameters of DEF FN get placeholders for their values
+				ret = append(ret, basicNumber, 0, 0, 0, 0, 0)
+			}
+		}
+
+		ident = isLetter(c) || (ident && (c == '$' || ('0' <= c && c <= '9')))
+	}
+
+	return append(ret, basicNewline), nil
+}
+
+// matchSpecial matches characters and notations not found in ASCII, and
+// returns their Spectrum representation
+func matchSpecial(src []rune) ([]byte, int, bool) {
+
+	switch c := src[0]; {
+	case c == '£':
+		return []byte{0x60}, 1, true
+	case c == '©':
+		return []byte{0x7f}, 1, true
+	case c == '{':
+		return matchBraces(src)
+	}
+
+	for ix, g := range blockGraphics {
+		if ix > 0 && src[0] == g {
+			return []byte{byte(0x80 + ix)}, 1, true
+		}
+	}
+
+	return nil, 0, false
+}
+
+// matchBraces matches control codes, user defined graphics, and hex codes in
+// brace notation
+func matchBraces(src []rune) ([]byte, int, bool) {
+
+	end := -1
+	for ix, c := range src {
+		if c == '}' {
+			end = ix
+			break
+		}
+	}
+	if end < 0 {
+		return nil, 0, false
+	}
+
+	f := strings.Fields(strings.ReplaceAll(string(src[1:end]), ",", " "))
+
+	switch len(f) {
+
+	case 1:
+		if v, err := strconv.ParseUint(f[0], 16, 8); err == nil &&
+			len(f[0]) == 2 {
+			return []byte{byte(v)}, end + 1, true
+		}
+
+	case 2:
+		if strings.ToUpper(f[0]) == "UDG" && len(f[1]) == 1 {
+			u := strings.ToUpper(f[1])[0]
+			if 'A' <= u && u <= 'U' {
+				return []byte{0x90 + u - 'A'}, end + 1, true
+			}
+		}
+		fallthrough
+
+	case 3:
+		for code, cc := range controlCodes {
+			if cc.name != strings.ToUpper(f[0]) {
+				continue
+			}
+			var params []int
+			for _, p := range f[1:] {
+				v, err := strconv.Atoi(p)
+				if err != nil {
+					return nil, 0, false
+				}
+				params = append(params, v)
+			}
+			switch {
+			case code == 0x17 && len(params) == 1:
+				return []byte{code, byte(params[0]), byte(params[0] >> 8)},
+					end + 1, true
+			case code != 0x17 && len(params) == cc.params:
+				ret := []byte{code}
+				for _, p := range params {
+					ret = append(ret, byte(p))
+				}
+				return ret, end + 1, true
+			}
+		}
+	}
+
+	return nil, 0, false
+}
+
+// matchKeyword matches a keyword at the start of src, and returns it together
+// with the length of the match; spaces within keywords are optional. Keywords
+// starting with a letter are only considered if letters is set.
+func matchKeyword(src []rune, letters bool) (keyword, int) {
+
+	for _, kw := range tokenizerKeywords {
+
+		if !letters && isLetter(rune(kw.text[0])) {
+			continue
+		}
+
+		pos := 0
+		ok := true
+
+		for _, k := range kw.text {
+			if isSpace(k) {
+				for pos < len(src) && isSpace(src[pos]) {
+					pos++
+				}
+				continue
+			}
+			if pos >= len(src) || unicode.ToUpper(src[pos]) != k {
+				ok = false
+				break
+			}
+			pos++
+		}
+
+		// keywords ending in a letter must not be followed by one
+		if ok && isLetter(rune(kw.text[len(kw.text)-1])) &&
+			pos < len(src) && isLetter(src[pos]) {
+			ok = false
+		}
+
+		if ok {
+			return kw, pos
+		}
+	}
+
+	return keyword{}, 0
+}
+
+// numberLength returns the length of the numeric literal at the start of src,
+// or 0 if there is none
+func numberLength(src []rune) int {
+
+	digits := func(pos int) int {
+		for pos < len(src) && '0' <= src[pos] && src[pos] <= '9' {
+			pos++
+		}
+		return pos
+	}
+
+	pos := digits(0)
+	if pos < len(src) && src[pos] == '.' {
+		pos = digits(pos + 1)
+	}
+	if pos == 0 || (pos == 1 && src[0] == '.') {
+		return 0
+	}
+
+	if pos < len(src) && (src[pos] == 'e' || src[pos] == 'E') {
+		exp := pos + 1
+		if exp < len(src) && (src[exp] == '+' || src[exp] == '-') {
+			exp++
+		}
+		if end := digits(exp); end > exp {
+			pos = end
+		}
+	}
+
+	return pos
+}
+
+/*
+	encodeNumber encodes a number into the Spectrum's 5 byte form. Integers
+	from 0 through 65535 use the short form, all others the floating point
+	form, consisting of exponent byte and 32 bit mantissa, with the mantissa's
+	most significant bit replaced by the sign.
+*/
+func encodeNumber(v float64) ([]byte, error) {
+
+	if v == math.Trunc(v) && 0 <= v && v <= 65535 {
+		i := int(v)
+		return []byte{0, 0, byte(i), byte(i >> 8), 0}, nil
+	}
+
+	frac, exp := math.Frexp(math.Abs(v))
+	m := math.Round(frac * (1 << 32))
+	if m >= 1<<32 {
+		m /= 2
+		exp++
+	}
+
+	if exp+128 < 1 {
+		return []byte{0, 0, 0, 0, 0}, nil // underflow, becomes zero
+	}
+	if exp+128 > 255 {
+		return nil, fmt.Errorf("number too large: %g", v)
+	}
+
+	mant := uint32(m) & 0x7fffffff
+	if v < 0 {
+		mant |= 0x80000000
+	}
+
+	ret := []byte{byte(exp + 128), 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(ret[1:], mant)
+	return ret, nil
+}
+
+// isSpace also covers non-breaking spaces, as used in the keyword table
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\u00a0'
+}
+
+//
+func isLetter(r rune) bool {
+	return ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')
+}
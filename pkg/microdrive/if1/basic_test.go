@@ -0,0 +1,209 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+package if1
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+//
+func TestTokenizeLine(t *testing.T) {
+
+	for _, tc := range []struct {
+		line string
+		want []byte
+	}{
+		// operators directly after variable names
+		{"IF total>=10 AND total<>3 THEN GO TO 20", cat(
+			[]byte{0xfa}, []byte("total"), []byte{0xc8}, num("10", 0, 0, 10, 0, 0),
+			[]byte{0xc6}, []byte("total"), []byte{0xc9}, num("3", 0, 0, 3, 0, 0),
+			[]byte{0xcb, 0xec}, num("20", 0, 0, 20, 0, 0))},
+		{"IF a$<>b$ THEN STOP", cat(
+			[]byte{0xfa}, []byte("a$"), []byte{0xc9}, []byte("b$"),
+			[]byte{0xcb, 0xe2})},
+		{"IF x1<=y THEN PRINT x1", cat(
+			[]byte{0xfa}, []byte("x1"), []byte{0xc7}, []byte("y"),
+			[]byte{0xcb, 0xf5}, []byte("x1"))},
+		// keywords must not be found inside variable names
+		{"LET total=top", cat(
+			[]byte{0xf1}, []byte("total=top"))},
+		{"LET toast=1", cat(
+			[]byte{0xf1}, []byte("toast="), num("1", 0, 0, 1, 0, 0))},
+		// alternative spellings and case
+		{"goto 10", cat([]byte{0xec}, num("10", 0, 0, 10, 0, 0))},
+		{"GO SUB 100", cat([]byte{0xed}, num("100", 0, 0, 100, 0, 0))},
+		// strings and REM are taken literally
+		{`PRINT "a<>b TO c"`, cat([]byte{0xf5}, []byte(`"a<>b TO c"`))},
+		{"REM x>=1 GO TO", cat([]byte{0xea}, []byte("x>=1 GO TO"))},
+		// BIN literals get their value as hidden number
+		{"LET b=BIN 101", cat([]byte{0xf1}, []byte("b="), []byte{0xc4},
+			num("101", 0, 0, 5, 0, 0))},
+		// DEF FN parameters get placeholders
+		{"DEF FN f(x,y)=x*y", cat([]byte{0xce}, []byte("f(x"),
+			[]byte{0x0e, 0, 0, 0, 0, 0}, []byte(",y"),
+			[]byte{0x0e, 0, 0, 0, 0, 0}, []byte(")=x*y"))},
+	} {
+		t.Run(tc.line, func(t *testing.T) {
+			got, err := tokenizeLine(tc.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := append(tc.want, basicNewline)
+			if !bytes.Equal(got, want) {
+				t.Errorf("\nwant % x\ngot  % x", want, got)
+			}
+		})
+	}
+}
+
+//
+func TestEncodeNumber(t *testing.T) {
+
+	for _, tc := range []struct {
+		val  float64
+		want []byte
+	}{
+		{0, []byte{0x00, 0x00, 0x00, 0x00, 0x00}},
+		{1, []byte{0x00, 0x00, 0x01, 0x00, 0x00}},
+		{256, []byte{0x00, 0x00, 0x00, 0x01, 0x00}},
+		{65535, []byte{0x00, 0x00, 0xff, 0xff, 0x00}},
+		{65536, []byte{0x91, 0x00, 0x00, 0x00, 0x00}},
+		{100000, []byte{0x91, 0x43, 0x50, 0x00, 0x00}},
+		{0.5, []byte{0x80, 0x00, 0x00, 0x00, 0x00}},
+		{0.1, []byte{0x7d, 0x4c, 0xcc, 0xcc, 0xcd}},
+		{1.5, []byte{0x81, 0x40, 0x00, 0x00, 0x00}},
+		{3.141592653589793, []byte{0x82, 0x49, 0x0f, 0xda, 0xa2}},
+		{-1, []byte{0x81, 0x80, 0x00, 0x00, 0x00}},
+		{-0.5, []byte{0x80, 0x80, 0x00, 0x00, 0x00}},
+		{1e-40, []byte{0x00, 0x00, 0x00, 0x00, 0x00}},
+	} {
+		got, err := encodeNumber(tc.val)
+		if err != nil {
+			t.Errorf("%g: %v", tc.val, err)
+			continue
+		}
+		if !bytes.Equal(got, tc.want) {
+			t.Errorf("%g: want % x, got % x", tc.val, tc.want, got)
+		}
+	}
+
+	if _, err := encodeNumber(1e39); err == nil {
+		t.Errorf("want error for number too large")
+	}
+}
+
+//
+func TestTokenizeNumbers(t *testing.T) {
+
+	for _, tc := range []struct {
+		line string
+		want []byte
+	}{
+		{"PRINT 1.5", cat([]byte{0xf5}, num("1.5", 0x81, 0x40, 0, 0, 0))},
+		{"PRINT .5", cat([]byte{0xf5}, num(".5", 0x80, 0, 0, 0, 0))},
+		{"PRINT 1e5", cat([]byte{0xf5}, num("1e5", 0x91, 0x43, 0x50, 0, 0))},
+		{"PRINT 2E-1", cat([]byte{0xf5}, num("2E-1", 0x7e, 0x4c, 0xcc, 0xcc, 0xcd))},
+		{"PRINT -7", cat([]byte{0xf5}, []byte("-"), num("7", 0, 0, 7, 0, 0))},
+		{"PRINT a7", cat([]byte{0xf5}, []byte("a7"))},
+	} {
+		got, err := tokenizeLine(tc.line)
+		if err != nil {
+			t.Errorf("%s: %v", tc.line, err)
+			continue
+		}
+		want := append(tc.want, basicNewline)
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s:\nwant % x\ngot  % x", tc.line, want, got)
+		}
+	}
+}
+
+//
+func TestTokenizeRoundTrip(t *testing.T) {
+
+	src := `#autostart 10
+  10 REM test
+  20 LET total=0: LET a$="x<>y"
+  30 FOR i=1 TO 10 STEP 2: LET total=total+i: NEXT i
+  40 IF total>=10 AND total<>3 THEN GO TO 20
+  50 IF total<=100 THEN PRINT AT 1,2;{INK 2}"done";{UDG A}
+  60 DEF FN f(x)=x*x+1
+  70 PRINT FN f(0.5)
+`
+
+	prog, autostart, err := Tokenize(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if autostart != 10 {
+		t.Errorf("want auto-start 10, got %d", autostart)
+	}
+
+	var out bytes.Buffer
+	if err := Detokenize(prog, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	// multi-word keywords are listed with non-breaking spaces
+	want := strings.SplitN(src, "\n", 2)[1]
+	if got := strings.ReplaceAll(out.String(), "\u00a0", " "); got != want {
+		t.Errorf("\nwant:\n%s\ngot:\n%s", want, got)
+	}
+
+	again, _, err := Tokenize(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(prog, again) {
+		t.Errorf("tokenized program changed in round trip")
+	}
+}
+
+//
+func TestTokenizeErrors(t *testing.T) {
+	for _, src := range []string{
+		"20 PRINT\n10 PRINT",
+		"x PRINT",
+		"10000 PRINT",
+		"#autostart x",
+		"10 PRINT \"ü\"",
+		"10 PRINT 1e99",
+	} {
+		if _, _, err := Tokenize(strings.NewReader(src)); err == nil {
+			t.Errorf("want error for %q", src)
+		}
+	}
+}
+
+// num returns a numeric literal followed by its hidden 5 byte form
+func num(lit string, b ...byte) []byte {
+	return cat([]byte(lit), []byte{basicNumber}, b)
+}
+
+//
+func cat(parts ...[]byte) []byte {
+	var ret []byte
+	for _, p := range parts {
+		ret = append(ret, p...)
+	}
+	return ret
+}
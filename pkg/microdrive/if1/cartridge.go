@@ -31,7 +31,13 @@ import (
 
 //
 func NewCartridge() base.Cartridge {
-	ret := &cartridge{base.NewCartridge(client.IF1, SectorCount)}
+	return NewCartridgeOfSize(SectorCount)
+}
+
+// NewCartridgeOfSize creates a cartridge with room for the given number of
+// sectors, to emulate the varying tape lengths of real cartridges
+func NewCartridgeOfSize(sectors int) base.Cartridge {
+	ret := &cartridge{base.NewCartridge(client.IF1, sectors)}
 	ret.RewindAccessIx(false)
 	return ret
 }
@@ -49,9 +55,10 @@ func (c *cartridge) List(w io.Writer) {
 	dir := make(map[string]int)
 	used := 0
 
+	// visit each slot once; cartridges may not be fully formatted
 	for ix := 0; ix < c.SectorCount(); ix++ {
 
-		if sec := c.GetNextSector(); sec != nil {
+		if sec := c.GetSectorAt(ix); sec != nil {
 			if rec := sec.Record(); rec != nil {
 
 				if rec.Flags()&RecordFlagsUsed == 0 {
@@ -86,6 +93,7 @@ func (c *cartridge) List(w io.Writer) {
 		fmt.Fprintf(w, "%-16s%8d\n", f, dir[f])
 	}
 
-	fmt.Fprintf(w, "\n%d of %d sectors used (%dkb free)\n\n",
+	fmt.Fprintf(w, "\n%d of %d sectors used (%dkb free)\n",
 		used, c.SectorCount(), (c.SectorCount()-used)/2)
+	fmt.Fprintf(w, "content hash: %s\n\n", c.Hash())
 }
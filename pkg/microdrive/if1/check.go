@@ -0,0 +1,186 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package if1
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+)
+
+/*
+	Check checks the integrity of this cartridge and returns a report of the
+	issues found. If repair is set, issues that can be safely repaired are
+	fixed. These are check sum errors in sector headers and records, and
+	cartridge names in sector headers that differ from the prevalent name.
+*/
+func (c *cartridge) Check(repair bool) *base.CheckReport {
+
+	rep := base.NewCheckReport()
+	base.CheckSectorNumbers(c, 1, SectorCount, rep)
+	name := base.PrevalentName(c)
+
+	for ix := 0; ix < c.SectorCount(); ix++ {
+
+		sec := c.GetSectorAt(ix)
+		if sec == nil {
+			continue
+		}
+		num := sec.Index()
+
+		hd, ok := sec.Header().(*header)
+		if !ok {
+			rep.Add(base.SeverityError, num, "", "sector has no header")
+			continue
+		}
+
+		if err := hd.Validate(); err != nil {
+			rep.AddRepairable(base.SeverityError, num, "", repair,
+				hd.FixChecksum, "%v", err)
+		}
+
+		if hd.Name() != name {
+			rep.AddRepairable(base.SeverityWarning, num, "", repair,
+				func() error { return hd.setName(name) },
+				"cartridge name in header is %+q, expected %+q",
+				hd.Name(), name)
+		}
+
+		rec, ok := sec.Record().(*record)
+		if !ok {
+			rep.Add(base.SeverityError, num, "", "sector has no record")
+			continue
+		}
+
+		file := strings.TrimRight(translate(rec.Name()), " ")
+
+		if err := rec.Validate(); err != nil {
+			rep.AddRepairable(base.SeverityError, num, file, repair,
+				rec.FixChecksums, "%v", err)
+		}
+
+		if l := rec.Length(); l > len(rec.Data()) {
+			rep.Add(base.SeverityError, num, file,
+				"record length %d exceeds data size %d", l, len(rec.Data()))
+		}
+	}
+
+	if rep.Repaired() > 0 {
+		c.SetModified(true)
+	}
+
+	dir := c.fileRecords()
+	var names []string
+	for n := range dir {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		checkFile(translate(n), dir[n], rep)
+	}
+
+	return rep
+}
+
+//
+func checkFile(name string, recs []*fileRecord, rep *base.CheckReport) {
+
+	sort.SliceStable(recs, func(i, j int) bool {
+		return recs[i].record.Index() < recs[j].record.Index()
+	})
+
+	seen := make(map[int]bool)
+	var nums []int
+	consistent := true
+
+	for _, r := range recs {
+		ix := r.record.Index()
+		if seen[ix] {
+			rep.Add(base.SeverityError, r.sector, name,
+				"record %d appears more than once", ix)
+			consistent = false
+			continue
+		}
+		seen[ix] = true
+		nums = append(nums, ix)
+	}
+
+	if !seen[0] {
+		rep.Add(base.SeverityWarning, -1, name,
+			"record 0 is missing, remaining records are orphaned")
+		consistent = false
+	}
+
+	last := recs[len(recs)-1]
+	var missing []int
+	for _, m := range base.MissingNumbers(nums, last.record.Index()) {
+		if m != 0 {
+			missing = append(missing, m)
+		}
+	}
+	if len(missing) > 0 {
+		rep.Add(base.SeverityError, -1, name, "records %v are missing", missing)
+		consistent = false
+	}
+
+	if last.record.Flags()&RecordFlagsEOF == 0 {
+		rep.Add(base.SeverityError, last.sector, name,
+			"last record %d is not marked as end of file, file is truncated",
+			last.record.Index())
+		consistent = false
+	}
+
+	total := 0
+	for _, r := range recs[:len(recs)-1] {
+		if r.record.Flags()&RecordFlagsEOF != 0 {
+			rep.Add(base.SeverityError, r.sector, name,
+				"record %d is marked as end of file, but is followed by "+
+					"further records", r.record.Index())
+			consistent = false
+		}
+		if l := r.record.Length(); l != RecordDataLength {
+			rep.Add(base.SeverityError, r.sector, name,
+				"record %d has length %d, expected %d",
+				r.record.Index(), l, RecordDataLength)
+			consistent = false
+		}
+		total += r.record.Length()
+	}
+	total += last.record.Length()
+
+	if !consistent {
+		return
+	}
+
+	// SAVEd files carry their length in the file header
+	first := recs[0].record
+	if first.Flags()&RecordFlagsNoPrint != 0 {
+		if d := first.Data(); total >= FileHeaderLength && len(d) > 2 {
+			if l := int(d[1]) | int(d[2])<<8; l != total-FileHeaderLength {
+				rep.Add(base.SeverityWarning, -1, name,
+					"length in file header is %d, but file holds %d bytes",
+					l, total-FileHeaderLength)
+			}
+		}
+	}
+}
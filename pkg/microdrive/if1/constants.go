@@ -27,8 +27,19 @@ const RecordLength = 540
 const RecordLengthMux = RecordLength + 1
 const FormatExtraBytes = 99
 
+// flags byte of sector headers
+const HeaderFlags = 0x01
+
 //
 const RecordFlagsUsed = 0x06
+const RecordFlagsEOF = 0x02     // set in last record of a file
+const RecordFlagsNoPrint = 0x04 // set for files that are not PRINT files
+
+// all but the last record of a file carry this many bytes of data
+const RecordDataLength = 512
+
+// SAVEd files start with a header in their first record
+const FileHeaderLength = 9
 
 // sector numbers range from 1 through 254
 const SectorCount = 254
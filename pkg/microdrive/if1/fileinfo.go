@@ -0,0 +1,272 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package if1
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+)
+
+// types of SAVEd files, as given in the file header
+const (
+	FileTypeProgram    = 0x00
+	FileTypeNumbers    = 0x01
+	FileTypeCharacters = 0x02
+	FileTypeCode       = 0x03
+)
+
+// start address of BASIC programs when Interface 1 is present
+const ProgramStart = 23813
+
+// PRINT files have no file header, so this is not an actual type code
+const FileTypePrint = 0xff
+
+//
+var fileTypeNames = map[byte]string{
+	FileTypeProgram:    "program",
+	FileTypeNumbers:    "numbers",
+	FileTypeCharacters: "characters",
+	FileTypeCode:       "code",
+	FileTypePrint:      "print",
+}
+
+/*
+	FileInfo describes a file stored on cartridge, as given by its file header.
+	The 9 byte file header consists of:
+
+	- type: 0x00 program, 0x01 number array, 0x02 character array, 0x03 code
+	- total length of the data
+	- start address
+	- program length without variables (only for programs)
+	- auto-start line (only for programs)
+
+	All values except type are 16 bit little endian.
+*/
+type FileInfo struct {
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	Length        int    `json:"length"`
+	Start         int    `json:"start"`
+	ProgramLength int    `json:"programLength"`
+	Line          int    `json:"line"`
+}
+
+// NewFileInfo creates the file info for the given file. Files without header
+// are considered PRINT files.
+func NewFileInfo(f base.File) *FileInfo {
+
+	ret := &FileInfo{
+		Name:   f.Name(),
+		Type:   fileTypeNames[FileTypePrint],
+		Length: f.Size(),
+	}
+
+	if h := f.Header(); len(h) >= FileHeaderLength {
+		ret.Type = fileTypeNames[h[0]]
+		if ret.Type == "" {
+			ret.Type = fmt.Sprintf("%d", h[0])
+		}
+		ret.Length = int(binary.LittleEndian.Uint16(h[1:]))
+		ret.Start = int(binary.LittleEndian.Uint16(h[3:]))
+		ret.ProgramLength = int(binary.LittleEndian.Uint16(h[5:]))
+		ret.Line = int(binary.LittleEndian.Uint16(h[7:]))
+	}
+
+	return ret
+}
+
+// TypeCode returns the code of this file's type
+func (fi *FileInfo) TypeCode() (byte, error) {
+	for code, name := range fileTypeNames {
+		if name == fi.Type {
+			return code, nil
+		}
+	}
+	var code byte
+	if _, err := fmt.Sscanf(fi.Type, "%d", &code); err == nil {
+		return code, nil
+	}
+	return 0, fmt.Errorf("unknown file type: %s", fi.Type)
+}
+
+// IsPrint determines whether this is a PRINT file, i.e. a file without header
+func (fi *FileInfo) IsPrint() bool {
+	return fi.Type == fileTypeNames[FileTypePrint]
+}
+
+// Header returns the 9 byte file header for this file info
+func (fi *FileInfo) Header() ([]byte, error) {
+
+	if fi.IsPrint() {
+		return nil, fmt.Errorf("PRINT files have no header")
+	}
+
+	code, err := fi.TypeCode()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]byte, FileHeaderLength)
+	ret[0] = code
+	binary.LittleEndian.PutUint16(ret[1:], uint16(fi.Length))
+	binary.LittleEndian.PutUint16(ret[3:], uint16(fi.Start))
+	binary.LittleEndian.PutUint16(ret[5:], uint16(fi.ProgramLength))
+	binary.LittleEndian.PutUint16(ret[7:], uint16(fi.Line))
+
+	return ret, nil
+}
+
+/*
+	ToTAP converts the given file into TAP format, i.e. a header block followed
+	by a data block. The Microdrive file header is mapped onto the tape header
+	as follows:
+
+	- program: param 1 is the auto-start line, param 2 the program length
+	- code and arrays: param 1 is the start address, param 2 is 32768
+
+	PRINT files cannot be converted, since they have no header.
+*/
+func ToTAP(f base.File) ([]byte, error) {
+
+	fi := NewFileInfo(f)
+	if fi.IsPrint() {
+		return nil, fmt.Errorf("cannot convert PRINT file %+q to TAP", f.Name())
+	}
+
+	code, err := fi.TypeCode()
+	if err != nil {
+		return nil, err
+	}
+
+	p1, p2 := fi.Start, 32768
+	if code == FileTypeProgram {
+		p1, p2 = fi.Line, fi.ProgramLength
+	}
+
+	hd := make([]byte, 17)
+	hd[0] = code
+	copy(hd[1:11], fmt.Sprintf("%-10s", f.Name()))
+	binary.LittleEndian.PutUint16(hd[11:], uint16(len(f.Data())))
+	binary.LittleEndian.PutUint16(hd[13:], uint16(p1))
+	binary.LittleEndian.PutUint16(hd[15:], uint16(p2))
+
+	var b bytes.Buffer
+	writeTAPBlock(&b, 0x00, hd)
+	writeTAPBlock(&b, 0xff, f.Data())
+
+	return b.Bytes(), nil
+}
+
+// writeTAPBlock writes a TAP block with given flag and payload, adding length
+// and check sum
+func writeTAPBlock(b *bytes.Buffer, flag byte, payload []byte) {
+	binary.Write(b, binary.LittleEndian, uint16(len(payload)+2))
+	b.WriteByte(flag)
+	sum := flag
+	for _, p := range payload {
+		sum ^= p
+	}
+	b.Write(payload)
+	b.WriteByte(sum)
+}
+
+// TAPFile is a file found in a TAP file
+type TAPFile struct {
+	Info *FileInfo
+	Data []byte
+}
+
+/*
+	ParseTAP parses the given TAP file content and returns the files found in
+	it. Each file consists of a header block followed by a data block. Data
+	blocks without preceding header are skipped. This is the reverse of ToTAP.
+*/
+func ParseTAP(tap []byte) ([]*TAPFile, error) {
+
+	var ret []*TAPFile
+	var hd []byte
+
+	for pos := 0; pos < len(tap); {
+
+		if pos+2 > len(tap) {
+			return nil, fmt.Errorf("truncated TAP block at offset %d", pos)
+		}
+		l := int(binary.LittleEndian.Uint16(tap[pos:]))
+		pos += 2
+		if l < 2 || pos+l > len(tap) {
+			return nil, fmt.Errorf("invalid TAP block at offset %d", pos-2)
+		}
+
+		block := tap[pos : pos+l]
+		pos += l
+
+		var sum byte
+		for _, b := range block {
+			sum ^= b
+		}
+		if sum != 0 {
+			return nil, fmt.Errorf("TAP block check sum error at offset %d",
+				pos-l-2)
+		}
+
+		flag, payload := block[0], block[1:l-1]
+
+		if flag == 0x00 && len(payload) == 17 {
+			hd = payload
+			continue
+		}
+
+		if hd == nil {
+			continue // headerless block
+		}
+
+		fi := &FileInfo{
+			Name:   strings.TrimRight(string(hd[1:11]), " "),
+			Length: len(payload),
+		}
+		fi.Type = fileTypeNames[hd[0]]
+		if fi.Type == "" {
+			fi.Type = fmt.Sprintf("%d", hd[0])
+		}
+
+		p1 := int(binary.LittleEndian.Uint16(hd[13:]))
+		p2 := int(binary.LittleEndian.Uint16(hd[15:]))
+
+		if hd[0] == FileTypeProgram {
+			fi.Start = ProgramStart
+			fi.Line = p1
+			fi.ProgramLength = p2
+		} else {
+			fi.Start = p1
+			fi.Line = 0xffff
+			fi.ProgramLength = 0xffff
+		}
+
+		ret = append(ret, &TAPFile{Info: fi, Data: payload})
+		hd = nil
+	}
+
+	return ret, nil
+}
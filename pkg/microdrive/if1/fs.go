@@ -0,0 +1,243 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package if1
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/raw"
+)
+
+//
+type fileRecord struct {
+	sector int
+	record base.Record
+}
+
+// Files returns the files stored on this cartridge, sorted by name. Records
+// are assigned to files by name, and ordered by their record number.
+func (c *cartridge) Files() []base.File {
+
+	dir := c.fileRecords()
+
+	var names []string
+	for n := range dir {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var ret []base.File
+	for _, n := range names {
+		ret = append(ret, newFile(n, dir[n]))
+	}
+
+	return ret
+}
+
+// fileRecords returns the used records of this cartridge, grouped by file name
+func (c *cartridge) fileRecords() map[string][]*fileRecord {
+
+	dir := make(map[string][]*fileRecord)
+
+	for ix := 0; ix < c.SectorCount(); ix++ {
+		if sec := c.GetSectorAt(ix); sec != nil {
+			if rec := sec.Record(); rec != nil {
+				if rec.Flags()&RecordFlagsUsed == 0 ||
+					translate(rec.Name()) == "" {
+					continue
+				}
+				name := strings.TrimRight(rec.Name(), " ")
+				dir[name] = append(dir[name],
+					&fileRecord{sector: sec.Index(), record: rec})
+			}
+		}
+	}
+
+	return dir
+}
+
+//
+func newFile(name string, recs []*fileRecord) base.File {
+
+	sort.SliceStable(recs, func(i, j int) bool {
+		return recs[i].record.Index() < recs[j].record.Index()
+	})
+
+	complete := true
+	var data []byte
+	var sectors []int
+
+	for ix, r := range recs {
+		if r.record.Index() != ix {
+			complete = false // missing or duplicate record
+		}
+		l := r.record.Length()
+		d := r.record.Data()
+		if l > len(d) {
+			l = len(d)
+			complete = false
+		}
+		data = append(data, d[:l]...)
+		sectors = append(sectors, r.sector)
+	}
+
+	if len(recs) == 0 || recs[len(recs)-1].record.Flags()&RecordFlagsEOF == 0 {
+		complete = false
+	}
+
+	var header []byte
+	if len(recs) > 0 && recs[0].record.Index() == 0 &&
+		recs[0].record.Flags()&RecordFlagsNoPrint != 0 &&
+		len(data) >= FileHeaderLength {
+		header = data[:FileHeaderLength]
+		data = data[FileHeaderLength:]
+	}
+
+	return base.NewFile(name, header, data, sectors, complete)
+}
+
+// TranslateName translates a file or cartridge name as stored on cartridge
+// into a readable ASCII representation
+func TranslateName(n string) string {
+	return translate(n)
+}
+
+// NewFormattedCartridge creates a cartridge with all sectors formatted and
+// empty, using the given cartridge name
+func NewFormattedCartridge(name string) (base.Cartridge, error) {
+
+	c := NewCartridge()
+	c.SetName(fmt.Sprintf("%-10.10s", name))
+
+	for ix := 0; ix < c.SectorCount(); ix++ {
+
+		var b bytes.Buffer
+		raw.WriteSyncPattern(&b)
+		b.WriteByte(HeaderFlags)
+		b.WriteByte(byte(ix + 1))
+		b.Write([]byte{0, 0})
+		b.WriteString(c.Name())
+		b.WriteByte(0)
+
+		hd, _ := NewHeader(b.Bytes(), false)
+		if err := hd.FixChecksum(); err != nil {
+			return nil, err
+		}
+
+		rec, _ := NewRecord(make([]byte, RecordLength), false)
+		if err := rec.FixChecksums(); err != nil {
+			return nil, err
+		}
+
+		sec, err := base.NewSector(hd, rec)
+		if err != nil {
+			return nil, err
+		}
+		c.SetSectorAt(ix, sec)
+	}
+
+	c.SeekToStart()
+	c.SetModified(false)
+
+	return c, nil
+}
+
+/*
+	AddFile adds a file with given file header and data to this cartridge. For
+	PRINT files, header is nil. The file is stored in unused records, starting
+	with the highest sector number.
+*/
+func (c *cartridge) AddFile(name string, header, data []byte) error {
+
+	if name == "" || len(name) > 10 {
+		return fmt.Errorf("invalid file name %+q", name)
+	}
+	if _, exists := c.fileRecords()[strings.TrimRight(name, " ")]; exists {
+		return fmt.Errorf("file %+q already exists", name)
+	}
+	if header != nil && len(header) != FileHeaderLength {
+		return fmt.Errorf("invalid file header length: %d", len(header))
+	}
+
+	content := append(append([]byte{}, header...), data...)
+	count := (len(content) + RecordDataLength - 1) / RecordDataLength
+	if count == 0 {
+		count = 1
+	}
+
+	free := c.freeRecords()
+	if len(free) < count {
+		return fmt.Errorf(
+			"not enough space for file %+q, need %d sectors, %d free",
+			name, count, len(free))
+	}
+
+	var flags byte
+	if header != nil {
+		flags = RecordFlagsNoPrint
+	}
+
+	for ix := 0; ix < count; ix++ {
+		chunk := content[ix*RecordDataLength:]
+		if len(chunk) > RecordDataLength {
+			chunk = chunk[:RecordDataLength]
+		}
+		f := flags
+		if ix == count-1 {
+			f |= RecordFlagsEOF
+		}
+		if err := free[ix].set(f, ix, name, chunk); err != nil {
+			return err
+		}
+	}
+
+	c.SetModified(true)
+	return nil
+}
+
+// freeRecords returns the unused records of this cartridge, in descending
+// order of their sector numbers
+func (c *cartridge) freeRecords() []*record {
+
+	var secs []base.Sector
+	for ix := 0; ix < c.SectorCount(); ix++ {
+		if sec := c.GetSectorAt(ix); sec != nil {
+			if rec, ok := sec.Record().(*record); ok &&
+				rec.Flags()&RecordFlagsUsed == 0 {
+				secs = append(secs, sec)
+			}
+		}
+	}
+
+	sort.Slice(secs, func(i, j int) bool {
+		return secs[i].Index() > secs[j].Index()
+	})
+
+	ret := make([]*record, len(secs))
+	for ix, s := range secs {
+		ret[ix] = s.Record().(*record)
+	}
+	return ret
+}
@@ -0,0 +1,142 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+package if1
+
+import (
+	"testing"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+)
+
+//
+func TestHashCache(t *testing.T) {
+
+	cart := hashTestCartridge(t)
+	hash := cart.Hash()
+
+	if want := base.CartridgeHash(cart); hash != want {
+		t.Fatalf("cached hash %s differs from computed hash %s", hash, want)
+	}
+
+	// content changes invalidate the cached hash
+	changeRecord(t, cart, 3)
+	if cart.Hash() != hash {
+		t.Fatal("hash changed without cartridge change")
+	}
+	cart.SetModified(true)
+	if changed := cart.Hash(); changed == hash {
+		t.Fatal("hash not updated after SetModified")
+	} else {
+		hash = changed
+	}
+
+	cart.SetSectorAt(5, nil)
+	if cart.Hash() == hash {
+		t.Fatal("hash not updated after SetSectorAt")
+	}
+}
+
+//
+func TestHashPrepareStale(t *testing.T) {
+
+	cart := hashTestCartridge(t)
+	before := cart.PrepareHash()
+
+	cart.SetSectorAt(5, nil)
+	stale := before()
+	if stale == base.CartridgeHash(cart) {
+		t.Fatal("prepared hash includes later change")
+	}
+
+	// hash computed for an outdated cartridge must not be cached
+	if cart.Hash() == stale {
+		t.Fatal("stale hash was cached")
+	}
+
+	// but an up-to-date one is
+	after := cart.PrepareHash()
+	want := after()
+	changeRecord(t, cart, 3)
+	if cart.Hash() != want {
+		t.Fatal("hash not cached")
+	}
+}
+
+//
+func TestHashPosition(t *testing.T) {
+
+	cart := hashTestCartridge(t)
+
+	// same sectors, different positions on the tape and tape length
+	moved := NewCartridgeOfSize(SectorCount + 10)
+	for ix := 0; ix < cart.SectorCount(); ix++ {
+		moved.SetSectorAt(moved.SectorCount()-1-ix, cart.GetSectorAt(ix))
+	}
+
+	if cart.Hash() != moved.Hash() {
+		t.Fatal("hash depends on sector positions")
+	}
+
+	hashes := base.ComputeHashes(moved)
+	if hashes.Cartridge != cart.Hash() {
+		t.Fatal("cartridge hash differs from hash of sector hashes")
+	}
+	if len(hashes.Sectors) != SectorCount {
+		t.Fatalf("want %d sector hashes, got %d",
+			SectorCount, len(hashes.Sectors))
+	}
+
+	changeRecord(t, moved, moved.SectorCount()-1)
+	changed := base.ComputeHashes(moved)
+	diffs := 0
+	for ix, s := range changed.Sectors {
+		if s.Number != hashes.Sectors[ix].Number {
+			t.Fatal("sector order changed")
+		}
+		if s.Hash != hashes.Sectors[ix].Hash {
+			diffs++
+		}
+	}
+	if diffs != 1 {
+		t.Fatalf("want 1 changed sector hash, got %d", diffs)
+	}
+}
+
+//
+func hashTestCartridge(t *testing.T) base.Cartridge {
+	cart, err := NewFormattedCartridge("hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cart
+}
+
+// changeRecord changes the record at ix in place, as the daemon does when a
+// record gets written
+func changeRecord(t *testing.T, cart base.Cartridge, ix int) {
+	sec := cart.GetSectorAt(ix)
+	data := append([]byte{}, sec.Record().Demuxed()...)
+	data[100] ^= 0xff
+	rec, err := NewRecord(data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec.SetRecord(rec)
+}
@@ -79,6 +79,11 @@ func (h *header) Demuxed() []byte {
 	return h.block.Data
 }
 
+//
+func (h *header) Content() []byte {
+	return h.block.GetSlice("header")
+}
+
 //
 func (h *header) mux() {
 	h.muxed = raw.Mux(h.block.Data, false)
@@ -99,6 +104,38 @@ func (h *header) Name() string {
 	return h.block.GetString("name")
 }
 
+// setName sets the cartridge name in this header, padded with spaces, and
+// fixes the check sum
+func (h *header) setName(n string) error {
+	if len(n) > 10 {
+		n = n[:10]
+	}
+	if err := h.block.SetString("name", fmt.Sprintf("%-10s", n)); err != nil {
+		return err
+	}
+	return h.FixChecksum()
+}
+
+// setNumber sets the sector number in this header and fixes the check sum
+func (h *header) setNumber(n int) error {
+	if err := h.block.SetByte("number", byte(n)); err != nil {
+		return err
+	}
+	return h.FixChecksum()
+}
+
+// rebuild resets flags and spares of this header and sets the given name;
+// the sector number is left unchanged
+func (h *header) rebuild(name string) error {
+	if err := h.block.SetByte("flags", HeaderFlags); err != nil {
+		return err
+	}
+	if err := h.block.SetSlice("spares", []byte{0, 0}); err != nil {
+		return err
+	}
+	return h.setName(name)
+}
+
 //
 func (h *header) Checksum() int {
 	return int(h.block.GetByte("checksum"))
@@ -97,6 +97,16 @@ func (r *record) Demuxed() []byte {
 	return r.block.Data
 }
 
+// Content returns record header and data. Long FORMAT records from earlier ROMs
+// are cut down to normal data length, the same as when saving them.
+func (r *record) Content() []byte {
+	data := r.Data()
+	if len(data) > RecordDataLength {
+		data = data[:RecordDataLength]
+	}
+	return append(append([]byte{}, r.block.GetSlice("header")...), data...)
+}
+
 //
 func (r *record) mux() {
 	r.muxed = raw.Mux(r.block.Data, false)
@@ -122,6 +132,47 @@ func (r *record) Name() string {
 	return r.block.GetString("name")
 }
 
+// setUnused marks this record as not being used by any file, and fixes check
+// sums
+func (r *record) setUnused() error {
+	if err := r.block.SetByte("flags", 0); err != nil {
+		return err
+	}
+	if err := r.block.SetByte("number", 0); err != nil {
+		return err
+	}
+	if err := r.block.SetInt("length", 0); err != nil {
+		return err
+	}
+	return r.FixChecksums()
+}
+
+// set sets descriptor and data of this record, and fixes check sums; data is
+// padded with zeros
+func (r *record) set(flags byte, number int, name string, data []byte) error {
+	if err := r.block.SetByte("flags", flags); err != nil {
+		return err
+	}
+	if err := r.block.SetByte("number", byte(number)); err != nil {
+		return err
+	}
+	if err := r.block.SetInt("length", len(data)); err != nil {
+		return err
+	}
+	if err := r.block.SetString("name", fmt.Sprintf("%-10s", name)); err != nil {
+		return err
+	}
+	d := r.Data()
+	if len(data) > len(d) {
+		return fmt.Errorf("data too long for record: %d", len(data))
+	}
+	copy(d, data)
+	for ix := len(data); ix < len(d); ix++ {
+		d[ix] = 0
+	}
+	return r.FixChecksums()
+}
+
 //
 func (r *record) HeaderChecksum() byte {
 	return r.block.GetByte("checksum")
@@ -177,6 +228,14 @@ func (r *record) FixChecksums() error {
 	return r.Validate()
 }
 
+// descriptorIntact determines whether the check sum of the record descriptor
+// is correct
+func (r *record) descriptorIntact() bool {
+	// FORMAT records from earlier ROMs do not use correct checksums
+	return r.block.Length() > RecordLength ||
+		r.HeaderChecksum() == r.CalculateHeaderChecksum()
+}
+
 //
 func (r *record) Validate() error {
 
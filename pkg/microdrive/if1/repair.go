@@ -0,0 +1,191 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package if1
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+)
+
+/*
+	Reconstruct tries to repair structural damage of this cartridge, going
+	beyond fixing check sums, and returns a report of what was done:
+
+	- damaged sector headers are rebuilt, if the sector's record is intact
+	- sectors with damaged header and record descriptor are dropped
+	- records with damaged descriptor are kept if they plausibly belong to a
+	  file, and dropped otherwise
+	- records with damaged data are kept, to preserve file chains
+	- duplicate records of a file are freed
+	- duplicate and invalid sector numbers are reassigned
+*/
+func (c *cartridge) Reconstruct() *base.CheckReport {
+
+	rep := base.NewCheckReport()
+	name := base.PrevalentName(c)
+	files := c.intactFileNames()
+
+	for ix := 0; ix < c.SectorCount(); ix++ {
+
+		sec := c.GetSectorAt(ix)
+		if sec == nil {
+			continue
+		}
+		num := sec.Index()
+
+		hd, _ := sec.Header().(*header)
+		rec, _ := sec.Record().(*record)
+
+		hdOK := hd != nil && hd.Validate() == nil
+		recOK := rec != nil && rec.descriptorIntact()
+
+		if hd == nil || (!hdOK && !recOK) {
+			c.SetSectorAt(ix, nil)
+			rep.AddRepaired(base.SeverityError, num, "",
+				"dropped sector, header and record descriptor are damaged")
+			continue
+		}
+
+		if !hdOK {
+			if hd.Flags() == HeaderFlags && hd.Name() == name {
+				if err := hd.FixChecksum(); err != nil {
+					rep.Add(base.SeverityError, num, "", "%v", err)
+				} else {
+					rep.AddRepaired(base.SeverityWarning, num, "",
+						"fixed header check sum")
+				}
+			} else if err := hd.rebuild(name); err != nil {
+				rep.Add(base.SeverityError, num, "",
+					"cannot rebuild header: %v", err)
+			} else {
+				rep.AddRepaired(base.SeverityWarning, num, "",
+					"rebuilt damaged header")
+			}
+		}
+
+		if rec == nil {
+			rep.Add(base.SeverityError, num, "", "sector has no record")
+			continue
+		}
+
+		raw := strings.TrimRight(rec.Name(), " ")
+		file := translate(raw)
+		used := rec.Flags()&RecordFlagsUsed != 0
+
+		if !recOK {
+			if rec.Flags()&^RecordFlagsUsed == 0 &&
+				rec.Length() <= RecordDataLength && (!used || files[raw]) {
+				if err := rec.FixChecksums(); err != nil {
+					rep.Add(base.SeverityError, num, file, "%v", err)
+				} else {
+					rep.AddRepaired(base.SeverityWarning, num, file,
+						"fixed damaged record descriptor")
+				}
+			} else {
+				c.SetSectorAt(ix, nil)
+				rep.AddRepaired(base.SeverityError, num, file,
+					"dropped sector, record descriptor is damaged")
+			}
+			continue
+		}
+
+		if rec.Validate() != nil {
+			if err := rec.FixChecksums(); err != nil {
+				rep.Add(base.SeverityError, num, file, "%v", err)
+			} else if used {
+				rep.AddRepaired(base.SeverityWarning, num, file,
+					"record %d has damaged data, kept to preserve file chain",
+					rec.Index())
+			} else {
+				rep.AddRepaired(base.SeverityInfo, num, "",
+					"fixed data check sum of unused record")
+			}
+		}
+	}
+
+	c.freeDuplicateRecords(rep)
+
+	base.ReassignSectorNumbers(c, 1, SectorCount,
+		func(s base.Sector, n int) error {
+			if hd, ok := s.Header().(*header); ok {
+				return hd.setNumber(n)
+			}
+			return fmt.Errorf("sector has no header")
+		}, rep)
+
+	if len(rep.Issues) > 0 {
+		c.SetModified(true)
+	}
+
+	return rep
+}
+
+// intactFileNames returns the names of all files for which there is at least
+// one used record with intact descriptor
+func (c *cartridge) intactFileNames() map[string]bool {
+	ret := make(map[string]bool)
+	for ix := 0; ix < c.SectorCount(); ix++ {
+		if sec := c.GetSectorAt(ix); sec != nil {
+			if rec, ok := sec.Record().(*record); ok && rec.descriptorIntact() &&
+				rec.Flags()&RecordFlagsUsed != 0 {
+				ret[strings.TrimRight(rec.Name(), " ")] = true
+			}
+		}
+	}
+	return ret
+}
+
+// freeDuplicateRecords marks all but the first occurrence of a record within
+// a file as unused
+func (c *cartridge) freeDuplicateRecords(rep *base.CheckReport) {
+
+	dir := c.fileRecords()
+	var names []string
+	for n := range dir {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		seen := make(map[int]bool)
+		for _, r := range dir[n] {
+			ix := r.record.Index()
+			if !seen[ix] {
+				seen[ix] = true
+				continue
+			}
+			rec, ok := r.record.(*record)
+			if !ok {
+				continue
+			}
+			if err := rec.setUnused(); err != nil {
+				rep.Add(base.SeverityError, r.sector, translate(n),
+					"cannot free duplicate of record %d: %v", ix, err)
+			} else {
+				rep.AddRepaired(base.SeverityWarning, r.sector, translate(n),
+					"freed duplicate of record %d", ix)
+			}
+		}
+	}
+}
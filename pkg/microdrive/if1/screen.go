@@ -0,0 +1,106 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package if1
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// Spectrum display memory
+const (
+	ScreenStart      = 16384
+	ScreenLength     = 6912
+	ScreenWidth      = 256
+	ScreenHeight     = 192
+	screenAttributes = 6144
+)
+
+// Spectrum colours, normal followed by bright
+var screenPalette = color.Palette{
+	color.RGBA{0x00, 0x00, 0x00, 0xff},
+	color.RGBA{0x00, 0x00, 0xd7, 0xff},
+	color.RGBA{0xd7, 0x00, 0x00, 0xff},
+	color.RGBA{0xd7, 0x00, 0xd7, 0xff},
+	color.RGBA{0x00, 0xd7, 0x00, 0xff},
+	color.RGBA{0x00, 0xd7, 0xd7, 0xff},
+	color.RGBA{0xd7, 0xd7, 0x00, 0xff},
+	color.RGBA{0xd7, 0xd7, 0xd7, 0xff},
+	color.RGBA{0x00, 0x00, 0x00, 0xff},
+	color.RGBA{0x00, 0x00, 0xff, 0xff},
+	color.RGBA{0xff, 0x00, 0x00, 0xff},
+	color.RGBA{0xff, 0x00, 0xff, 0xff},
+	color.RGBA{0x00, 0xff, 0x00, 0xff},
+	color.RGBA{0x00, 0xff, 0xff, 0xff},
+	color.RGBA{0xff, 0xff, 0x00, 0xff},
+	color.RGBA{0xff, 0xff, 0xff, 0xff},
+}
+
+/*
+	DecodeScreen turns the given Spectrum display memory into an image. Pixel
+	rows are interleaved in memory: within each third of the screen, first the
+	top pixel row of all character rows is stored, then the second, and so on.
+	The bitmap is followed by one attribute byte per character cell, holding
+	flash, bright, paper, and ink. Flashing cells are shown in their normal
+	state.
+*/
+func DecodeScreen(scr []byte) (image.Image, error) {
+
+	if len(scr) < ScreenLength {
+		return nil, fmt.Errorf(
+			"screen data too short: %d bytes, need %d", len(scr), ScreenLength)
+	}
+
+	img := image.NewPaletted(
+		image.Rect(0, 0, ScreenWidth, ScreenHeight), screenPalette)
+
+	for y := 0; y < ScreenHeight; y++ {
+		row := (y&0xc0)<<5 | (y&0x07)<<8 | (y&0x38)<<2
+		for col := 0; col < ScreenWidth/8; col++ {
+			attr := scr[screenAttributes+(y/8)*32+col]
+			bright := (attr >> 3) & 0x08
+			ink := attr&0x07 | bright
+			paper := (attr>>3)&0x07 | bright
+			bits := scr[row+col]
+			for b := 0; b < 8; b++ {
+				c := paper
+				if bits&(0x80>>uint(b)) != 0 {
+					c = ink
+				}
+				img.SetColorIndex(col*8+b, y, c)
+			}
+		}
+	}
+
+	return img, nil
+}
+
+// RenderScreen writes the given Spectrum display memory as PNG to w
+func RenderScreen(scr []byte, w io.Writer) error {
+	img, err := DecodeScreen(scr)
+	if err != nil {
+		return err
+	}
+	return png.Encode(w, img)
+}
@@ -31,7 +31,13 @@ import (
 
 //
 func NewCartridge() base.Cartridge {
-	return &cartridge{base.NewCartridge(client.QL, SectorCount)}
+	return NewCartridgeOfSize(SectorCount)
+}
+
+// NewCartridgeOfSize creates a cartridge with room for the given number of
+// sectors, for images of tapes that are shorter or longer than usual
+func NewCartridgeOfSize(sectors int) base.Cartridge {
+	return &cartridge{base.NewCartridge(client.QL, sectors)}
 }
 
 //
@@ -47,8 +53,9 @@ func (c *cartridge) List(w io.Writer) {
 	dir := make(map[string]int)
 	used := c.SectorCount()
 
+	// visit each slot once; cartridges may not be fully formatted
 	for ix := 0; ix < c.SectorCount(); ix++ {
-		if sec := c.GetNextSector(); sec != nil {
+		if sec := c.GetSectorAt(ix); sec != nil {
 			if rec := sec.Record(); rec != nil {
 				if rec.Flags() == 0xfd {
 					used--
@@ -73,6 +80,7 @@ func (c *cartridge) List(w io.Writer) {
 		}
 	}
 
-	fmt.Fprintf(w, "\n%d of %d sectors used (%dkb free)\n\n",
+	fmt.Fprintf(w, "\n%d of %d sectors used (%dkb free)\n",
 		used, c.SectorCount(), (c.SectorCount()-used)/2)
+	fmt.Fprintf(w, "content hash: %s\n\n", c.Hash())
 }
@@ -0,0 +1,267 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package ql
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+)
+
+/*
+	Check checks the integrity of this cartridge and returns a report of the
+	issues found. If repair is set, issues that can be safely repaired are
+	fixed. These are check sum errors in sector headers and records, and
+	cartridge names in sector headers that differ from the prevalent name.
+	Inconsistencies between sector map and record headers are reported, but
+	not repaired.
+*/
+func (c *cartridge) Check(repair bool) *base.CheckReport {
+
+	rep := base.NewCheckReport()
+	sectors := base.CheckSectorNumbers(c, 0, SectorCount-1, rep)
+	name := base.PrevalentName(c)
+
+	files := make(map[int][]*fileBlock)
+	var sectorMap []byte
+
+	for ix := 0; ix < c.SectorCount(); ix++ {
+
+		sec := c.GetSectorAt(ix)
+		if sec == nil {
+			continue
+		}
+		num := sec.Index()
+
+		hd, ok := sec.Header().(*header)
+		if !ok {
+			rep.Add(base.SeverityError, num, "", "sector has no header")
+			continue
+		}
+
+		if err := hd.Validate(); err != nil {
+			rep.AddRepairable(base.SeverityError, num, "", repair,
+				hd.FixChecksum, "%v", err)
+		}
+
+		if hd.Name() != name {
+			rep.AddRepairable(base.SeverityWarning, num, "", repair,
+				func() error { return hd.setName(name) },
+				"cartridge name in header is %+q, expected %+q",
+				hd.Name(), name)
+		}
+
+		rec, ok := sec.Record().(*record)
+		if !ok {
+			rep.Add(base.SeverityError, num, "", "sector has no record")
+			continue
+		}
+
+		if err := rec.Validate(); err != nil {
+			rep.AddRepairable(base.SeverityError, num, "", repair,
+				rec.FixChecksums, "%v", err)
+		}
+
+		switch f := int(rec.Flags()); {
+		case f == FileNumberMap && rec.Index() == 0:
+			if sectorMap != nil {
+				rep.Add(base.SeverityError, num, "",
+					"sector map found more than once")
+			}
+			sectorMap = rec.Data()
+		case f <= FileNumberMaxData:
+			files[f] = append(files[f], &fileBlock{sector: num, record: rec})
+		}
+	}
+
+	if rep.Repaired() > 0 {
+		c.SetModified(true)
+	}
+
+	checkSectorMap(sectorMap, sectors, rep)
+	checkFiles(files, rep)
+
+	return rep
+}
+
+/*
+	checkSectorMap compares the sector map with file and block numbers found in
+	the record headers. The map holds a two byte entry per sector number,
+	consisting of file and block number.
+*/
+func checkSectorMap(sectorMap []byte, sectors map[int][]base.Sector,
+	rep *base.CheckReport) {
+
+	if sectorMap == nil {
+		rep.Add(base.SeverityError, -1, "", "sector map not found")
+		return
+	}
+
+	for num := 0; num < SectorCount && 2*num+1 < len(sectorMap); num++ {
+
+		file := int(sectorMap[2*num])
+		block := int(sectorMap[2*num+1])
+
+		secs, ok := sectors[num]
+		if !ok {
+			if file <= FileNumberMaxData || file == FileNumberMap {
+				rep.Add(base.SeverityWarning, num, "",
+					"sector map lists sector as used by file %d, block %d, "+
+						"but sector is missing", file, block)
+			}
+			continue
+		}
+
+		if file == FileNumberBad {
+			continue
+		}
+
+		for _, sec := range secs {
+			rec := sec.Record()
+			if rec == nil {
+				continue
+			}
+			if f := int(rec.Flags()); f != file ||
+				(f != FileNumberFree && rec.Index() != block) {
+				rep.Add(base.SeverityError, num, "",
+					"sector map lists file %d, block %d, but record is "+
+						"file %d, block %d", file, block, f, rec.Index())
+			}
+		}
+	}
+}
+
+//
+func checkFiles(files map[int][]*fileBlock, rep *base.CheckReport) {
+
+	var nums []int
+	for n := range files {
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+
+	names := make(map[int]string)
+	for _, n := range nums {
+		names[n] = checkFile(n, files[n], rep)
+	}
+
+	// the directory holds a copy of each file's header, at the position given
+	// by the file number; the first entry is the directory's own header
+	dirBlocks, ok := files[FileNumberDirectory]
+	if !ok {
+		rep.Add(base.SeverityError, -1, "", "directory not found")
+		return
+	}
+
+	dir := newFile(FileNumberDirectory, dirBlocks)
+	if !dir.IsComplete() {
+		return
+	}
+
+	listed := make(map[int]bool)
+	data := dir.Data()
+
+	for n := 1; n*FileHeaderLength <= len(data); n++ {
+		entry := data[(n-1)*FileHeaderLength : n*FileHeaderLength]
+		if binary.BigEndian.Uint32(entry) == 0 {
+			continue // deleted or unused
+		}
+		listed[n] = true
+		if _, ok := files[n]; !ok {
+			rep.Add(base.SeverityError, -1, entryName(n, entry),
+				"directory lists file %d, but it has no blocks", n)
+		}
+	}
+
+	for _, n := range nums {
+		if n != FileNumberDirectory && !listed[n] {
+			rep.Add(base.SeverityWarning, -1, names[n],
+				"file %d is not listed in directory, blocks are orphaned", n)
+		}
+	}
+}
+
+// checkFile checks block numbers and length of a file, and returns its name
+func checkFile(num int, blocks []*fileBlock, rep *base.CheckReport) string {
+
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blocks[i].record.Index() < blocks[j].record.Index()
+	})
+
+	name := fmt.Sprintf("#%d", num)
+	seen := make(map[int]bool)
+	var ixs []int
+
+	for _, b := range blocks {
+		ix := b.record.Index()
+		if seen[ix] {
+			rep.Add(base.SeverityError, b.sector, "",
+				"block %d of file %d appears more than once", ix, num)
+			continue
+		}
+		seen[ix] = true
+		ixs = append(ixs, ix)
+	}
+
+	if !seen[0] {
+		rep.Add(base.SeverityWarning, -1, name,
+			"block 0 is missing, remaining blocks are orphaned")
+		return name
+	}
+
+	first := blocks[0].record
+	if num != FileNumberDirectory {
+		name = first.Name()
+	}
+
+	length := first.Length()
+	if length < FileHeaderLength {
+		rep.Add(base.SeverityError, blocks[0].sector, name,
+			"invalid file length %d in file header", length)
+		return name
+	}
+
+	want := (length + BlockLength - 1) / BlockLength
+	last := ixs[len(ixs)-1]
+
+	if missing := base.MissingNumbers(ixs, want-1); len(missing) > 0 {
+		rep.Add(base.SeverityError, -1, name, "blocks %v are missing", missing)
+	}
+
+	if last >= want {
+		rep.Add(base.SeverityWarning, -1, name,
+			"file length %d needs %d blocks, but block %d exists",
+			length, want, last)
+	}
+
+	return name
+}
+
+//
+func entryName(num int, entry []byte) string {
+	l := int(binary.BigEndian.Uint16(entry[14:16]))
+	if 0 < l && l <= 36 {
+		return string(entry[16 : 16+l])
+	}
+	return fmt.Sprintf("#%d", num)
+}
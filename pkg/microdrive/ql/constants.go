@@ -35,6 +35,24 @@ const MaxSectorLength = HeaderLength + RecordLength + FormatExtraBytes
 // sector numbers range from 0 through 254
 const SectorCount = 255
 
+// cartridges may physically hold more sectors than a format numbers, but no
+// more than can be told apart by their single byte sector number
+const MaxSectorCount = 256
+
+// flags byte of sector headers
+const HeaderFlags = 0xff
+
+// file numbers found in record headers
+const FileNumberDirectory = 0x00
+const FileNumberMaxData = 0xef
+const FileNumberMap = 0xf8
+const FileNumberFree = 0xfd
+const FileNumberBad = 0xfe
+
+// each file starts with a header in its first block
+const FileHeaderLength = 64
+const BlockLength = 512
+
 //
 func toQLCheckSum(sum int) int {
 	return (0x0f0f + sum) % 0x10000
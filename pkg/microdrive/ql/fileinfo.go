@@ -0,0 +1,113 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package ql
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+)
+
+// file type of executable programs, as given in the file header
+const FileTypeExecutable = 1
+
+// XTcc trailers are appended to executables on non-QDOS file systems, to
+// preserve the data space size, which would otherwise be lost
+const XTccMagic = "XTcc"
+const XTccLength = 8
+
+/*
+	FileInfo describes a file stored on cartridge, as given by its 64 byte QDOS
+	file header. The complete header is kept as well, so that it can be fully
+	restored.
+*/
+type FileInfo struct {
+	Name      string `json:"name"`
+	Length    int    `json:"length"`
+	Access    byte   `json:"access"`
+	Type      byte   `json:"type"`
+	Dataspace int    `json:"dataspace"`
+	Extra     int    `json:"extra"`
+	Header    []byte `json:"header"`
+}
+
+// NewFileInfo creates the file info for the given file
+func NewFileInfo(f base.File) *FileInfo {
+
+	ret := &FileInfo{
+		Name:   f.Name(),
+		Length: f.Size(),
+	}
+
+	if h := f.Header(); len(h) >= FileHeaderLength {
+		ret.Access = h[4]
+		ret.Type = h[5]
+		ret.Dataspace = int(binary.BigEndian.Uint32(h[6:]))
+		ret.Extra = int(binary.BigEndian.Uint32(h[10:]))
+		ret.Header = make([]byte, FileHeaderLength)
+		copy(ret.Header, h)
+	}
+
+	return ret
+}
+
+// IsExecutable determines whether this file is an executable program
+func (fi *FileInfo) IsExecutable() bool {
+	return fi.Type == FileTypeExecutable
+}
+
+// AppendXTcc appends an XTcc trailer with the given data space size to data
+func AppendXTcc(data []byte, dataspace int) []byte {
+	ret := make([]byte, len(data), len(data)+XTccLength)
+	copy(ret, data)
+	ret = append(ret, XTccMagic...)
+	ds := make([]byte, 4)
+	binary.BigEndian.PutUint32(ds, uint32(dataspace))
+	return append(ret, ds...)
+}
+
+// StripXTcc removes an XTcc trailer from data, if present, and returns the
+// remaining data and the data space size found in the trailer
+func StripXTcc(data []byte) ([]byte, int, bool) {
+	if len(data) < XTccLength {
+		return data, 0, false
+	}
+	t := data[len(data)-XTccLength:]
+	if !bytes.Equal(t[:4], []byte(XTccMagic)) {
+		return data, 0, false
+	}
+	return data[:len(data)-XTccLength], int(binary.BigEndian.Uint32(t[4:])),
+		true
+}
+
+// FileHeader returns the QDOS file header for this file info. If the complete
+// header is present, it is used as the base. Access, type, data space, and
+// extra fields are always set from the file info.
+func (fi *FileInfo) FileHeader() []byte {
+	ret := make([]byte, FileHeaderLength)
+	copy(ret, fi.Header)
+	ret[4] = fi.Access
+	ret[5] = fi.Type
+	binary.BigEndian.PutUint32(ret[6:], uint32(fi.Dataspace))
+	binary.BigEndian.PutUint32(ret[10:], uint32(fi.Extra))
+	return ret
+}
@@ -0,0 +1,331 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package ql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/client"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/raw"
+)
+
+//
+type fileBlock struct {
+	sector int
+	record base.Record
+}
+
+/*
+	Files returns the files stored on this cartridge, sorted by name. Blocks are
+	assigned to files by the file number in their record header, and ordered by
+	block number. The directory file is not included. A file whose first block
+	is missing has no name, so it is given the name #{file number}.
+*/
+func (c *cartridge) Files() []base.File {
+
+	dir := make(map[int][]*fileBlock)
+
+	for ix := 0; ix < c.SectorCount(); ix++ {
+		if sec := c.GetSectorAt(ix); sec != nil {
+			if rec := sec.Record(); rec != nil {
+				num := int(rec.Flags())
+				if num == FileNumberDirectory || num > FileNumberMaxData {
+					continue
+				}
+				dir[num] = append(dir[num],
+					&fileBlock{sector: sec.Index(), record: rec})
+			}
+		}
+	}
+
+	var ret []base.File
+	for num, blocks := range dir {
+		ret = append(ret, newFile(num, blocks))
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name() < ret[j].Name()
+	})
+
+	return ret
+}
+
+//
+func newFile(num int, blocks []*fileBlock) base.File {
+
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blocks[i].record.Index() < blocks[j].record.Index()
+	})
+
+	complete := true
+	var data []byte
+	var sectors []int
+
+	for ix, b := range blocks {
+		if b.record.Index() != ix {
+			complete = false // missing or duplicate block
+		}
+		data = append(data, b.record.Data()...)
+		sectors = append(sectors, b.sector)
+	}
+
+	name := fmt.Sprintf("#%d", num)
+	if len(blocks) > 0 && blocks[0].record.Index() == 0 {
+		name = blocks[0].record.Name()
+	} else {
+		complete = false
+	}
+
+	if len(data) < FileHeaderLength {
+		return base.NewFile(name, nil, data, sectors, false)
+	}
+
+	header := data[:FileHeaderLength]
+
+	// file length in header includes the header itself
+	length := int(binary.BigEndian.Uint32(header))
+	if length < FileHeaderLength || length > len(data) {
+		complete = false
+		length = len(data)
+	}
+
+	return base.NewFile(name, header, data[FileHeaderLength:length], sectors,
+		complete)
+}
+
+/*
+	NewFormattedCartridge creates a cartridge with all sectors formatted, using
+	the given cartridge name. It contains an empty directory and the sector map.
+*/
+func NewFormattedCartridge(name string) (base.Cartridge, error) {
+
+	c := &cartridge{base.NewCartridge(client.QL, SectorCount)}
+	c.RewindAccessIx(false)
+	c.SetName(fmt.Sprintf("%-10.10s", name))
+
+	random := rand.New(rand.NewSource(time.Now().UnixNano())).Intn(0x10000)
+
+	for ix := 0; ix < c.SectorCount(); ix++ {
+
+		var b bytes.Buffer
+		raw.WriteSyncPattern(&b)
+		b.WriteByte(HeaderFlags)
+		b.WriteByte(byte(ix))
+		b.WriteString(c.Name())
+		b.Write([]byte{byte(random), byte(random >> 8)})
+		b.Write([]byte{0, 0})
+
+		hd, _ := NewHeader(b.Bytes(), false)
+		if err := hd.FixChecksum(); err != nil {
+			return nil, err
+		}
+
+		data := make([]byte, MaxSectorLength-HeaderLength)
+		raw.CopySyncPattern(data)
+		raw.CopyDataSyncPattern(data[16:])
+		rec, _ := NewRecord(data, false)
+
+		file := FileNumberFree
+		if ix == 0 {
+			file = FileNumberMap
+		}
+		if err := rec.setDescriptor(file, 0); err != nil {
+			return nil, err
+		}
+
+		sec, err := base.NewSector(hd, rec)
+		if err != nil {
+			return nil, err
+		}
+		c.SetSectorAt(ix, sec)
+	}
+
+	dir := make([]byte, FileHeaderLength)
+	binary.BigEndian.PutUint32(dir, FileHeaderLength)
+	if err := c.writeBlocks(FileNumberDirectory, dir); err != nil {
+		return nil, err
+	}
+
+	c.rebuildSectorMap(base.NewCheckReport())
+	c.SeekToStart()
+	c.SetModified(false)
+
+	return c, nil
+}
+
+/*
+	AddFile adds a file with given QDOS file header and data to this cartridge.
+	If header is nil, a header for a plain data file is used. Length and name
+	in the header are set according to data and name. The file is entered into
+	the directory, and the sector map updated.
+*/
+func (c *cartridge) AddFile(name string, header, data []byte) error {
+
+	if name == "" || len(name) > 36 {
+		return fmt.Errorf("invalid file name %+q", name)
+	}
+	if header == nil {
+		header = make([]byte, FileHeaderLength)
+	}
+	if len(header) != FileHeaderLength {
+		return fmt.Errorf("invalid file header length: %d", len(header))
+	}
+
+	dir, used := c.directory()
+	if dir == nil {
+		return fmt.Errorf("directory not found")
+	}
+
+	num := 1
+	for ; num <= FileNumberMaxData; num++ {
+		if !used[num] {
+			break
+		}
+	}
+	if num > FileNumberMaxData {
+		return fmt.Errorf("no more file numbers available")
+	}
+
+	for n := 1; (n+1)*FileHeaderLength <= len(dir); n++ {
+		entry := dir[n*FileHeaderLength : (n+1)*FileHeaderLength]
+		if binary.BigEndian.Uint32(entry) != 0 && entryName(n, entry) == name {
+			return fmt.Errorf("file %+q already exists", name)
+		}
+	}
+
+	hd := make([]byte, FileHeaderLength)
+	copy(hd, header)
+	binary.BigEndian.PutUint32(hd, uint32(FileHeaderLength+len(data)))
+	binary.BigEndian.PutUint16(hd[14:], uint16(len(name)))
+	copy(hd[16:52], append([]byte(name), make([]byte, 36)...))
+
+	if err := c.writeBlocks(num, append(append([]byte{}, hd...), data...)); err != nil {
+		return err
+	}
+
+	// directory entry of file is at position given by file number
+	for len(dir) < (num+1)*FileHeaderLength {
+		dir = append(dir, make([]byte, FileHeaderLength)...)
+	}
+	copy(dir[num*FileHeaderLength:], hd)
+	binary.BigEndian.PutUint32(dir, uint32(len(dir)))
+
+	if err := c.writeBlocks(FileNumberDirectory, dir); err != nil {
+		return err
+	}
+
+	c.rebuildSectorMap(base.NewCheckReport())
+	c.SetModified(true)
+
+	return nil
+}
+
+// directory returns the content of the directory file including its header,
+// and the file numbers in use
+func (c *cartridge) directory() ([]byte, map[int]bool) {
+
+	var dir []*fileBlock
+	used := make(map[int]bool)
+
+	for ix := 0; ix < c.SectorCount(); ix++ {
+		if sec := c.GetSectorAt(ix); sec != nil {
+			if rec := sec.Record(); rec != nil {
+				num := int(rec.Flags())
+				if num == FileNumberDirectory {
+					dir = append(dir, &fileBlock{sector: sec.Index(), record: rec})
+				} else if num <= FileNumberMaxData {
+					used[num] = true
+				}
+			}
+		}
+	}
+
+	if len(dir) == 0 {
+		return nil, used
+	}
+
+	f := newFile(FileNumberDirectory, dir)
+	return append(append([]byte{}, f.Header()...), f.Data()...), used
+}
+
+/*
+	writeBlocks writes content into the blocks of the given file. Blocks that
+	already exist are overwritten, missing blocks are allocated from free
+	sectors, and surplus blocks are freed.
+*/
+func (c *cartridge) writeBlocks(file int, content []byte) error {
+
+	count := (len(content) + BlockLength - 1) / BlockLength
+	blocks := make(map[int]*record)
+	var free []*record
+
+	for ix := 0; ix < c.SectorCount(); ix++ {
+		if sec := c.GetSectorAt(ix); sec != nil {
+			if rec, ok := sec.Record().(*record); ok {
+				switch int(rec.Flags()) {
+				case file:
+					blocks[rec.Index()] = rec
+				case FileNumberFree:
+					free = append(free, rec)
+				}
+			}
+		}
+	}
+
+	for ix := 0; ix < count; ix++ {
+		if _, ok := blocks[ix]; !ok {
+			if len(free) == 0 {
+				return fmt.Errorf("cartridge full")
+			}
+			blocks[ix] = free[0]
+			free = free[1:]
+		}
+	}
+
+	for ix, rec := range blocks {
+		if ix >= count {
+			if err := rec.setDescriptor(FileNumberFree, 0); err != nil {
+				return err
+			}
+			continue
+		}
+		chunk := content[ix*BlockLength:]
+		if len(chunk) > BlockLength {
+			chunk = chunk[:BlockLength]
+		}
+		d := rec.Data()
+		copy(d, chunk)
+		for p := len(chunk); p < len(d); p++ {
+			d[p] = 0
+		}
+		if err := rec.setDescriptor(file, ix); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -80,6 +80,11 @@ func (h *header) Demuxed() []byte {
 	return h.block.Data
 }
 
+//
+func (h *header) Content() []byte {
+	return h.block.GetSlice("header")
+}
+
 //
 func (h *header) mux() {
 	h.muxed = raw.Mux(h.block.Data, true)
@@ -104,11 +109,43 @@ func (h *header) Name() string {
 	return ""
 }
 
+// setNumber sets the sector number in this header and fixes the check sum
+func (h *header) setNumber(n int) error {
+	if err := h.block.SetByte("number", byte(n)); err != nil {
+		return err
+	}
+	return h.FixChecksum()
+}
+
+// rebuild resets the flags of this header and sets the given name and random
+// value; the sector number is left unchanged
+func (h *header) rebuild(name string, random int) error {
+	if err := h.block.SetByte("flags", HeaderFlags); err != nil {
+		return err
+	}
+	if err := h.block.SetInt("random", random); err != nil {
+		return err
+	}
+	return h.setName(name)
+}
+
 //
 func (h *header) Random() int {
 	return int(h.block.GetInt("random"))
 }
 
+// setName sets the cartridge name in this header, padded with spaces, and
+// fixes the check sum
+func (h *header) setName(n string) error {
+	if len(n) > 10 {
+		n = n[:10]
+	}
+	if err := h.block.SetString("name", fmt.Sprintf("%-10s", n)); err != nil {
+		return err
+	}
+	return h.FixChecksum()
+}
+
 //
 func (h *header) Checksum() int {
 	return int(h.block.GetInt("checksum"))
@@ -83,6 +83,12 @@ func (r *record) Demuxed() []byte {
 	return r.block.Data
 }
 
+// Content returns record header and data, leaving out the extra data written
+// when formatting.
+func (r *record) Content() []byte {
+	return append(append([]byte{}, r.block.GetSlice("header")...), r.Data()...)
+}
+
 //
 func (r *record) mux() {
 	r.muxed = raw.Mux(r.block.Data, true)
@@ -119,6 +125,18 @@ func (r *record) Name() string {
 	return ""
 }
 
+// setDescriptor sets file and block number of this record, and fixes check
+// sums
+func (r *record) setDescriptor(file, block int) error {
+	if err := r.block.SetByte("flags", byte(file)); err != nil {
+		return err
+	}
+	if err := r.block.SetByte("number", byte(block)); err != nil {
+		return err
+	}
+	return r.FixChecksums()
+}
+
 //
 func (r *record) HeaderChecksum() int {
 	return r.block.GetInt("headerChecksum")
@@ -191,6 +209,12 @@ func (r *record) FixChecksums() error {
 	return r.Validate()
 }
 
+// descriptorIntact determines whether the check sum of the record descriptor
+// is correct
+func (r *record) descriptorIntact() bool {
+	return r.HeaderChecksum() == r.CalculateHeaderChecksum()
+}
+
 //
 func (r *record) Validate() error {
 
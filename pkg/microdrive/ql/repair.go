@@ -0,0 +1,329 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package ql
+
+import (
+	"fmt"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+)
+
+/*
+	Reconstruct tries to repair structural damage of this cartridge, going
+	beyond fixing check sums, and returns a report of what was done:
+
+	- damaged sector headers are rebuilt, if the sector's record is intact
+	- sectors with damaged header and record descriptor are marked unusable
+	- damaged record descriptors are restored from the sector map if possible,
+	  otherwise the sector is marked unusable
+	- records with damaged data are kept, to preserve file chains
+	- duplicate blocks of a file are freed
+	- duplicate and invalid sector numbers are reassigned
+	- the sector map is rebuilt from the record descriptors
+*/
+func (c *cartridge) Reconstruct() *base.CheckReport {
+
+	rep := base.NewCheckReport()
+	name := base.PrevalentName(c)
+	random := c.prevalentRandom()
+	sectorMap := c.intactSectorMap()
+
+	for ix := 0; ix < c.SectorCount(); ix++ {
+
+		sec := c.GetSectorAt(ix)
+		if sec == nil {
+			continue
+		}
+		num := sec.Index()
+
+		hd, _ := sec.Header().(*header)
+		rec, _ := sec.Record().(*record)
+
+		hdOK := hd != nil && hd.Validate() == nil
+		recOK := rec != nil && rec.descriptorIntact()
+
+		if hd == nil || rec == nil {
+			c.SetSectorAt(ix, nil)
+			rep.AddRepaired(base.SeverityError, num, "",
+				"dropped incomplete sector")
+			continue
+		}
+
+		if !hdOK && !recOK {
+			c.markUnusable(hd, rec, name, random,
+				"header and record descriptor are damaged", rep)
+			continue
+		}
+
+		if !hdOK {
+			if hd.Flags() == HeaderFlags && hd.Name() == name &&
+				hd.Random() == random {
+				if err := hd.FixChecksum(); err != nil {
+					rep.Add(base.SeverityError, num, "", "%v", err)
+				} else {
+					rep.AddRepaired(base.SeverityWarning, num, "",
+						"fixed header check sum")
+				}
+			} else if err := hd.rebuild(name, random); err != nil {
+				rep.Add(base.SeverityError, num, "",
+					"cannot rebuild header: %v", err)
+			} else {
+				rep.AddRepaired(base.SeverityWarning, num, "",
+					"rebuilt damaged header")
+			}
+		}
+
+		if !recOK {
+			if sectorMap != nil && hdOK && 2*num+1 < len(sectorMap) &&
+				sectorMap[2*num] != FileNumberBad {
+				file := int(sectorMap[2*num])
+				block := int(sectorMap[2*num+1])
+				if err := rec.setDescriptor(file, block); err != nil {
+					rep.Add(base.SeverityError, num, "", "%v", err)
+				} else {
+					rep.AddRepaired(base.SeverityWarning, num, "",
+						"restored record descriptor from sector map: "+
+							"file %d, block %d", file, block)
+				}
+			} else {
+				c.markUnusable(hd, rec, name, random,
+					"record descriptor is damaged", rep)
+			}
+			continue
+		}
+
+		if rec.Validate() != nil {
+			if err := rec.FixChecksums(); err != nil {
+				rep.Add(base.SeverityError, num, "", "%v", err)
+			} else if f := int(rec.Flags()); f <= FileNumberMaxData {
+				rep.AddRepaired(base.SeverityWarning, num, "",
+					"block %d of file %d has damaged data, kept to preserve "+
+						"file chain", rec.Index(), f)
+			} else {
+				rep.AddRepaired(base.SeverityInfo, num, "",
+					"fixed data check sum of record for file %d", f)
+			}
+		}
+	}
+
+	c.freeDuplicateBlocks(rep)
+
+	base.ReassignSectorNumbers(c, 0, SectorCount-1,
+		func(s base.Sector, n int) error {
+			if hd, ok := s.Header().(*header); ok {
+				return hd.setNumber(n)
+			}
+			return fmt.Errorf("sector has no header")
+		}, rep)
+
+	c.rebuildSectorMap(rep)
+
+	if len(rep.Issues) > 0 {
+		c.SetModified(true)
+	}
+
+	return rep
+}
+
+/*
+	markUnusable marks a sector that cannot be recovered as unusable, thereby
+	dropping it from the file system. The sector itself is kept, since QL
+	cartridges need to have all sectors present.
+*/
+func (c *cartridge) markUnusable(hd *header, rec *record, name string,
+	random int, reason string, rep *base.CheckReport) {
+
+	num := hd.Index()
+
+	if err := hd.rebuild(name, random); err != nil {
+		rep.Add(base.SeverityError, num, "", "cannot rebuild header: %v", err)
+		return
+	}
+
+	if err := rec.setDescriptor(FileNumberBad, 0); err != nil {
+		rep.Add(base.SeverityError, num, "",
+			"cannot mark sector as unusable: %v", err)
+		return
+	}
+
+	rep.AddRepaired(base.SeverityError, num, "",
+		"marked sector as unusable, %s", reason)
+}
+
+// prevalentRandom returns the random value found in most intact headers
+func (c *cartridge) prevalentRandom() int {
+
+	count := make(map[int]int)
+	for ix := 0; ix < c.SectorCount(); ix++ {
+		if sec := c.GetSectorAt(ix); sec != nil {
+			if hd, ok := sec.Header().(*header); ok && hd.Validate() == nil {
+				count[hd.Random()]++
+			}
+		}
+	}
+
+	ret, max := 0, 0
+	for r, cnt := range count {
+		if cnt > max || (cnt == max && r < ret) {
+			ret, max = r, cnt
+		}
+	}
+	return ret
+}
+
+// intactSectorMap returns the data of the sector map if the map record is
+// fully intact, nil otherwise
+func (c *cartridge) intactSectorMap() []byte {
+	for ix := 0; ix < c.SectorCount(); ix++ {
+		if sec := c.GetSectorAt(ix); sec != nil {
+			if rec, ok := sec.Record().(*record); ok &&
+				rec.Flags() == FileNumberMap && rec.Index() == 0 &&
+				rec.Validate() == nil {
+				ret := make([]byte, len(rec.Data()))
+				copy(ret, rec.Data())
+				return ret
+			}
+		}
+	}
+	return nil
+}
+
+// freeDuplicateBlocks marks all but the first occurrence of a block within a
+// file as free
+func (c *cartridge) freeDuplicateBlocks(rep *base.CheckReport) {
+
+	seen := make(map[[2]int]bool)
+
+	for ix := 0; ix < c.SectorCount(); ix++ {
+
+		sec := c.GetSectorAt(ix)
+		if sec == nil {
+			continue
+		}
+		rec, ok := sec.Record().(*record)
+		if !ok {
+			continue
+		}
+
+		f := int(rec.Flags())
+		if f > FileNumberMaxData && f != FileNumberMap {
+			continue
+		}
+
+		key := [2]int{f, rec.Index()}
+		if !seen[key] {
+			seen[key] = true
+			continue
+		}
+
+		if err := rec.setDescriptor(FileNumberFree, 0); err != nil {
+			rep.Add(base.SeverityError, sec.Index(), "",
+				"cannot free duplicate of block %d of file %d: %v",
+				key[1], f, err)
+		} else {
+			rep.AddRepaired(base.SeverityWarning, sec.Index(), "",
+				"freed duplicate of block %d of file %d", key[1], f)
+		}
+	}
+}
+
+/*
+	rebuildSectorMap rebuilds the sector map from the record descriptors. The
+	map holds a two byte entry per sector number, consisting of file and block
+	number. Sector numbers without a sector are marked as unusable. If there
+	is no map, it is placed in a free sector.
+*/
+func (c *cartridge) rebuildSectorMap(rep *base.CheckReport) {
+
+	entries := make([]byte, 2*SectorCount)
+	for num := 0; num < SectorCount; num++ {
+		entries[2*num] = FileNumberBad
+	}
+
+	var mapRec, free *record
+	mapNum, freeNum := -1, -1
+
+	for ix := 0; ix < c.SectorCount(); ix++ {
+
+		sec := c.GetSectorAt(ix)
+		if sec == nil {
+			continue
+		}
+		rec, ok := sec.Record().(*record)
+		num := sec.Index()
+		if !ok || num < 0 || num >= SectorCount {
+			continue
+		}
+
+		switch {
+		case rec.Flags() == FileNumberMap && rec.Index() == 0 && mapRec == nil:
+			mapRec, mapNum = rec, num
+		case rec.Flags() == FileNumberFree && free == nil:
+			free, freeNum = rec, num
+		}
+
+		entries[2*num] = rec.Flags()
+		entries[2*num+1] = byte(rec.Index())
+	}
+
+	if mapRec == nil {
+		if free == nil {
+			rep.Add(base.SeverityError, -1, "",
+				"sector map missing, and no free sector to hold it")
+			return
+		}
+		if err := free.setDescriptor(FileNumberMap, 0); err != nil {
+			rep.Add(base.SeverityError, freeNum, "",
+				"cannot create sector map: %v", err)
+			return
+		}
+		mapRec, mapNum = free, freeNum
+		entries[2*mapNum] = FileNumberMap
+		entries[2*mapNum+1] = 0
+		rep.AddRepaired(base.SeverityError, mapNum, "",
+			"sector map missing, created new map in free sector")
+	}
+
+	data := mapRec.Data()
+	if len(data) < len(entries) {
+		rep.Add(base.SeverityError, mapNum, "", "sector map too short")
+		return
+	}
+
+	changed := 0
+	for ix, e := range entries {
+		if data[ix] != e && (ix%2 == 0 || entries[ix-1] != FileNumberFree) {
+			changed++
+		}
+		data[ix] = e
+	}
+
+	if err := mapRec.FixChecksums(); err != nil {
+		rep.Add(base.SeverityError, mapNum, "",
+			"cannot fix sector map check sums: %v", err)
+		return
+	}
+
+	if changed > 0 {
+		rep.AddRepaired(base.SeverityWarning, mapNum, "",
+			"rebuilt sector map, %d entries changed", changed)
+	}
+}
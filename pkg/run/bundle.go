@@ -0,0 +1,142 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package run
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/format"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/format/z80"
+)
+
+//
+func NewBundle() *Bundle {
+
+	b := &Bundle{}
+	b.Runner = *NewRunner(
+		`bundle -o|--output {file} | -d|--drive {drive} [-f|--force]
+       [-n|--name {cartridge name}] [-s|--screen {packed|plain|none}]
+       [-a|--address {address}] {snapshot} ...`,
+		"put several Z80 snapshots onto one cartridge, with a menu",
+		`
+Use the bundle command to convert several 48K Z80 snapshots into a single cartridge.
+The cartridge's run program shows a menu for selecting which snapshot to load. The
+cartridge is either written to a file, or loaded into a drive.`,
+		"", fmt.Sprintf(`- Up to %d snapshots can be bundled, as long as they fit onto the cartridge.
+  Typically, two or three compressed 48K snapshots fit. Leaving out loading
+  screens with --screen none saves a few sectors per snapshot.
+
+- The menu lists snapshots in the order given, using their file names.
+
+`, z80.MaxGames)+runnerHelpEpilogue, b.Run)
+
+	b.AddBaseSettings()
+	b.AddSetting(&b.Output, "output", "o", "", "", "cartridge output file", false)
+	b.AddSetting(&b.Drive, "drive", "d", "", 0, "drive number (1-8)", false)
+	b.AddSetting(&b.Force, "force", "f", "", false,
+		"force replacing modified cartridge in daemon", false)
+	b.AddSetting(&b.Name, "name", "n", "", "GAMES", "cartridge name", false)
+	b.AddSetting(&b.Screen, "screen", "s", "", "",
+		"loading screen mode for all snapshots", false)
+
+	return b
+}
+
+//
+type Bundle struct {
+	//
+	Runner
+	//
+	Output string
+	Drive  int
+	Force  bool
+	Name   string
+	Screen string
+}
+
+//
+func (b *Bundle) Run() error {
+
+	b.ParseSettings()
+
+	if len(b.Args) == 0 {
+		return fmt.Errorf("no snapshots given")
+	}
+
+	if (b.Output == "") == (b.Drive == 0) {
+		return fmt.Errorf("need either an output file or a drive")
+	}
+
+	var games []*z80.Game
+
+	for _, file := range b.Args {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		name := filepath.Base(file)
+		games = append(games, &z80.Game{
+			Name: strings.TrimSuffix(name, filepath.Ext(name)),
+			In:   bufio.NewReader(f),
+		})
+	}
+
+	cart, err := z80.LoadMultiZ80(games, b.Name,
+		&z80.ScreenOptions{Mode: b.Screen})
+	if err != nil {
+		return err
+	}
+
+	if b.Output != "" {
+		return writeCartridge(b.Output, cart)
+	}
+
+	if err := validateDrive(b.Drive); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := format.NewMDR().Write(cart, &buf, nil); err != nil {
+		return err
+	}
+
+	resp, err := b.apiCall("PUT", fmt.Sprintf(
+		"/drive/%d?type=mdr&force=%v", b.Drive, b.Force), false, &buf)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+
+	msg, err := ioutil.ReadAll(resp)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s", msg)
+	return nil
+}
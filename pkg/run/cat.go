@@ -0,0 +1,143 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package run
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/client"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/if1"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/ql"
+)
+
+//
+func NewCat() *Cat {
+
+	c := &Cat{}
+	c.Runner = *NewRunner(
+		`cat -d|--drive {drive} | -i|--input {file} [-a|--address {address}]
+       [-x|--hex] {file name}`,
+		"show content of a file on a cartridge",
+		`
+Use the cat command to show the content of a file stored on a cartridge, either
+in a drive, or in a cartridge file.`,
+		"", `- IF1 BASIC programs are shown as a listing. Hidden numbers are omitted, and colour
+  and position control codes are shown in braces, e.g. {INK 2}. PRINT files are
+  shown as text. All other files are shown as a hex dump.
+
+- QL executables are shown as a hex dump, all other files as they are.
+
+`+runnerHelpEpilogue, c.Run)
+
+	c.AddBaseSettings()
+	c.AddSetting(&c.Drive, "drive", "d", "", 0, "drive number (1-8)", false)
+	c.AddSetting(&c.Input, "input", "i", "", "", "cartridge input file", false)
+	c.AddSetting(&c.Hex, "hex", "x", "", false, "always show hex dump", false)
+
+	return c
+}
+
+//
+type Cat struct {
+	//
+	Runner
+	//
+	Drive int
+	Input string
+	Hex   bool
+}
+
+//
+func (c *Cat) Run() error {
+
+	c.ParseSettings()
+
+	if len(c.Args) != 1 {
+		return fmt.Errorf("need exactly one file name")
+	}
+
+	if (c.Input == "") == (c.Drive == 0) {
+		return fmt.Errorf("need either a drive or an input file")
+	}
+
+	var cart base.Cartridge
+	var err error
+
+	if c.Input != "" {
+		cart, err = readCartridge(c.Input, false, false)
+	} else {
+		cart, err = c.fetchCartridge(c.Drive, "")
+	}
+	if err != nil {
+		return err
+	}
+
+	f := microdrive.FindFile(cart, c.Args[0])
+	if f == nil {
+		return fmt.Errorf("file %+q not found", c.Args[0])
+	}
+	if !f.IsComplete() {
+		fmt.Fprintf(os.Stderr, "warning: file %+q is incomplete\n", f.Name())
+	}
+
+	if c.Hex {
+		return hexDump(f.Data())
+	}
+
+	switch cart.Client() {
+
+	case client.IF1:
+		info := if1.NewFileInfo(f)
+		switch {
+		case info.Type == "program":
+			prog := f.Data()
+			if info.ProgramLength < len(prog) {
+				prog = prog[:info.ProgramLength]
+			}
+			return if1.Detokenize(prog, os.Stdout)
+		case info.IsPrint():
+			_, err := os.Stdout.WriteString(strings.ReplaceAll(
+				string(f.Data()), "\r", "\n"))
+			return err
+		}
+
+	case client.QL:
+		if !ql.NewFileInfo(f).IsExecutable() {
+			_, err := os.Stdout.Write(f.Data())
+			return err
+		}
+	}
+
+	return hexDump(f.Data())
+}
+
+//
+func hexDump(data []byte) error {
+	d := hex.Dumper(os.Stdout)
+	defer d.Close()
+	_, err := d.Write(data)
+	return err
+}
@@ -0,0 +1,136 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package run
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+)
+
+//
+func NewCheck() *Check {
+
+	c := &Check{}
+	c.Runner = *NewRunner(
+		`check -i|--input {file} | -d|--drive {drive} [-a|--address {address}]
+       [-r|--repair [-D|--deep] -o|--output {file}]`,
+		"check integrity of a cartridge",
+		`
+Use the check command to validate the logical structure of a cartridge, either a
+cartridge file, or the cartridge in a drive. Checks include duplicate sector numbers,
+missing records in multi-record files, length fields inconsistent with data, orphaned
+records, cartridge names in sector headers, and for QL, the sector map.`,
+		"", `- Repair only fixes what can be safely repaired, i.e. check sums and cartridge
+  names in sector headers. The repaired cartridge is written to the output file,
+  which may be the same as the input file. A cartridge in a drive is not changed.
+
+- Deep repair additionally tries to reconstruct damaged cartridges: it rebuilds
+  lost sector headers, drops unrecoverable sectors, frees duplicate records,
+  reassigns duplicate and invalid sector numbers, and for QL rebuilds the sector
+  map. Files may still be damaged afterwards, so keep the original file.
+
+- The command exits with a non-zero status if any unrepaired errors remain.
+
+`+runnerHelpEpilogue, c.Run)
+
+	c.AddBaseSettings()
+	c.AddSetting(&c.Input, "input", "i", "", "", "cartridge input file", false)
+	c.AddSetting(&c.Drive, "drive", "d", "", 0, "drive number (1-8)", false)
+	c.AddSetting(&c.Repair, "repair", "r", "", false,
+		"repair issues that can be safely repaired", false)
+	c.AddSetting(&c.Deep, "deep", "D", "", false,
+		"try to reconstruct damaged cartridge, implies repair", false)
+	c.AddSetting(&c.Output, "output", "o", "", "",
+		"output file for repaired cartridge", false)
+
+	return c
+}
+
+//
+type Check struct {
+	//
+	Runner
+	//
+	Input  string
+	Drive  int
+	Repair bool
+	Deep   bool
+	Output string
+}
+
+//
+func (c *Check) Run() error {
+
+	c.ParseSettings()
+
+	if c.Deep {
+		c.Repair = true
+	}
+
+	if (c.Input == "") == (c.Drive == 0) {
+		return fmt.Errorf("need either an input file or a drive to check")
+	}
+
+	if c.Repair && c.Output == "" {
+		return fmt.Errorf("need an output file for the repaired cartridge")
+	}
+
+	var cart base.Cartridge
+	var err error
+
+	if c.Input != "" {
+		cart, err = readCartridge(c.Input, false, false)
+	} else {
+		cart, err = c.fetchCartridge(c.Drive, getExtension(c.Output))
+	}
+	if err != nil {
+		return err
+	}
+
+	repaired := 0
+
+	if c.Deep {
+		fmt.Println("\nrepair report:")
+		recon := cart.Reconstruct()
+		recon.Emit(os.Stdout)
+		repaired += recon.Repaired()
+		fmt.Println("check report:")
+	}
+
+	report := cart.Check(c.Repair)
+	report.Emit(os.Stdout)
+	repaired += report.Repaired()
+
+	if c.Repair && repaired > 0 {
+		if err := writeCartridge(c.Output, cart); err != nil {
+			return err
+		}
+		fmt.Printf("repaired cartridge written to %s\n", c.Output)
+	}
+
+	if n := report.Count(base.SeverityError); n > 0 {
+		return fmt.Errorf("%d unrepaired errors found", n)
+	}
+
+	return nil
+}
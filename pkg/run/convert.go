@@ -0,0 +1,228 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+package run
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/format"
+)
+
+//
+func NewConvert() *Convert {
+
+	c := &Convert{}
+	c.Runner = *NewRunner(
+		`convert -i|--input {file|dir|pattern} -o|--output {file|dir}
+       [-t|--type {format}] [-v|--variant {variant}] [-r|--repair]
+       [-n|--name {cartridge name}] [-f|--force]`,
+		"convert cartridges between formats",
+		`
+Use the convert command to convert cartridge files between formats, without a
+running daemon. Either a single file is converted, or all files matched by the
+input in batch mode.`,
+		"", `- When the input is a directory or a glob pattern (e.g. 'games/*.z80', quote it
+  to keep the shell from expanding it), batch mode is used. The output then has
+  to be a directory, and the target format has to be given with --type. Output
+  files are named after their input files. Archives are accepted as input as
+  long as they contain a single cartridge image.
+
+- For a single input file, the target format is taken from the output file
+  extension, unless --type is given.
+
+- When converting to mdv, --variant selects the sector layout. Default is qlay,
+  with 686 bytes per sector as used by most QL emulators. Use raw for 652 bytes
+  per sector, which is what the QL actually writes. The layout of mdv input
+  files is detected automatically.
+
+- Cartridges can only be converted into a format of their client type, i.e. mdr
+  for IF1 and mdv for QL. Cartridges created from Z80 snapshots can also be
+  converted back into Z80 snapshots.
+
+- The name is given to cartridges converted from Z80 snapshots. If it is not set,
+  the snapshot file name is used.
+
+- Existing output files are only overwritten when using --force.
+
+- Repair currently only recalculates checksums and reverts sector order, if needed.
+
+`+runnerHelpEpilogue, c.Run)
+
+	c.AddBaseSettings()
+	c.AddSetting(&c.Input, "input", "i", "", nil,
+		"input file, directory, or pattern", true)
+	c.AddSetting(&c.Output, "output", "o", "", nil,
+		"output file, or directory in batch mode", true)
+	c.AddSetting(&c.Type, "type", "t", "", "",
+		"target format (mdr, mdv, or z80)", false)
+	c.AddSetting(&c.Variant, "variant", "v", "", "",
+		"variant of target format, if any", false)
+	c.AddSetting(&c.Repair, "repair", "r", "", false,
+		"try to repair cartridges if corrupted", false)
+	c.AddSetting(&c.Name, "name", "n", "", "",
+		"name to give to cartridges converted from Z80 snapshots", false)
+	c.AddSetting(&c.Force, "force", "f", "", false,
+		"overwrite existing output files", false)
+
+	return c
+}
+
+//
+type Convert struct {
+	//
+	Runner
+	//
+	Input   string
+	Output  string
+	Type    string
+	Variant string
+	Repair  bool
+	Name    string
+	Force   bool
+}
+
+//
+func (c *Convert) Run() error {
+
+	c.ParseSettings()
+
+	if c.Type != "" {
+		if _, err := format.NewFormat(c.Type); err != nil {
+			return err
+		}
+	}
+
+	inputs, batch, err := c.inputs()
+	if err != nil {
+		return err
+	}
+
+	if !batch {
+		return c.convert(inputs[0], c.Output)
+	}
+
+	if c.Type == "" {
+		return fmt.Errorf("target format needs to be set in batch mode")
+	}
+	if err := os.MkdirAll(c.Output, 0755); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, in := range inputs {
+		base := filepath.Base(in)
+		out := filepath.Join(c.Output, fmt.Sprintf("%s.%s",
+			strings.TrimSuffix(base, filepath.Ext(base)),
+			strings.ToLower(c.Type)))
+		if err := c.convert(in, out); err != nil {
+			fmt.Printf("%s: %v\n", in, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d conversions failed", failed, len(inputs))
+	}
+	return nil
+}
+
+// inputs determines the input files; batch mode is used if input is a
+// directory or a pattern
+func (c *Convert) inputs() ([]string, bool, error) {
+
+	var pattern string
+
+	if fi, err := os.Stat(c.Input); err == nil {
+		if !fi.IsDir() {
+			return []string{c.Input}, false, nil
+		}
+		pattern = filepath.Join(c.Input, "*")
+
+	} else if strings.ContainsAny(c.Input, "*?[") {
+		pattern = c.Input
+
+	} else {
+		return nil, false, err
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, true, err
+	}
+
+	var ret []string
+	for _, m := range matches {
+		if fi, err := os.Stat(m); err == nil && fi.Mode().IsRegular() {
+			ret = append(ret, m)
+		}
+	}
+
+	if len(ret) == 0 {
+		return nil, true, fmt.Errorf("no input files found for %s", c.Input)
+	}
+	return ret, true, nil
+}
+
+//
+func (c *Convert) convert(in, out string) error {
+
+	_, err := os.Stat(out)
+	exists := err == nil
+	if exists && !c.Force {
+		return fmt.Errorf("output file %s already exists", out)
+	}
+
+	name := c.Name
+	if name == "" {
+		name = strings.ToUpper(strings.TrimSuffix(
+			filepath.Base(in), filepath.Ext(in)))
+	}
+
+	cart, err := readCartridgeWithParams(in, false, c.Repair,
+		map[string]interface{}{"name": name})
+	if err != nil {
+		return err
+	}
+
+	typ := c.Type
+	if typ == "" {
+		typ = getExtension(out)
+	}
+	typ = strings.ToLower(typ)
+
+	if typ != "z80" && typ != cart.Client().DefaultFormat() {
+		return fmt.Errorf("cannot convert %s cartridge to %s",
+			cart.Client(), typ)
+	}
+
+	if err := writeCartridgeAs(out, typ, cart,
+		map[string]interface{}{"variant": c.Variant}); err != nil {
+		if !exists {
+			os.Remove(out)
+		}
+		return err
+	}
+
+	fmt.Printf("%s  ->  %s\n", in, out)
+	return nil
+}
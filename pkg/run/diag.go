@@ -0,0 +1,93 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package run
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/xelalexv/oqtadrive/pkg/daemon"
+)
+
+//
+func NewDiag() *Diag {
+
+	d := &Diag{}
+	d.Runner = *NewRunner(
+		"diag [-d|--drive {drive}] [-c|--count {sectors}] [-a|--address {address}]",
+		"run diagnostics against the adapter",
+		`
+Use the diag command to check the quality of the link between daemon and adapter.
+The daemon measures ping round trip times, sends sectors from the cartridge in the
+given drive to the adapter and verifies what the adapter reflects back, and reports
+the link statistics it collected since it started, together with hints on possible
+problems.`,
+		"", `- The adapter needs to be idle while diagnostics are running, i.e. no drive
+  should be running. The adapter firmware needs to support pings and sector
+  verification from the daemon.
+
+- A formatted cartridge needs to be loaded into the drive used for diagnostics.
+
+`+runnerHelpEpilogue, d.Run)
+
+	d.AddBaseSettings()
+	d.AddSetting(&d.Drive, "drive", "d", "", 1, "drive number (1-8)", false)
+	d.AddSetting(&d.Count, "count", "c", "", 32,
+		fmt.Sprintf("number of sectors to verify (1-%d)", daemon.MaxVerifyCount),
+		false)
+
+	return d
+}
+
+//
+type Diag struct {
+	Runner
+	//
+	Drive int
+	Count int
+}
+
+//
+func (d *Diag) Run() error {
+
+	d.ParseSettings()
+
+	if err := validateDrive(d.Drive); err != nil {
+		return err
+	}
+
+	fmt.Println("\nrunning diagnostics, this could take a moment...")
+
+	resp, err := d.apiCall("PUT",
+		fmt.Sprintf("/diag?drive=%d&count=%d", d.Drive, d.Count), false, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+
+	msg, err := ioutil.ReadAll(resp)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s\n", msg)
+	return nil
+}
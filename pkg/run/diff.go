@@ -0,0 +1,107 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package run
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+)
+
+//
+func NewDiff() *Diff {
+
+	d := &Diff{}
+	d.Runner = *NewRunner(
+		`diff [-d|--drive {drive}] [-a|--address {address}] {file A} [{file B}]`,
+		"compare two cartridges",
+		`
+Use the diff command to compare two cartridges, either two cartridge files, or the
+cartridge in a drive with a cartridge file. The comparison is done on sector level,
+reporting sectors missing on either side and changes to sector headers and records,
+and on file level, reporting added, removed, and changed files.`,
+		"", `- Sectors are compared by their sector number, so differences in sector order
+  are not reported.
+
+- When comparing with a drive, the cartridge in the drive is A, and the given
+  file is B.
+
+`+runnerHelpEpilogue, d.Run)
+
+	d.AddBaseSettings()
+	d.AddSetting(&d.Drive, "drive", "d", "", 0, "drive number (1-8)", false)
+
+	return d
+}
+
+//
+type Diff struct {
+	Runner
+	//
+	Drive int
+}
+
+//
+func (d *Diff) Run() error {
+
+	d.ParseSettings()
+
+	var a, b base.Cartridge
+	var nameA, nameB string
+	var err error
+
+	if d.Drive > 0 {
+		if len(d.Args) != 1 {
+			return fmt.Errorf("need exactly one cartridge file to compare with")
+		}
+		nameA = fmt.Sprintf("drive %d", d.Drive)
+		nameB = d.Args[0]
+		if b, err = readCartridge(nameB, false, false); err != nil {
+			return err
+		}
+		if a, err = d.fetchCartridge(
+			d.Drive, getExtension(nameB)); err != nil {
+			return err
+		}
+
+	} else {
+		if len(d.Args) != 2 {
+			return fmt.Errorf("need exactly two cartridge files to compare")
+		}
+		nameA = d.Args[0]
+		nameB = d.Args[1]
+		if a, err = readCartridge(nameA, false, false); err != nil {
+			return err
+		}
+		if b, err = readCartridge(nameB, false, false); err != nil {
+			return err
+		}
+	}
+
+	diff, err := base.Compare(a, b)
+	if err != nil {
+		return err
+	}
+
+	diff.Emit(os.Stdout, nameA, nameB)
+	return nil
+}
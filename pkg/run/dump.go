@@ -21,12 +21,9 @@
 package run
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 	"os"
-
-	"github.com/xelalexv/oqtadrive/pkg/microdrive/format"
 )
 
 //
@@ -61,18 +58,7 @@ func (d *Dump) Run() error {
 	d.ParseSettings()
 
 	if d.File != "" {
-		f, err := os.Open(d.File)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-
-		form, err := format.NewFormat(getExtension(d.File))
-		if err != nil {
-			return err
-		}
-
-		cart, err := form.Read(bufio.NewReader(f), false, false, nil)
+		cart, err := readCartridge(d.File, false, false)
 		if err != nil {
 			return err
 		}
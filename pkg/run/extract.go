@@ -0,0 +1,201 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package run
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/client"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/if1"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/ql"
+)
+
+// file extension of sidecar files describing extracted files
+const sidecarExtension = ".inf"
+
+//
+func NewExtract() *Extract {
+
+	e := &Extract{}
+	e.Runner = *NewRunner(
+		`extract -i|--input {file} | -d|--drive {drive} -o|--output {dir}
+       [-t|--tap] [-a|--address {address}]`,
+		"extract files from a cartridge",
+		`
+Use the extract command to write the files stored on a cartridge to a directory on
+the host, either from a cartridge file, or from the cartridge in a drive.`,
+		"", `- IF1 files are written as raw data, accompanied by a sidecar file with extension
+  `+sidecarExtension+`, describing type, length, start address, program length, and auto-start
+  line. With --tap, files are instead written as TAP files, except for PRINT files.
+
+- QL files are written as raw data, accompanied by a sidecar file with extension
+  `+sidecarExtension+`, holding the complete QDOS file header. Executables additionally
+  get an XTcc trailer with their data space size, as used by emulators and zip.
+
+- Incomplete files are extracted as far as possible, and reported.
+
+`+runnerHelpEpilogue, e.Run)
+
+	e.AddBaseSettings()
+	e.AddSetting(&e.Input, "input", "i", "", "", "cartridge input file", false)
+	e.AddSetting(&e.Drive, "drive", "d", "", 0, "drive number (1-8)", false)
+	e.AddSetting(&e.Output, "output", "o", "", nil, "output directory", true)
+	e.AddSetting(&e.TAP, "tap", "t", "", false,
+		"write IF1 files in TAP format", false)
+
+	return e
+}
+
+//
+type Extract struct {
+	//
+	Runner
+	//
+	Input  string
+	Drive  int
+	Output string
+	TAP    bool
+}
+
+//
+func (e *Extract) Run() error {
+
+	e.ParseSettings()
+
+	if (e.Input == "") == (e.Drive == 0) {
+		return fmt.Errorf("need either an input file or a drive to extract from")
+	}
+
+	var cart base.Cartridge
+	var err error
+
+	if e.Input != "" {
+		cart, err = readCartridge(e.Input, false, false)
+	} else {
+		cart, err = e.fetchCartridge(e.Drive, "")
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(e.Output, 0755); err != nil {
+		return err
+	}
+
+	for _, f := range cart.Files() {
+
+		var files []string
+		var err error
+
+		switch cart.Client() {
+		case client.IF1:
+			files, err = e.extractIF1(f)
+		case client.QL:
+			files, err = e.extractQL(f)
+		default:
+			err = fmt.Errorf("unsupported client type: %v", cart.Client())
+		}
+
+		if err != nil {
+			return fmt.Errorf("error extracting file %+q: %v", f.Name(), err)
+		}
+
+		incomplete := ""
+		if !f.IsComplete() {
+			incomplete = " (incomplete)"
+		}
+		fmt.Printf("%-16s%8d  ->  %s%s\n", f.Name(), f.Size(),
+			strings.Join(files, ", "), incomplete)
+	}
+
+	return nil
+}
+
+//
+func (e *Extract) extractIF1(f base.File) ([]string, error) {
+
+	name := e.hostPath(if1.TranslateName(f.Name()))
+
+	if e.TAP && f.Header() != nil {
+		data, err := if1.ToTAP(f)
+		if err != nil {
+			return nil, err
+		}
+		name += ".tap"
+		return []string{name}, ioutil.WriteFile(name, data, 0644)
+	}
+
+	return writeWithSidecar(name, f.Data(), if1.NewFileInfo(f))
+}
+
+//
+func (e *Extract) extractQL(f base.File) ([]string, error) {
+
+	name := e.hostPath(f.Name())
+	info := ql.NewFileInfo(f)
+
+	data := f.Data()
+	if info.IsExecutable() && info.Dataspace > 0 {
+		data = ql.AppendXTcc(data, info.Dataspace)
+	}
+
+	return writeWithSidecar(name, data, info)
+}
+
+// hostPath turns a file name from a cartridge into a path in the output
+// directory, replacing characters not suitable for host file names
+func (e *Extract) hostPath(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "" || name == "." || name == ".." {
+		name = "_"
+	}
+	return filepath.Join(e.Output, name)
+}
+
+// writeWithSidecar writes data to the given file, and info as JSON to its
+// sidecar file
+func writeWithSidecar(file string, data []byte,
+	info interface{}) ([]string, error) {
+
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		return nil, err
+	}
+
+	js, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	sidecar := file + sidecarExtension
+	return []string{file, sidecar},
+		ioutil.WriteFile(sidecar, append(js, '\n'), 0644)
+}
@@ -0,0 +1,131 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+package run
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+)
+
+//
+func NewFaults() *Faults {
+
+	f := &Faults{}
+	f.Runner = *NewRunner(
+		`faults [-d|--drive {drive}] [-s|--sector {number|all}]
+       [-k|--kind {unreadable|intermittent|corrupt}] [-p|--probability {p}]
+       [-e|--seed {seed}] [-c|--clear] [-a|--address {address}]`,
+		"emulate bad sectors",
+		`
+Use the faults command to emulate defective sectors in a drive, for testing how
+software handles read errors. Without --kind or --clear, the faults currently
+set for the drive are listed.`,
+		"", `- Faults are identified by sector number. With --sector all, the fault applies to
+  all sectors without a fault of their own, emulating a worn tape.
+
+- Kinds of faults:
+
+    unreadable      sector is never sent, as if it were missing
+    intermittent    sector is not sent with the given probability
+    corrupt         a bit is flipped in the sector's record with the given
+                    probability, causing a checksum error
+
+- Whether a fault occurs is decided randomly. The random source of each drive
+  can be seeded with --seed, so that faults occur in the same way each time the
+  same sectors are accessed in the same order.
+
+- Faults stay in place when loading other cartridges into the drive. Use --clear
+  to remove them, optionally only for the sector given with --sector.
+
+`+runnerHelpEpilogue, f.Run)
+
+	f.AddBaseSettings()
+	f.AddSetting(&f.Drive, "drive", "d", "", 1, "drive number (1-8)", false)
+	f.AddSetting(&f.Sector, "sector", "s", "", "",
+		"sector number, or all", false)
+	f.AddSetting(&f.Kind, "kind", "k", "", "", "kind of fault", false)
+	f.AddSetting(&f.Probability, "probability", "p", "", 1.0,
+		"probability of intermittent or corrupt fault occurring", false)
+	f.AddSetting(&f.Seed, "seed", "e", "", "",
+		"seed for the random source deciding when faults occur", false)
+	f.AddSetting(&f.Clear, "clear", "c", "", false, "remove faults", false)
+
+	return f
+}
+
+//
+type Faults struct {
+	//
+	Runner
+	//
+	Drive       int
+	Sector      string
+	Kind        string
+	Probability float64
+	Seed        string
+	Clear       bool
+}
+
+//
+func (f *Faults) Run() error {
+
+	f.ParseSettings()
+
+	if err := validateDrive(f.Drive); err != nil {
+		return err
+	}
+
+	method := "GET"
+	path := fmt.Sprintf("/drive/%d/faults", f.Drive)
+
+	if f.Clear {
+		if f.Kind != "" {
+			return fmt.Errorf("cannot set and clear a fault at the same time")
+		}
+		method = "DELETE"
+		if f.Sector != "" {
+			path += "?sector=" + url.QueryEscape(f.Sector)
+		}
+
+	} else if f.Kind != "" {
+		if f.Sector == "" {
+			return fmt.Errorf("sector needs to be given when setting a fault")
+		}
+		method = "PUT"
+		path += fmt.Sprintf("?sector=%s&kind=%s&probability=%v&seed=%s",
+			url.QueryEscape(f.Sector), url.QueryEscape(f.Kind),
+			f.Probability, url.QueryEscape(f.Seed))
+	}
+
+	resp, err := f.apiCall(method, path, false, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+
+	msg, err := ioutil.ReadAll(resp)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s", msg)
+	return nil
+}
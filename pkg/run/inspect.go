@@ -0,0 +1,107 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package run
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/format/z80"
+)
+
+//
+func NewInspect() *Inspect {
+
+	i := &Inspect{}
+	i.Runner = *NewRunner(
+		`inspect [-s|--screen {screen}] [-j|--json] {snapshot}`,
+		"check how a Z80 snapshot converts into a cartridge",
+		`
+Use the inspect command to see what loading a Z80 snapshot would yield, without
+loading it into a drive. This shows snapshot version and model, the files that
+would be written with their compressed sizes and sectors needed, and if the
+snapshot cannot be converted, the reason why.`,
+		"", `- The screen setting is the same as for the load command.
+
+- The command fails when the snapshot cannot be converted, or does not fit onto
+  a cartridge.
+
+`+runnerHelpEpilogue, i.Run)
+
+	i.AddBaseSettings()
+	i.AddSetting(&i.Screen, "screen", "s", "", "",
+		"loading screen to use for conversion", false)
+	i.AddSetting(&i.JSON, "json", "j", "", false, "output as JSON", false)
+
+	return i
+}
+
+//
+type Inspect struct {
+	//
+	Runner
+	//
+	Screen string
+	JSON   bool
+}
+
+//
+func (i *Inspect) Run() error {
+
+	i.ParseSettings()
+
+	if len(i.Args) != 1 {
+		return fmt.Errorf("need exactly one snapshot file")
+	}
+
+	screen, err := parseScreenSetting(i.Screen)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(i.Args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stats, err := z80.InspectZ80(bufio.NewReader(f), screen)
+	if err != nil {
+		return err
+	}
+
+	if i.JSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(stats); err != nil {
+			return err
+		}
+	} else {
+		stats.Emit(os.Stdout)
+	}
+
+	if !stats.Fits() {
+		return fmt.Errorf("snapshot cannot be loaded")
+	}
+	return nil
+}
@@ -21,13 +21,12 @@
 package run
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 
-	"github.com/xelalexv/oqtadrive/pkg/microdrive/format"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
 )
 
 //
@@ -35,16 +34,21 @@ func NewList() *List {
 
 	l := &List{}
 	l.Runner = *NewRunner(
-		"ls [-a|--address {address}] [-d|--drive {drive}] [-i|--input {file}]",
+		"ls [-a|--address {address}] [-d|--drive {drive}] [-i|--input {file}] [-s|--sectors]",
 		"get cartridge list from daemon",
 		`
 Use the ls command to get a drive list from the daemon. If a drive number or input
-file is given, the contents of that cartridge is listed.`,
+file is given, the contents of that cartridge is listed. With --sectors, the content
+hashes of the cartridge and each of its sectors are listed instead. Sector hashes
+only depend on sector content, so they can be compared across cartridges to find
+the sectors in which two cartridges differ.`,
 		"", runnerHelpEpilogue, l.Run)
 
 	l.AddBaseSettings()
 	l.AddSetting(&l.File, "input", "i", "", nil, "cartridge file", false)
 	l.AddSetting(&l.Drive, "drive", "d", "", 0, "drive number (1-8)", false)
+	l.AddSetting(&l.Sectors, "sectors", "s", "", false,
+		"list content hashes of cartridge and sectors", false)
 
 	return l
 }
@@ -53,8 +57,9 @@ file is given, the contents of that cartridge is listed.`,
 type List struct {
 	Runner
 	//
-	Drive int
-	File  string
+	Drive   int
+	File    string
+	Sectors bool
 }
 
 //
@@ -63,30 +68,28 @@ func (l *List) Run() error {
 	l.ParseSettings()
 
 	if l.File != "" {
-		f, err := os.Open(l.File)
+		cart, err := readCartridge(l.File, true, false)
 		if err != nil {
 			return err
 		}
-		defer f.Close()
 
-		form, err := format.NewFormat(getExtension(l.File))
-		if err != nil {
-			return err
+		if l.Sectors {
+			base.ComputeHashes(cart).Emit(os.Stdout)
+		} else {
+			cart.List(os.Stdout)
 		}
 
-		cart, err := form.Read(bufio.NewReader(f), true, false, nil)
-		if err != nil {
-			return err
-		}
-
-		cart.List(os.Stdout)
-
 	} else if l.Drive > 0 {
 		if err := validateDrive(l.Drive); err != nil {
 			return err
 		}
 
-		resp, err := l.apiCall("GET", fmt.Sprintf("/drive/%d/list", l.Drive),
+		info := "list"
+		if l.Sectors {
+			info = "hash"
+		}
+
+		resp, err := l.apiCall("GET", fmt.Sprintf("/drive/%d/%s", l.Drive, info),
 			false, nil)
 		if err != nil {
 			return err
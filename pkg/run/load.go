@@ -21,13 +21,17 @@
 package run
 
 import (
-	"bufio"
+	"bytes"
+	"encoding/base64"
 	"fmt"
 	"io/ioutil"
 	"net/url"
-	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/format"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/format/z80"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/if1"
 )
 
 //
@@ -35,11 +39,39 @@ func NewLoad() *Load {
 
 	l := &Load{}
 	l.Runner = *NewRunner(
-		`load [-d|--drive {drive}] -i|--input {file} [-f|--force] [-r|--repair]
-       [-a|--address {address}] [-n|--name {cartridge name}]`,
+		`load [-d|--drive {drive}] -i|--input {file} [-e|--entry {entry}]
+       [-f|--force] [-r|--repair] [-a|--address {address}]
+       [-n|--name {cartridge name}] [-s|--screen {screen}]
+       [-l|--length {sectors}] [-o|--overlay]`,
 		"load cartridge into daemon",
 		"\nUse the load command to load a cartridge into the daemon.",
-		"", `- You can directly load Z80 snapshot files into the daemon.
+		"", `- The format of the cartridge file is detected from its content. Only when
+  detection is not conclusive, the file extension is used.
+
+- You can directly load Z80 snapshot files into the daemon.
+
+- The input file can also be a ZIP, gzip, or gzipped tar archive. If it contains
+  more than one cartridge image, select the one to load with --entry, either by
+  its path inside the archive or just its file name.
+
+- When loading a Z80 snapshot, the loading screen can be controlled with the
+  --screen option:
+
+    packed          compressed screen, this is the default
+    plain           uncompressed screen, loads slower but needs no m/c unpacker
+    none            no loading screen, saves sectors
+    {file}          use the given SCR file as loading screen, compressed
+    plain:{file}    use the given SCR file as loading screen, uncompressed
+
+- A cartridge keeps the tape length of its file, i.e. has room for exactly the
+  sectors contained in it. Use --length to load it into a longer cartridge, which
+  can be useful for cartridges that are to be formatted again.
+
+- With --overlay, the cartridge is loaded in overlay mode. The loaded cartridge
+  then serves as an immutable master, and all writes go into a delta layer on
+  top of it. This keeps master copies pristine, while programs that write to the
+  cartridge, e.g. for saving high scores, still work normally. Use the overlay
+  command to inspect, discard, or commit the changes.
 
 - Repair currently only recalculates checksums and reverts sector order, if needed.
   If the cartridge is really broken, it won't be fixed this way.
@@ -48,6 +80,8 @@ func NewLoad() *Load {
 
 	l.AddBaseSettings()
 	l.AddSetting(&l.File, "input", "i", "", nil, "cartridge input file", true)
+	l.AddSetting(&l.Entry, "entry", "e", "", "",
+		"cartridge image to load when input is an archive", false)
 	l.AddSetting(&l.Drive, "drive", "d", "", 1, "drive number (1-8)", false)
 	l.AddSetting(&l.Force, "force", "f", "", false,
 		"force replacing modified cartridge in daemon", false)
@@ -55,6 +89,12 @@ func NewLoad() *Load {
 		"try to repair cartridge if corrupted", false)
 	l.AddSetting(&l.Name, "name", "n", "", "",
 		"name to give to cartridge when loading a Z80 snapshot", false)
+	l.AddSetting(&l.Screen, "screen", "s", "", "",
+		"loading screen to use when loading a Z80 snapshot", false)
+	l.AddSetting(&l.Length, "length", "l", "", 0,
+		"tape length of cartridge in sectors", false)
+	l.AddSetting(&l.Overlay, "overlay", "o", "", false,
+		"load cartridge in overlay mode", false)
 
 	return l
 }
@@ -64,11 +104,15 @@ type Load struct {
 	//
 	Runner
 	//
-	Drive  int
-	File   string
-	Name   string
-	Force  bool
-	Repair bool
+	Drive   int
+	File    string
+	Entry   string
+	Name    string
+	Screen  string
+	Force   bool
+	Repair  bool
+	Length  int
+	Overlay bool
 }
 
 //
@@ -80,26 +124,46 @@ func (l *Load) Run() error {
 		return err
 	}
 
-	f, err := os.Open(l.File)
+	data, err := ioutil.ReadFile(l.File)
+	if err != nil {
+		return err
+	}
+
+	entry, err := format.Unpack(data, l.Entry)
+	if err != nil {
+		return err
+	}
+
+	file := l.File
+	if entry.Name != "" {
+		file = entry.Name
+	}
+
+	_, cand, err := format.Detect(entry.Data, getExtension(file))
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
 	var name string
 
 	if l.Name != "" {
 		name = l.Name
 	} else {
-		_, name = filepath.Split(l.File)
+		_, name = filepath.Split(file)
 		name = strings.TrimSuffix(strings.ToUpper(name), ".Z80")
 	}
 
+	screen, err := l.screenArgs()
+	if err != nil {
+		return err
+	}
+
 	resp, err := l.apiCall("PUT",
-		fmt.Sprintf("/drive/%d?type=%s&force=%v&repair=%v&name=%s",
-			l.Drive, getExtension(l.File), l.Force, l.Repair,
-			url.QueryEscape(name)),
-		false, bufio.NewReader(f))
+		fmt.Sprintf(
+			"/drive/%d?type=%s&force=%v&repair=%v&name=%s&sectors=%d&overlay=%v%s",
+			l.Drive, cand.Format, l.Force, l.Repair,
+			url.QueryEscape(name), l.Length, l.Overlay, screen),
+		false, bytes.NewReader(entry.Data))
 	if err != nil {
 		return err
 	}
@@ -113,3 +177,53 @@ func (l *Load) Run() error {
 	fmt.Printf("%s", msg)
 	return nil
 }
+
+// screenArgs turns the screen setting into the query args for the load request
+func (l *Load) screenArgs() (string, error) {
+
+	opts, err := parseScreenSetting(l.Screen)
+	if err != nil || opts == nil {
+		return "", err
+	}
+
+	if opts.Data == nil {
+		return fmt.Sprintf("&screen=%s", opts.Mode), nil
+	}
+
+	return fmt.Sprintf("&screen=%s&screendata=%s", opts.Mode,
+		base64.URLEncoding.EncodeToString(opts.Data)), nil
+}
+
+// parseScreenSetting turns a screen setting into screen options for Z80
+// snapshot conversion. The setting is either a screen mode, or a SCR file,
+// optionally prefixed with plain: for storing it uncompressed.
+func parseScreenSetting(setting string) (*z80.ScreenOptions, error) {
+
+	switch setting {
+	case "":
+		return nil, nil
+	case z80.ScreenPacked, z80.ScreenPlain, z80.ScreenNone:
+		return &z80.ScreenOptions{Mode: setting}, nil
+	}
+
+	opts := &z80.ScreenOptions{Mode: z80.ScreenPacked}
+	file := setting
+
+	if strings.HasPrefix(file, z80.ScreenPlain+":") {
+		opts.Mode = z80.ScreenPlain
+		file = strings.TrimPrefix(file, z80.ScreenPlain+":")
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != if1.ScreenLength {
+		return nil, fmt.Errorf(
+			"%s is not a SCR file, length is %d instead of %d",
+			file, len(data), if1.ScreenLength)
+	}
+	opts.Data = data
+
+	return opts, nil
+}
@@ -0,0 +1,257 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package run
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/client"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/if1"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/ql"
+)
+
+//
+func NewMkCart() *MkCart {
+
+	m := &MkCart{}
+	m.Runner = *NewRunner(
+		`mkcart -d|--directory {dir} -o|--output {file} [-c|--client {if1|ql}]
+       [-n|--name {cartridge name}]`,
+		"build a cartridge from a directory of files",
+		`
+Use the mkcart command to create a freshly formatted cartridge, and write all files
+in a directory onto it. This is the reverse of the extract command.`,
+		"", `- IF1: Type, start address, and auto-start line are taken from sidecar files
+  with extension `+sidecarExtension+`, as written by extract. TAP files are unpacked, and
+  each file in them added. Files with extension .bas are taken as BASIC listings
+  in text form, and stored as tokenized programs. The auto-start line can be set
+  with a #autostart {line} comment in the listing. Files without type information
+  are stored as code at address 32768.
+
+- QL: The QDOS file header is taken from sidecar files with extension `+sidecarExtension+`,
+  as written by extract. Files with an XTcc trailer are stored as executables,
+  with the data space size from the trailer. All others are stored as data files.
+
+- If client is not given, it is derived from the output file extension. If no name
+  is given, the name of the directory is used.
+
+`+runnerHelpEpilogue, m.Run)
+
+	m.AddBaseSettings()
+	m.AddSetting(&m.Directory, "directory", "d", "", nil,
+		"directory with files to put onto cartridge", true)
+	m.AddSetting(&m.Output, "output", "o", "", nil, "cartridge output file", true)
+	m.AddSetting(&m.Client, "client", "c", "", "", "client type, if1 or ql", false)
+	m.AddSetting(&m.Name, "name", "n", "", "", "cartridge name", false)
+
+	return m
+}
+
+//
+type MkCart struct {
+	//
+	Runner
+	//
+	Directory string
+	Output    string
+	Client    string
+	Name      string
+}
+
+//
+func (m *MkCart) Run() error {
+
+	m.ParseSettings()
+
+	cl := client.GetClient(m.Client)
+	if m.Client == "" {
+		switch strings.ToLower(getExtension(m.Output)) {
+		case "mdr":
+			cl = client.IF1
+		case "mdv":
+			cl = client.QL
+		}
+	}
+	if cl == client.UNKNOWN {
+		return fmt.Errorf("cannot determine client type, use --client")
+	}
+
+	name := m.Name
+	if name == "" {
+		name = strings.ToUpper(filepath.Base(filepath.Clean(m.Directory)))
+	}
+
+	cart, err := microdrive.NewFormattedCartridge(cl, name)
+	if err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(m.Directory)
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	for _, e := range entries {
+
+		if !e.Mode().IsRegular() ||
+			strings.HasSuffix(e.Name(), sidecarExtension) {
+			continue
+		}
+
+		file := filepath.Join(m.Directory, e.Name())
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		if cl == client.IF1 {
+			err = m.addIF1(cart, file, data)
+		} else {
+			err = m.addQL(cart, file, data)
+		}
+		if err != nil {
+			return fmt.Errorf("error adding %s: %v", file, err)
+		}
+	}
+
+	if err := writeCartridge(m.Output, cart); err != nil {
+		return err
+	}
+
+	cart.List(os.Stdout)
+	return nil
+}
+
+//
+func (m *MkCart) addIF1(cart base.Cartridge, file string, data []byte) error {
+
+	switch strings.ToLower(getExtension(file)) {
+
+	case "bas":
+		prog, line, err := if1.Tokenize(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		info := &if1.FileInfo{
+			Name: strings.TrimSuffix(filepath.Base(file),
+				filepath.Ext(file)),
+			Type:  "program",
+			Start: if1.ProgramStart,
+			Line:  line,
+		}
+		if err := readSidecar(file, info); err != nil {
+			return err
+		}
+		info.Length = len(prog)
+		info.ProgramLength = len(prog)
+		return addIF1File(cart, info, prog)
+
+	case "tap":
+		files, err := if1.ParseTAP(data)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if err := addIF1File(cart, f.Info, f.Data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	info := &if1.FileInfo{
+		Name:          filepath.Base(file),
+		Type:          "code",
+		Start:         32768,
+		ProgramLength: 0xffff,
+		Line:          0xffff,
+	}
+	if err := readSidecar(file, info); err != nil {
+		return err
+	}
+	info.Length = len(data)
+
+	return addIF1File(cart, info, data)
+}
+
+//
+func addIF1File(cart base.Cartridge, info *if1.FileInfo, data []byte) error {
+
+	var header []byte
+	if !info.IsPrint() {
+		var err error
+		if header, err = info.Header(); err != nil {
+			return err
+		}
+	}
+
+	name := info.Name
+	if len(name) > 10 {
+		name = name[:10]
+	}
+	return cart.AddFile(name, header, data)
+}
+
+//
+func (m *MkCart) addQL(cart base.Cartridge, file string, data []byte) error {
+
+	info := &ql.FileInfo{Name: filepath.Base(file)}
+	if err := readSidecar(file, info); err != nil {
+		return err
+	}
+
+	if d, dataspace, ok := ql.StripXTcc(data); ok {
+		data = d
+		info.Type = ql.FileTypeExecutable
+		info.Dataspace = dataspace
+	}
+
+	name := info.Name
+	if len(name) > 36 {
+		name = name[:36]
+	}
+	return cart.AddFile(name, info.FileHeader(), data)
+}
+
+// readSidecar reads the sidecar file for the given file into info, if there
+// is one
+func readSidecar(file string, info interface{}) error {
+	js, err := ioutil.ReadFile(file + sidecarExtension)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(js, info)
+}
@@ -0,0 +1,114 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+package run
+
+import (
+	"fmt"
+	"io/ioutil"
+)
+
+//
+func NewOverlay() *Overlay {
+
+	o := &Overlay{}
+	o.Runner = *NewRunner(
+		`overlay [-d|--drive {drive}] [-c|--commit] [-x|--discard]
+       [-a|--address {address}]`,
+		"inspect, discard, or commit changes of cartridge in overlay mode",
+		`
+Use the overlay command to manage a drive that holds a cartridge loaded in
+overlay mode with 'load --overlay'. In overlay mode, the loaded cartridge serves
+as an immutable master, and all writes go into a delta layer on top of it.
+Without --commit or --discard, the changes made in the delta layer are shown.`,
+		"", `- With --discard, all changes are dropped and the drive is reset to the master.
+
+- With --commit, the changes are merged into the master, i.e. the cartridge in
+  its current state becomes the new master. Note that this only affects the
+  master held by the daemon, not the file the cartridge was loaded from. Use the
+  save command to write the cartridge to a file.
+
+- Both master and delta layer are auto-saved, so overlay mode is kept across
+  daemon restarts. Loading another cartridge into the drive, without --overlay,
+  ends overlay mode.
+
+- Snapshots taken of the drive (see the snapshot command) capture the delta
+  layer. Restoring one keeps the drive in overlay mode, with the same master.
+
+`+runnerHelpEpilogue, o.Run)
+
+	o.AddBaseSettings()
+	o.AddSetting(&o.Drive, "drive", "d", "", 1, "drive number (1-8)", false)
+	o.AddSetting(&o.Commit, "commit", "c", "", false,
+		"commit changes to master", false)
+	o.AddSetting(&o.Discard, "discard", "x", "", false,
+		"discard changes", false)
+
+	return o
+}
+
+//
+type Overlay struct {
+	//
+	Runner
+	//
+	Drive   int
+	Commit  bool
+	Discard bool
+}
+
+//
+func (o *Overlay) Run() error {
+
+	o.ParseSettings()
+
+	if err := validateDrive(o.Drive); err != nil {
+		return err
+	}
+
+	if o.Commit && o.Discard {
+		return fmt.Errorf("only one of commit and discard can be used")
+	}
+
+	method := "GET"
+	path := fmt.Sprintf("/drive/%d/overlay", o.Drive)
+
+	switch {
+	case o.Commit:
+		method = "PUT"
+		path += "/commit"
+	case o.Discard:
+		method = "PUT"
+		path += "/discard"
+	}
+
+	resp, err := o.apiCall(method, path, false, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+
+	msg, err := ioutil.ReadAll(resp)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s", msg)
+	return nil
+}
@@ -21,14 +21,22 @@
 package run
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/xelalexv/oqtadrive/pkg/control"
 	"github.com/xelalexv/oqtadrive/pkg/daemon"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/base"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/client"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/format"
 )
 
 //
@@ -106,6 +114,117 @@ func (r *Runner) apiCall(method, path string, json bool,
 	return nil, fmt.Errorf("%s", msg)
 }
 
+/*
+	fetchCartridge gets the cartridge in the given drive from the daemon, without
+	changing its modified state. If typ is empty, the default format of the
+	client to which the daemon is connected is used for the transfer.
+*/
+func (r *Runner) fetchCartridge(drive int, typ string) (base.Cartridge, error) {
+
+	if err := validateDrive(drive); err != nil {
+		return nil, err
+	}
+
+	if typ == "" {
+		resp, err := r.apiCall("GET", "/status", true, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Close()
+
+		var stat control.Status
+		if err := json.NewDecoder(resp).Decode(&stat); err != nil {
+			return nil, err
+		}
+
+		cl := client.GetClient(stat.Client)
+		if cl == client.UNKNOWN {
+			return nil, fmt.Errorf(
+				"daemon is not connected to adapter, cannot determine format")
+		}
+		typ = cl.DefaultFormat()
+	}
+
+	form, err := format.NewFormat(typ)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.apiCall("GET",
+		fmt.Sprintf("/drive/%d?type=%s&peek=true", drive, typ), false, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+
+	return form.Read(bufio.NewReader(resp), false, false, nil)
+}
+
+// readCartridge reads the cartridge from the given file, using the format
+// detected from the file's content, or if that's not conclusive, the format
+// indicated by the file's extension. If the file is an archive containing a
+// single cartridge image, that image is read.
+func readCartridge(file string, strict, repair bool) (base.Cartridge, error) {
+	return readCartridgeWithParams(file, strict, repair, nil)
+}
+
+// readCartridgeWithParams is the same as readCartridge, but additionally
+// passes params to the format reader
+func readCartridgeWithParams(file string, strict, repair bool,
+	params map[string]interface{}) (base.Cartridge, error) {
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := format.Unpack(data, "")
+	if err != nil {
+		return nil, err
+	}
+
+	ext := getExtension(file)
+	if entry.Name != "" {
+		ext = entry.Format()
+	}
+
+	form, _, err := format.Detect(entry.Data, ext)
+	if err != nil {
+		return nil, err
+	}
+
+	return form.Read(bytes.NewReader(entry.Data), strict, repair, params)
+}
+
+// writeCartridge writes the cartridge to the given file, using the format
+// indicated by the file's extension
+func writeCartridge(file string, cart base.Cartridge) error {
+	return writeCartridgeAs(file, getExtension(file), cart, nil)
+}
+
+// writeCartridgeAs writes the cartridge to the given file, using format typ,
+// and passing params to the format writer
+func writeCartridgeAs(file, typ string, cart base.Cartridge,
+	params map[string]interface{}) error {
+
+	form, err := format.NewFormat(typ)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	if err := form.Write(cart, w, params); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
 //
 func validateDrive(d int) error {
 	if d < 1 || d > daemon.DriveCount {
@@ -24,6 +24,7 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
 )
 
@@ -34,11 +35,20 @@ func NewSave() *Save {
 
 	s := &Save{}
 	s.Runner = *NewRunner(
-		"save [-d|--drive {drive}] -o|--output {file} [-f|--force] [-a|--address {address}]",
+		`save [-d|--drive {drive}] -o|--output {file} [-f|--force] [-a|--address {address}]
+       [-s|--snapshot {number}] [-v|--variant {qlay|raw}]`,
 		"get cartridge from daemon and save",
 		"\nUse the save command to get a cartridge from the daemon and save it to a file.",
 		"", `- The format for saving the file is determined by the file extensions of the
-  given file name. Currently supported formats are .mdr and .mdv
+  given file name. Currently supported formats are .mdr, .mdv, and .z80
+
+- Saving as .z80 only works for cartridges created from Z80 snapshots, and turns
+  them back into snapshots. For cartridges created with the bundle command, use
+  --snapshot to select the snapshot to save.
+
+- When saving as .mdv, --variant selects the sector layout. Default is qlay, with
+  686 bytes per sector as used by most QL emulators. Use raw for 652 bytes per
+  sector, which is what the QL actually writes.
 
 `+runnerHelpEpilogue, s.Run)
 
@@ -47,6 +57,10 @@ func NewSave() *Save {
 	s.AddSetting(&s.Drive, "drive", "d", "", 1, "drive number (1-8)", false)
 	s.AddSetting(&s.Force, "force", "f", "", false,
 		"force overwriting output file", false)
+	s.AddSetting(&s.Snapshot, "snapshot", "s", "", 0,
+		"number of snapshot to save from a bundled cartridge", false)
+	s.AddSetting(&s.Variant, "variant", "v", "", "",
+		"variant of format to save in, if any", false)
 
 	return s
 }
@@ -56,9 +70,11 @@ type Save struct {
 	//
 	Runner
 	//
-	File  string
-	Drive int
-	Force bool
+	File     string
+	Drive    int
+	Force    bool
+	Snapshot int
+	Variant  string
 }
 
 //
@@ -77,8 +93,14 @@ func (s *Save) Run() error {
 		}
 	}
 
+	prefix := ""
+	if s.Snapshot > 0 {
+		prefix = fmt.Sprintf("%d", s.Snapshot)
+	}
+
 	resp, err := s.apiCall("GET",
-		fmt.Sprintf("/drive/%d?type=%s", s.Drive, getExtension(s.File)),
+		fmt.Sprintf("/drive/%d?type=%s&prefix=%s&variant=%s", s.Drive,
+			getExtension(s.File), prefix, url.QueryEscape(s.Variant)),
 		false, nil)
 	if err != nil {
 		return err
@@ -0,0 +1,143 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package run
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+
+	"github.com/xelalexv/oqtadrive/pkg/microdrive"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/client"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/format/z80"
+	"github.com/xelalexv/oqtadrive/pkg/microdrive/if1"
+)
+
+//
+func NewScreen() *Screen {
+
+	s := &Screen{}
+	s.Runner = *NewRunner(
+		`screen -d|--drive {drive} | -i|--input {file} -f|--file {file name}
+       -o|--output {file} [-a|--address {address}]`,
+		"render a Spectrum screen file as PNG",
+		`
+Use the screen command to render a Spectrum SCREEN$ file stored on a cartridge
+as a PNG image. The cartridge can either be in a drive, or in a cartridge file.`,
+		"", `- Besides plain 6912 byte screen files, the compressed screen file S written when
+  converting Z80 snapshots is supported.
+
+- Flashing attributes are rendered in their normal, i.e. non-inverted state.
+
+`+runnerHelpEpilogue, s.Run)
+
+	s.AddBaseSettings()
+	s.AddSetting(&s.Drive, "drive", "d", "", 0, "drive number (1-8)", false)
+	s.AddSetting(&s.Input, "input", "i", "", "", "cartridge input file", false)
+	s.AddSetting(&s.File, "file", "f", "", nil, "name of screen file", true)
+	s.AddSetting(&s.Output, "output", "o", "", nil, "PNG output file", true)
+
+	return s
+}
+
+//
+type Screen struct {
+	//
+	Runner
+	//
+	Drive  int
+	Input  string
+	File   string
+	Output string
+}
+
+//
+func (s *Screen) Run() error {
+
+	s.ParseSettings()
+
+	if (s.Input == "") == (s.Drive == 0) {
+		return fmt.Errorf("need either a drive or an input file")
+	}
+
+	if s.Input != "" {
+		return s.renderLocal()
+	}
+
+	if err := validateDrive(s.Drive); err != nil {
+		return err
+	}
+
+	resp, err := s.apiCall("GET", fmt.Sprintf("/drive/%d/screen?file=%s",
+		s.Drive, url.QueryEscape(s.File)), false, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+
+	f, err := os.Create(s.Output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	out := bufio.NewWriter(f)
+	defer out.Flush()
+
+	_, err = io.Copy(out, resp)
+	return err
+}
+
+//
+func (s *Screen) renderLocal() error {
+
+	cart, err := readCartridge(s.Input, false, false)
+	if err != nil {
+		return err
+	}
+
+	if cart.Client() != client.IF1 {
+		return fmt.Errorf("not a Spectrum cartridge")
+	}
+
+	f := microdrive.FindFile(cart, s.File)
+	if f == nil {
+		return fmt.Errorf("file %+q not found", s.File)
+	}
+
+	scr, err := z80.GetScreen(f)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(s.Output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+
+	return if1.RenderScreen(scr, w)
+}
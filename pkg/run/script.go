@@ -0,0 +1,162 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+package run
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/xelalexv/oqtadrive/pkg/daemon"
+)
+
+//
+func NewScript() *Script {
+
+	s := &Script{}
+	s.Runner = *NewRunner(
+		`script [-i|--input {file}] [-s|--stop] [-a|--address {address}]`,
+		"run script of drive actions in daemon",
+		`
+Use the script command to run a script of timed actions on drives in the daemon,
+e.g. for automating test scenarios involving several cartridges. Without --input
+or --stop, the status of the running script, or the one that ran last, is shown.`,
+		"", `- A script contains one step per line, in the form:
+
+    [at {duration} | wait {duration} | after {n} ops] [force] {action}
+
+  A step with an at trigger runs once the duration has passed since script start,
+  with wait once the duration has passed since the previous step, and with after
+  once n sector operations have occurred in the action's (first) drive since the
+  previous step. Steps without trigger run right after the previous step.
+  Durations are given as e.g. 500ms, 10s, or 2m. Actions are:
+
+    protect {drive} on|off    set/clear write protection
+    load {drive} {file}       load cartridge file into drive
+    unload {drive}            unload drive, leaving a blank cartridge
+    swap {drive} {drive}      swap cartridges between drives
+
+  Empty lines and lines starting with # are ignored.
+
+- Load and unload fail if the cartridge in the drive has been modified, and the
+  script stops. Add force before the action to replace the cartridge regardless,
+  e.g. 'wait 10s force unload 1'. Its changes are then lost.
+
+- Files to load are read by oqtactl and sent to the daemon along with the script,
+  so they need to be present on the host where oqtactl runs. Relative paths are
+  taken as relative to the script file.
+
+- Only one script can run at a time.
+
+Example:
+
+    # swap in disk 2 once the game has been reading for a while
+    load 1 game-disk1.mdr
+    after 400 ops force load 1 game-disk2.mdr
+    wait 30s protect 1 on
+    at 5m unload 1
+
+`+runnerHelpEpilogue, s.Run)
+
+	s.AddBaseSettings()
+	s.AddSetting(&s.Input, "input", "i", "", "", "script file", false)
+	s.AddSetting(&s.Stop, "stop", "s", "", false, "stop running script", false)
+
+	return s
+}
+
+//
+type Script struct {
+	//
+	Runner
+	//
+	Input string
+	Stop  bool
+}
+
+//
+func (s *Script) Run() error {
+
+	s.ParseSettings()
+
+	if s.Input != "" && s.Stop {
+		return fmt.Errorf("cannot run and stop a script at the same time")
+	}
+
+	method := "GET"
+	var body io.Reader
+
+	if s.Stop {
+		method = "DELETE"
+
+	} else if s.Input != "" {
+		f, err := os.Open(s.Input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		script, err := daemon.ParseScript(f)
+		if err != nil {
+			return err
+		}
+
+		upload := &daemon.ScriptUpload{
+			Script: script.String(),
+			Files:  map[string][]byte{},
+		}
+
+		for _, name := range script.LoadFiles() {
+			path := name
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(filepath.Dir(s.Input), path)
+			}
+			if upload.Files[name], err = ioutil.ReadFile(path); err != nil {
+				return err
+			}
+		}
+
+		js, err := json.Marshal(upload)
+		if err != nil {
+			return err
+		}
+
+		method = "PUT"
+		body = bytes.NewReader(js)
+	}
+
+	resp, err := s.apiCall(method, "/script", body != nil, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+
+	msg, err := ioutil.ReadAll(resp)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s", msg)
+	return nil
+}
@@ -0,0 +1,140 @@
+/*
+   OqtaDrive - Sinclair Microdrive emulator
+   Copyright (c) 2021, Alexander Vollschwitz
+
+   This file is part of OqtaDrive.
+
+   OqtaDrive is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   OqtaDrive is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with OqtaDrive. If not, see <http://www.gnu.org/licenses/>.
+*/
+package run
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+)
+
+//
+func NewSnapshot() *Snapshot {
+
+	s := &Snapshot{}
+	s.Runner = *NewRunner(
+		`snapshot [-d|--drive {drive}] [-t|--take] [-r|--restore [-f|--force]]
+       [-c|--clear] [-n|--name {name}] [-a|--address {address}]`,
+		"take and restore snapshots of cartridges",
+		`
+Use the snapshot command to take snapshots of the cartridge in a drive, and roll
+the drive back to them later on, e.g. to save the state of a cartridge before
+running a program that may damage it. Without --take, --restore, or --clear, the
+snapshots taken for the drive are listed.`,
+		"", `- Snapshots are held in the daemon's memory only, and get lost when the daemon
+  stops. They are kept per drive, and stay available when loading a different
+  cartridge into the drive. At most 16 snapshots are kept per drive; when taking
+  more, the oldest one is dropped.
+
+- When taking a snapshot without a name, it is named after the current time.
+  Taking a snapshot with the name of an existing one replaces it.
+
+- Restoring a snapshot replaces the cartridge in the drive. If that has been
+  modified, --force is required, and its changes are lost. The snapshot is kept,
+  so the drive can be restored to it again.
+
+- When the drive is in overlay mode (see the overlay command), it stays in it
+  when restoring a snapshot. The snapshot then replaces the delta layer, while
+  the master remains as it is.
+
+- With --clear, the snapshot given with --name is deleted, or all snapshots of
+  the drive if no name is given.
+
+`+runnerHelpEpilogue, s.Run)
+
+	s.AddBaseSettings()
+	s.AddSetting(&s.Drive, "drive", "d", "", 1, "drive number (1-8)", false)
+	s.AddSetting(&s.Name, "name", "n", "", "", "snapshot name", false)
+	s.AddSetting(&s.Take, "take", "t", "", false, "take snapshot", false)
+	s.AddSetting(&s.Restore, "restore", "r", "", false,
+		"restore drive to snapshot", false)
+	s.AddSetting(&s.Clear, "clear", "c", "", false, "delete snapshots", false)
+	s.AddSetting(&s.Force, "force", "f", "", false,
+		"force restoring over modified cartridge", false)
+
+	return s
+}
+
+//
+type Snapshot struct {
+	//
+	Runner
+	//
+	Drive   int
+	Name    string
+	Take    bool
+	Restore bool
+	Clear   bool
+	Force   bool
+}
+
+//
+func (s *Snapshot) Run() error {
+
+	s.ParseSettings()
+
+	if err := validateDrive(s.Drive); err != nil {
+		return err
+	}
+
+	actions := 0
+	for _, a := range []bool{s.Take, s.Restore, s.Clear} {
+		if a {
+			actions++
+		}
+	}
+	if actions > 1 {
+		return fmt.Errorf("only one of take, restore, and clear can be used")
+	}
+	if s.Force && !s.Restore {
+		return fmt.Errorf("force can only be used with restore")
+	}
+
+	method := "GET"
+	path := fmt.Sprintf("/drive/%d/snapshot", s.Drive)
+
+	switch {
+	case s.Take:
+		method = "PUT"
+	case s.Restore:
+		if s.Name == "" {
+			return fmt.Errorf("name of snapshot to restore is required")
+		}
+		method = "PUT"
+		path += "/restore"
+	case s.Clear:
+		method = "DELETE"
+	}
+
+	resp, err := s.apiCall(method, fmt.Sprintf("%s?name=%s&force=%v",
+		path, url.QueryEscape(s.Name), s.Force), false, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+
+	msg, err := ioutil.ReadAll(resp)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s", msg)
+	return nil
+}
@@ -31,17 +31,25 @@ func NewUnload() *Unload {
 
 	u := &Unload{}
 	u.Runner = *NewRunner(
-		"unload [-d|--drive {drive}] [-f|--force] [-a|--address {address}]",
+		`unload [-d|--drive {drive}] [-f|--force] [-l|--length {sectors}]
+       [-a|--address {address}]`,
 		"unload cartridge from daemon",
 		`
 Use the unload command to unload a cartridge from the daemon, replacing
 it with a blank, unformatted one`,
-		"", runnerHelpEpilogue, u.Run)
+		"", `- Real cartridges differ in tape length, e.g. IF1 cartridges commonly hold 170 to
+  190 sectors. Use --length to set the number of sectors of the blank cartridge,
+  so that formatting it yields a realistic capacity. By default, the blank
+  cartridge has full length, i.e. 254 sectors for IF1, and 255 for QL.
+
+`+runnerHelpEpilogue, u.Run)
 
 	u.AddBaseSettings()
 	u.AddSetting(&u.Drive, "drive", "d", "", 1, "drive number (1-8)", false)
 	u.AddSetting(&u.Force, "force", "f", "", false,
 		"force unloading modified cartridge from daemon", false)
+	u.AddSetting(&u.Length, "length", "l", "", 0,
+		"tape length of blank cartridge in sectors", false)
 
 	return u
 }
@@ -51,8 +59,9 @@ type Unload struct {
 	//
 	Runner
 	//
-	Drive int
-	Force bool
+	Drive  int
+	Force  bool
+	Length int
 }
 
 //
@@ -64,8 +73,9 @@ func (u *Unload) Run() error {
 		return err
 	}
 
-	resp, err := u.apiCall("GET", fmt.Sprintf("/drive/%d/unload?force=%s",
-		u.Drive, strconv.FormatBool(u.Force)), false, nil)
+	resp, err := u.apiCall("GET",
+		fmt.Sprintf("/drive/%d/unload?force=%s&sectors=%d",
+			u.Drive, strconv.FormatBool(u.Force), u.Length), false, nil)
 	if err != nil {
 		return err
 	}